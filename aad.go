@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// ContextAAD canonically encodes a context, such as a record ID,
+// table name or tenant, into associated data. Binding a ciphertext
+// to its context makes it fail to decrypt anywhere else.
+//
+// Entries are sorted by key and each one is encoded the same way the
+// AEAD construction lays out its Poly1305 input:
+//
+// key | pad16 | value | pad16 | len(key) | len(value)
+//
+// Both lengths are 64-bit little endian integers, so distinct
+// contexts never share an encoding. An empty context encodes
+// to no associated data.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.8
+func ContextAAD(context map[string]string) []byte {
+	keys := make([]string, 0, len(context))
+	for k := range context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var aad []byte
+	var pad [16]byte
+
+	for _, k := range keys {
+		v := context[k]
+
+		aad = append(aad, k...)
+		aad = append(aad, pad[:padding16(len(k))]...)
+		aad = append(aad, v...)
+		aad = append(aad, pad[:padding16(len(v))]...)
+		aad = binary.LittleEndian.AppendUint64(aad, uint64(len(k)))
+		aad = binary.LittleEndian.AppendUint64(aad, uint64(len(v)))
+	}
+
+	return aad
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestContextAAD(t *testing.T) {
+	context := map[string]string{
+		"table":  "users",
+		"record": "42",
+		"tenant": "acme",
+	}
+
+	expected := ContextAAD(context)
+
+	// Map iteration order must not leak into the encoding.
+	for i := 0; i < 100; i++ {
+		copied := make(map[string]string)
+		for k, v := range context {
+			copied[k] = v
+		}
+
+		if !bytes.Equal(ContextAAD(copied), expected) {
+			t.Fatalf("encoding is not deterministic")
+		}
+	}
+
+	// Entries are sorted by key: record, table, tenant.
+	if !bytes.HasPrefix(expected, []byte("record")) {
+		t.Fatalf("expected the first entry to be record, found %q", expected[:6])
+	}
+
+	if len(expected)%16 != 0 {
+		t.Fatalf("expected a multiple of 16 bytes, found %d", len(expected))
+	}
+
+	if aad := ContextAAD(nil); len(aad) != 0 {
+		t.Fatalf("expected no associated data for an empty context, found %x", aad)
+	}
+}
+
+func TestContextAADEncoding(t *testing.T) {
+	aad := ContextAAD(map[string]string{"id": "7"})
+
+	expected := "6964" + strings.Repeat("00", 14) +
+		"37" + strings.Repeat("00", 15) +
+		"0200000000000000" + "0100000000000000"
+
+	if actual := hex.EncodeToString(aad); actual != expected {
+		t.Fatalf("encoding mismatch:\nexpected %s\nfound    %s", expected, actual)
+	}
+}
+
+func TestContextAADDistinct(t *testing.T) {
+	// Contexts which concatenate to the same
+	// bytes must still encode differently.
+	contexts := []map[string]string{
+		{},
+		{"": ""},
+		{"a": "bc"},
+		{"ab": "c"},
+		{"abc": ""},
+		{"a": "b", "c": ""},
+		{"a": "b\x00"},
+		{"a": "b", "c": "d"},
+		{"a": "bc", "d": ""},
+		{"a\x00": "b"},
+	}
+
+	seen := make(map[string]int)
+
+	for i, context := range contexts {
+		aad := string(ContextAAD(context))
+
+		if j, ok := seen[aad]; ok {
+			t.Fatalf("contexts %v and %v share an encoding", contexts[j], context)
+		}
+		seen[aad] = i
+	}
+}
+
+func TestContextBinding(t *testing.T) {
+	row1 := ContextAAD(map[string]string{"table": "users", "record": "1"})
+	row2 := ContextAAD(map[string]string{"table": "users", "record": "2"})
+
+	c, err := NewCipher([]byte("context key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyring(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &LocalKeyProvider{Keyring: kr}
+
+	// Every authenticated API must accept its own
+	// context and reject the ciphertext of another row.
+	apis := []struct {
+		name    string
+		encrypt func(plainText, additionalData []byte) ([]byte, error)
+		decrypt func(cipherText, additionalData []byte) ([]byte, error)
+	}{
+		{
+			name: "Envelope",
+			encrypt: func(plainText, additionalData []byte) ([]byte, error) {
+				return c.EncryptEnvelope(ALG_CHACHA20_POLY1305, plainText, additionalData)
+			},
+			decrypt: c.DecryptEnvelope,
+		},
+		{
+			name:    "Authenticated",
+			encrypt: c.EncryptAuthenticatedWithAAD,
+			decrypt: c.DecryptAuthenticatedWithAAD,
+		},
+		{
+			name:    "Keyring",
+			encrypt: kr.EncryptWithAAD,
+			decrypt: kr.DecryptWithAAD,
+		},
+		{
+			name: "EnvelopeEncrypt",
+			encrypt: func(plainText, additionalData []byte) ([]byte, error) {
+				return EnvelopeEncryptWithAAD(p, plainText, additionalData)
+			},
+			decrypt: func(cipherText, additionalData []byte) ([]byte, error) {
+				return EnvelopeDecryptWithAAD(p, cipherText, additionalData)
+			},
+		},
+	}
+
+	for _, api := range apis {
+		cipherText, err := api.encrypt([]byte("secret"), row1)
+		if err != nil {
+			t.Fatalf("%s: %v", api.name, err)
+		}
+
+		plainText, err := api.decrypt(cipherText, row1)
+		if err != nil {
+			t.Fatalf("%s: %v", api.name, err)
+		}
+
+		if string(plainText) != "secret" {
+			t.Fatalf("%s: round trip mismatch", api.name)
+		}
+
+		for _, aad := range [][]byte{row2, nil} {
+			if _, err := api.decrypt(cipherText, aad); err != ErrAuthFailed && err != ErrAADSize {
+				t.Fatalf("%s: expected ErrAuthFailed or ErrAADSize, found %v", api.name, err)
+			}
+		}
+	}
+}
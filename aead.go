@@ -0,0 +1,174 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/wedkarz02/chacha20/pkg/poly"
+)
+
+// PolyKey generates the Poly1305 one-time key from
+// the first 32 bytes of the block with counter 0.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.6
+func (c *Cipher) polyKey() []byte {
+	c.ctr = 0
+	c.resetState()
+	c.block()
+
+	serializedState := c.serialize()
+
+	c.ctr = INITIAL_CTR
+	c.resetState()
+
+	return serializedState[:poly.KEY_SIZE]
+}
+
+// PolyTag computes the Poly1305 tag of the AEAD construction:
+//
+// AAD | pad16 | ciphertext | pad16 | len(AAD) | len(ciphertext)
+//
+// Both lengths are encoded as 64-bit little endian integers.
+// The input is streamed through poly.MAC instead of being copied.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.8
+func polyTag(otk []byte, additionalData []byte, cipherText []byte) ([]byte, error) {
+	m, err := poly.New(otk)
+	if err != nil {
+		return nil, err
+	}
+
+	var pad [poly.BLOCK_SIZE]byte
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(cipherText)))
+
+	m.Write(additionalData)
+	m.Write(pad[:padding16(len(additionalData))])
+	m.Write(cipherText)
+	m.Write(pad[:padding16(len(cipherText))])
+	m.Write(lengths[:])
+
+	return m.Sum(nil), nil
+}
+
+// Padding16 returns the number of zero bytes needed
+// to pad n to a multiple of 16.
+func padding16(n int) int {
+	if n%poly.BLOCK_SIZE == 0 {
+		return 0
+	}
+
+	return poly.BLOCK_SIZE - n%poly.BLOCK_SIZE
+}
+
+// SealChaCha20Poly1305 encrypts and authenticates the plainText
+// with the AEAD_CHACHA20_POLY1305 construction.
+// The returned slice is the cipherText followed by the tag.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.8
+func sealChaCha20Poly1305(key, nonce, plainText, additionalData []byte) ([]byte, error) {
+	c, err := newRawCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	otk := c.polyKey()
+
+	cipherText, err := c.encryptionCore(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := polyTag(otk, additionalData, cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(cipherText, tag...), nil
+}
+
+// OpenChaCha20Poly1305 verifies and decrypts the cipherText
+// followed by the tag created by sealChaCha20Poly1305.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No plainText is released in that case.
+func openChaCha20Poly1305(key, nonce, cipherText, additionalData []byte) ([]byte, error) {
+	if len(cipherText) < TAG_SIZE {
+		return nil, ErrAuthFailed
+	}
+
+	c, err := newRawCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	tag := cipherText[len(cipherText)-TAG_SIZE:]
+	cipherText = cipherText[:len(cipherText)-TAG_SIZE]
+
+	expected, err := polyTag(c.polyKey(), additionalData, cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	return c.encryptionCore(cipherText)
+}
+
+// Seal encrypts and authenticates the plainText with the cipher key
+// and returns the cipherText followed by the tag. A 12-byte nonce
+// selects ChaCha20-Poly1305 and a 24-byte nonce XChaCha20-Poly1305.
+//
+// The caller is responsible for never reusing a nonce with the same key.
+// Seal doesn't change the cipher state and is safe for concurrent use.
+func (c *Cipher) Seal(nonce, plainText, additionalData []byte) ([]byte, error) {
+	switch len(nonce) {
+	case NONCE_SIZE:
+		return sealChaCha20Poly1305(c.Key, nonce, plainText, additionalData)
+	case XNONCE_SIZE:
+		return sealXChaCha20Poly1305(c.Key, nonce, plainText, additionalData)
+	}
+
+	return nil, ErrNonceSize
+}
+
+// Open verifies and decrypts the cipherText followed by the tag
+// created by Seal with the same nonce and additionalData.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No plainText is released in that case.
+func (c *Cipher) Open(nonce, cipherText, additionalData []byte) ([]byte, error) {
+	switch len(nonce) {
+	case NONCE_SIZE:
+		return openChaCha20Poly1305(c.Key, nonce, cipherText, additionalData)
+	case XNONCE_SIZE:
+		return openXChaCha20Poly1305(c.Key, nonce, cipherText, additionalData)
+	}
+
+	return nil, ErrNonceSize
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+var aeadPlainText = []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+func TestPolyKey(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#section-2.6.2
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := testutil.DecodeHex(t, "000000000001020304050607")
+	expected := testutil.DecodeHex(t, "8ad5a08b905f81cc815040274ab29471a833b637e3fd0da508dbb8e2fdd1a646")
+
+	c, err := newRawCipher(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := c.polyKey(); !bytes.Equal(actual, expected) {
+		t.Fatalf("poly key mismatch: expected %x, found %x", expected, actual)
+	}
+}
+
+func TestChaCha20Poly1305(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#section-2.8.2
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := testutil.DecodeHex(t, "070000004041424344454647")
+	aad := testutil.DecodeHex(t, "50515253c0c1c2c3c4c5c6c7")
+	expected := testutil.DecodeHex(t, ""+
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6"+
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36"+
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc"+
+		"3ff4def08e4b7a9de576d26586cec64b6116"+
+		"1ae10b594f09e26a7e902ecbd0600691")
+
+	sealed, err := sealChaCha20Poly1305(key, nonce, aeadPlainText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sealed, expected) {
+		t.Fatalf("seal mismatch:\nexpected %x\nfound    %x", expected, sealed)
+	}
+
+	opened, err := openChaCha20Poly1305(key, nonce, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, aeadPlainText) {
+		t.Fatalf("open mismatch: expected %q, found %q", aeadPlainText, opened)
+	}
+
+	sealed[0] ^= 0x01
+	if _, err := openChaCha20Poly1305(key, nonce, sealed, aad); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for modified ciphertext, found %v", err)
+	}
+}
+
+func TestHChaCha20(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha#section-2.2.1
+	key := testutil.DecodeHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce := testutil.DecodeHex(t, "000000090000004a0000000031415927")
+	expected := testutil.DecodeHex(t, "82413b4227b27bfed30e42508a877d73a0f9e4d58a74a853c12ec41326d3ecdc")
+
+	subKey, err := hChaCha20(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(subKey, expected) {
+		t.Fatalf("subkey mismatch: expected %x, found %x", expected, subKey)
+	}
+}
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha#appendix-A.3.1
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := testutil.DecodeHex(t, "404142434445464748494a4b4c4d4e4f5051525354555657")
+	aad := testutil.DecodeHex(t, "50515253c0c1c2c3c4c5c6c7")
+	expected := testutil.DecodeHex(t, ""+
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb"+
+		"731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b452"+
+		"2f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff9"+
+		"21f9664c97637da9768812f615c68b13b52e"+
+		"c0875924c1c7987947deafd8780acf49")
+
+	sealed, err := sealXChaCha20Poly1305(key, nonce, aeadPlainText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sealed, expected) {
+		t.Fatalf("seal mismatch:\nexpected %x\nfound    %x", expected, sealed)
+	}
+
+	opened, err := openXChaCha20Poly1305(key, nonce, sealed, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, aeadPlainText) {
+		t.Fatalf("open mismatch: expected %q, found %q", aeadPlainText, opened)
+	}
+
+	if _, err := openXChaCha20Poly1305(key, nonce, sealed, aad[1:]); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for modified associated data, found %v", err)
+	}
+}
+
+func TestCipherSeal(t *testing.T) {
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+
+	c, err := newRawCipher(key, make([]byte, NONCE_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The nonce size selects the construction.
+	for _, nonce := range [][]byte{make([]byte, NONCE_SIZE), make([]byte, XNONCE_SIZE)} {
+		sealed, err := c.Seal(nonce, aeadPlainText, []byte("aad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected []byte
+		if len(nonce) == NONCE_SIZE {
+			expected, _ = sealChaCha20Poly1305(key, nonce, aeadPlainText, []byte("aad"))
+		} else {
+			expected, _ = sealXChaCha20Poly1305(key, nonce, aeadPlainText, []byte("aad"))
+		}
+
+		if !bytes.Equal(sealed, expected) {
+			t.Fatalf("%d-byte nonce: seal mismatch", len(nonce))
+		}
+
+		opened, err := c.Open(nonce, sealed, []byte("aad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(opened, aeadPlainText) {
+			t.Fatalf("%d-byte nonce: open mismatch", len(nonce))
+		}
+	}
+
+	if _, err := c.Seal(make([]byte, 16), nil, nil); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+
+	if _, err := c.Open(make([]byte, 16), make([]byte, TAG_SIZE), nil); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+}
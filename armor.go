@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/wedkarz02/chacha20/pkg/armor"
+)
+
+const (
+	// Type of the armored ciphertext blocks.
+	ARMOR_TYPE = "CHACHA20 MESSAGE"
+
+	// Version of the armored ciphertext blocks.
+	ARMOR_VERSION = 1
+
+	// Nonce-Encoding header value for the raw nonce|cipherText layout.
+	NONCE_ENCODING_PREPENDED = "prepended"
+
+	// Nonce-Encoding header value for envelopes.
+	NONCE_ENCODING_ENVELOPE = "envelope"
+)
+
+// Error returned if the armored block isn't a ChaCha20 message.
+var ErrArmorType = errors.New("armored block is not a " + ARMOR_TYPE)
+
+// Armor encodes the output of Encrypt or EncryptEnvelope
+// as a "CHACHA20 MESSAGE" armored block.
+func Armor(cipherText []byte) []byte {
+	return armor.Encode(&armor.Block{
+		Type:    ARMOR_TYPE,
+		Headers: ArmorHeaders(IsEnvelope(cipherText)),
+		Bytes:   cipherText,
+	})
+}
+
+// ArmorHeaders returns the headers of an armored ChaCha20 message.
+// Useful with armor.NewWriter when the data is streamed.
+func ArmorHeaders(envelope bool) map[string]string {
+	nonceEncoding := NONCE_ENCODING_PREPENDED
+	if envelope {
+		nonceEncoding = NONCE_ENCODING_ENVELOPE
+	}
+
+	return map[string]string{
+		"Version":        strconv.Itoa(ARMOR_VERSION),
+		"Nonce-Encoding": nonceEncoding,
+	}
+}
+
+// Dearmor decodes the first "CHACHA20 MESSAGE" block of the input.
+func Dearmor(armored []byte) ([]byte, error) {
+	b, _, err := armor.Decode(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Type != ARMOR_TYPE {
+		return nil, ErrArmorType
+	}
+
+	if v, ok := b.Headers["Version"]; ok && v != strconv.Itoa(ARMOR_VERSION) {
+		return nil, ErrEnvelopeVersion
+	}
+
+	return b.Bytes, nil
+}
+
+// EncryptArmored encrypts the plainText with Encrypt
+// and returns the armored cipherText.
+func (c *Cipher) EncryptArmored(plainText []byte) ([]byte, error) {
+	cipherText, err := c.Encrypt(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	return Armor(cipherText), nil
+}
+
+// DecryptArmored decodes the armored cipherText and decrypts it with Decrypt.
+func (c *Cipher) DecryptArmored(armored []byte) ([]byte, error) {
+	cipherText, err := Dearmor(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decrypt(cipherText)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/pkg/armor"
+)
+
+func TestArmorRoundTrip(t *testing.T) {
+	c, err := NewCipher([]byte("armor key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainText := []byte("pasted into config files and tickets")
+
+	armored, err := c.EncryptArmored(plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(armored), "-----BEGIN CHACHA20 MESSAGE-----\n") ||
+		!strings.Contains(string(armored), "Nonce-Encoding: prepended\n") ||
+		!strings.Contains(string(armored), "Version: 1\n") {
+		t.Fatalf("unexpected armor:\n%s", armored)
+	}
+
+	decrypted, err := c.DecryptArmored(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plainText) {
+		t.Fatalf("expected %q, found %q", plainText, decrypted)
+	}
+}
+
+func TestArmorEnvelope(t *testing.T) {
+	c, err := NewCipher([]byte("armor key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := c.EncryptEnvelope(ALG_CHACHA20_POLY1305, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armored := Armor(envelope)
+	if !strings.Contains(string(armored), "Nonce-Encoding: envelope\n") {
+		t.Fatalf("envelope not reflected in the headers:\n%s", armored)
+	}
+
+	decrypted, err := c.DecryptArmored(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decrypted) != "secret" {
+		t.Fatalf("expected %q, found %q", "secret", decrypted)
+	}
+}
+
+func TestDearmorType(t *testing.T) {
+	armored := armor.Encode(&armor.Block{Type: "SOMETHING ELSE", Bytes: []byte{0x00, 0x00, 0x00}})
+
+	if _, err := Dearmor(armored); err != ErrArmorType {
+		t.Fatalf("expected ErrArmorType, found %v", err)
+	}
+}
+
+func TestDearmorChecksum(t *testing.T) {
+	armored := Armor([]byte("nonce and cipherText"))
+
+	// Dropping the "=XXXX" line must not turn
+	// the checksum into an optional feature.
+	var stripped []string
+	for _, line := range strings.Split(string(armored), "\n") {
+		if !strings.HasPrefix(line, "=") {
+			stripped = append(stripped, line)
+		}
+	}
+
+	if _, err := Dearmor([]byte(strings.Join(stripped, "\n"))); err != armor.ErrNoChecksum {
+		t.Fatalf("expected ErrNoChecksum, found %v", err)
+	}
+}
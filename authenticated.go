@@ -0,0 +1,124 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import "crypto/subtle"
+
+// Encrypt-then-MAC on top of the legacy layout:
+//
+// nonce | cipherText | tag
+//
+// The tag is the AEAD_CHACHA20_POLY1305 tag of nonce | cipherText
+// and the optional associated data. Its one-time key is taken from the key
+// stream block with counter 0, which Encrypt never uses, so existing
+// nonce | cipherText data gains integrity without re-encryption.
+
+// EncryptAuthenticated encrypts the plainText like Encrypt
+// and appends a Poly1305 tag over the resulting nonce | cipherText.
+func (c *Cipher) EncryptAuthenticated(plainText []byte) ([]byte, error) {
+	return c.EncryptAuthenticatedWithAAD(plainText, nil)
+}
+
+// EncryptAuthenticatedWithAAD works like EncryptAuthenticated
+// and binds the tag to the additionalData, for example
+// the output of ContextAAD.
+func (c *Cipher) EncryptAuthenticatedWithAAD(plainText []byte, additionalData []byte) ([]byte, error) {
+	cipherText, err := c.Encrypt(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.AuthenticateWithAAD(cipherText, additionalData)
+}
+
+// Authenticate appends a Poly1305 tag to nonce | cipherText
+// previously produced by Encrypt with the same key.
+//
+// ErrCipherTextSize error is returned if the input is
+// shorter than the nonce.
+func (c *Cipher) Authenticate(cipherText []byte) ([]byte, error) {
+	return c.AuthenticateWithAAD(cipherText, nil)
+}
+
+// AuthenticateWithAAD works like Authenticate and
+// binds the tag to the additionalData.
+func (c *Cipher) AuthenticateWithAAD(cipherText []byte, additionalData []byte) ([]byte, error) {
+	if len(cipherText) < NONCE_SIZE {
+		return nil, ErrCipherTextSize
+	}
+
+	tag, err := c.authenticatedTag(cipherText, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(cipherText)+TAG_SIZE)
+	out = append(out, cipherText...)
+
+	return append(out, tag...), nil
+}
+
+// DecryptAuthenticated verifies the tag appended by EncryptAuthenticated
+// or Authenticate and decrypts the remaining nonce | cipherText.
+// Cipher object nonce is overwritten like in Decrypt.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No plainText is released in that case.
+func (c *Cipher) DecryptAuthenticated(cipherText []byte) ([]byte, error) {
+	return c.DecryptAuthenticatedWithAAD(cipherText, nil)
+}
+
+// DecryptAuthenticatedWithAAD decrypts data created by
+// EncryptAuthenticatedWithAAD or AuthenticateWithAAD.
+// The additionalData must match the one used to create the tag.
+func (c *Cipher) DecryptAuthenticatedWithAAD(cipherText []byte, additionalData []byte) ([]byte, error) {
+	if len(cipherText) < NONCE_SIZE+TAG_SIZE {
+		return nil, ErrAuthFailed
+	}
+
+	tag := cipherText[len(cipherText)-TAG_SIZE:]
+	cipherText = cipherText[:len(cipherText)-TAG_SIZE]
+
+	expected, err := c.authenticatedTag(cipherText, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	copy(c.nonce.Bytes[:], cipherText[:NONCE_SIZE])
+
+	return c.encryptionCore(cipherText[NONCE_SIZE:])
+}
+
+// AuthenticatedTag computes the tag of nonce | cipherText and the
+// additionalData with the one-time key derived from the embedded nonce.
+func (c *Cipher) authenticatedTag(cipherText []byte, additionalData []byte) ([]byte, error) {
+	mac, err := newRawCipher(c.Key, cipherText[:NONCE_SIZE])
+	if err != nil {
+		return nil, err
+	}
+	defer mac.ClearKey()
+
+	return polyTag(mac.polyKey(), additionalData, cipherText)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/pkg/poly"
+	"golang.org/x/crypto/chacha20"
+)
+
+func TestEncryptAuthenticated(t *testing.T) {
+	c, err := NewCipher([]byte("authenticated key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, plainText := range [][]byte{{}, []byte("a"), aeadPlainText, bytes.Repeat([]byte{0x42}, 1000)} {
+		sealed, err := c.EncryptAuthenticated(plainText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(sealed) != NONCE_SIZE+len(plainText)+TAG_SIZE {
+			t.Fatalf("unexpected length %d for %d bytes", len(sealed), len(plainText))
+		}
+
+		// The prefix is still the legacy layout.
+		legacy, err := c.Decrypt(sealed[:len(sealed)-TAG_SIZE])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(legacy, plainText) {
+			t.Fatalf("legacy prefix doesn't decrypt to the plainText")
+		}
+
+		opened, err := c.DecryptAuthenticated(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(opened, plainText) {
+			t.Fatalf("round trip mismatch")
+		}
+	}
+}
+
+func TestAuthenticateExisting(t *testing.T) {
+	c, _ := NewCipher([]byte("authenticated key"))
+
+	cipherText, err := c.Encrypt(aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := c.Authenticate(cipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(sealed[:len(cipherText)], cipherText) {
+		t.Fatalf("existing data was modified")
+	}
+
+	// The tag is the AEAD tag of nonce | cipherText without AAD,
+	// keyed with the first 32 bytes of key stream block 0.
+	ref, err := chacha20.NewUnauthenticatedCipher(c.Key, cipherText[:NONCE_SIZE])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otk := make([]byte, poly.KEY_SIZE)
+	ref.XORKeyStream(otk, otk)
+
+	var msg []byte
+	msg = append(msg, cipherText...)
+	msg = append(msg, make([]byte, padding16(len(cipherText)))...)
+	msg = binary.LittleEndian.AppendUint64(msg, 0)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(cipherText)))
+
+	expected, _ := poly.Sum(msg, otk)
+
+	if tag := sealed[len(cipherText):]; !bytes.Equal(tag, expected[:]) {
+		t.Fatalf("tag mismatch: expected %x, found %x", expected, tag)
+	}
+
+	// A cipher restored from the same key verifies it.
+	other, _ := NewCipher([]byte("authenticated key"))
+
+	opened, err := other.DecryptAuthenticated(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, aeadPlainText) {
+		t.Fatalf("round trip mismatch")
+	}
+}
+
+func TestDecryptAuthenticatedTampered(t *testing.T) {
+	c, _ := NewCipher([]byte("authenticated key"))
+
+	sealed, err := c.EncryptAuthenticated(aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nonce, cipherText and tag bytes are all covered.
+	for _, i := range []int{0, NONCE_SIZE - 1, NONCE_SIZE, len(sealed) - TAG_SIZE - 1, len(sealed) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x80
+
+		if _, err := c.DecryptAuthenticated(tampered); err != ErrAuthFailed {
+			t.Fatalf("byte %d: expected ErrAuthFailed, found %v", i, err)
+		}
+	}
+
+	for _, data := range [][]byte{nil, sealed[:NONCE_SIZE+TAG_SIZE-1], sealed[:len(sealed)-1], append(sealed, 0x00)} {
+		if _, err := c.DecryptAuthenticated(data); err != ErrAuthFailed {
+			t.Fatalf("%d bytes: expected ErrAuthFailed, found %v", len(data), err)
+		}
+	}
+
+	other, _ := NewCipher([]byte("another key"))
+	if _, err := other.DecryptAuthenticated(sealed); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for the wrong key, found %v", err)
+	}
+
+	if _, err := c.Authenticate(make([]byte, NONCE_SIZE-1)); err != ErrCipherTextSize {
+		t.Fatalf("expected ErrCipherTextSize, found %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/pkg/armor"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Message sizes used across the throughput benchmarks.
+var benchSizes = []int{
+	64,
+	1 << 10,
+	64 << 10,
+	1 << 20,
+	16 << 20,
+}
+
+// BenchSizeName formats a byte count as used in sub-benchmark names.
+func benchSizeName(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dKiB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// RunBenchSizes runs fn as a sub-benchmark for every size in benchSizes.
+func runBenchSizes(b *testing.B, fn func(b *testing.B, data []byte)) {
+	for _, size := range benchSizes {
+		data := make([]byte, size)
+
+		b.Run(benchSizeName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			fn(b, data)
+		})
+	}
+}
+
+func benchCipher(b *testing.B) *Cipher {
+	c, err := NewCipher([]byte("benchmark key"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return c
+}
+
+func BenchmarkBlock(b *testing.B) {
+	c := benchCipher(b)
+	c.resetState()
+
+	b.SetBytes(STATE_BYTE_SIZE)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.block()
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	c := benchCipher(b)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Encrypt(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	c := benchCipher(b)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		cipherText, err := c.Encrypt(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Decrypt(cipherText); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSeal(b *testing.B) {
+	key := make([]byte, KEY_SIZE)
+	nonce := make([]byte, NONCE_SIZE)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		for i := 0; i < b.N; i++ {
+			if _, err := sealChaCha20Poly1305(key, nonce, data, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkOpen(b *testing.B) {
+	key := make([]byte, KEY_SIZE)
+	nonce := make([]byte, NONCE_SIZE)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		sealed, err := sealChaCha20Poly1305(key, nonce, data, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := openChaCha20Poly1305(key, nonce, sealed, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEnvelope(b *testing.B) {
+	c := benchCipher(b)
+
+	for _, alg := range envelopeAlgorithms {
+		b.Run(alg.String(), func(b *testing.B) {
+			runBenchSizes(b, func(b *testing.B, data []byte) {
+				for i := 0; i < b.N; i++ {
+					sealed, err := c.EncryptEnvelope(alg, data, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					if _, err := c.DecryptEnvelopeUnauthenticated(sealed); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkArmorWriter(b *testing.B) {
+	headers := ArmorHeaders(false)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		for i := 0; i < b.N; i++ {
+			w := armor.NewWriter(io.Discard, ARMOR_TYPE, headers)
+
+			// Feed the writer in chunks to exercise its buffering.
+			for r := bytes.NewReader(data); r.Len() > 0; {
+				if _, err := io.CopyN(w, r, 4096); err != nil && err != io.EOF {
+					b.Fatal(err)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// The benchmarks below compare this package with
+// golang.org/x/crypto/chacha20poly1305 on the same inputs:
+//
+//	go test -run XXX -bench 'Seal|Open|Reference'
+
+func BenchmarkReferenceSeal(b *testing.B) {
+	aead, err := chacha20poly1305.New(make([]byte, KEY_SIZE))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	nonce := make([]byte, NONCE_SIZE)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		dst := make([]byte, 0, len(data)+TAG_SIZE)
+
+		for i := 0; i < b.N; i++ {
+			aead.Seal(dst, nonce, data, nil)
+		}
+	})
+}
+
+func BenchmarkReferenceOpen(b *testing.B) {
+	aead, err := chacha20poly1305.New(make([]byte, KEY_SIZE))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	nonce := make([]byte, NONCE_SIZE)
+
+	runBenchSizes(b, func(b *testing.B, data []byte) {
+		sealed := aead.Seal(nil, nonce, data, nil)
+		dst := make([]byte, 0, len(data))
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := aead.Open(dst, nonce, sealed, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
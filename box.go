@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/wedkarz02/chacha20/pkg/blake2b"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Anonymous sealed boxes follow libsodium's
+// crypto_box_curve25519xchacha20poly1305_seal:
+//
+//	ephemeral public key (32) | tag (16) | cipherText
+//
+// The box key is HChaCha20 of the X25519 shared secret with a zero nonce,
+// the 24-byte nonce is BLAKE2b-192(ephemeral public key | recipient public key)
+// and the message is encrypted with the XChaCha20 secretbox construction
+// (the tag covers the cipherText only), so boxes can be opened by libsodium
+// and the other way around.
+const (
+	// Size of the X25519 public and private keys in bytes.
+	BOX_KEY_SIZE = 32
+
+	// Size added to the message by SealAnonymous.
+	BOX_OVERHEAD = BOX_KEY_SIZE + TAG_SIZE
+)
+
+// Error returned if a sealed box key isn't an X25519 key.
+var ErrBoxKey = errors.New("sealed box keys must be X25519 keys")
+
+// SealAnonymous encrypts the message for the recipient's X25519 public key
+// using a fresh ephemeral key pair. The sender stays anonymous and can't
+// decrypt the box afterwards, as the ephemeral private key is discarded.
+func SealAnonymous(recipientPub *ecdh.PublicKey, msg []byte) ([]byte, error) {
+	return sealAnonymous(rand.Reader, recipientPub, msg)
+}
+
+// SealAnonymous reads the ephemeral private key from the random
+// source as is, so the boxes are reproducible for test vectors.
+func sealAnonymous(random io.Reader, recipientPub *ecdh.PublicKey, msg []byte) ([]byte, error) {
+	if recipientPub == nil || recipientPub.Curve() != ecdh.X25519() {
+		return nil, ErrBoxKey
+	}
+
+	b := make([]byte, BOX_KEY_SIZE)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().NewPrivateKey(b)
+	util.Wipe(b)
+	if err != nil {
+		return nil, err
+	}
+
+	epk := ephemeral.PublicKey().Bytes()
+
+	key, err := boxKey(ephemeral, recipientPub)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	sealed, err := sealSecretBox(xorXChaCha20SecretBox, key, boxNonce(epk, recipientPub.Bytes()), msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(epk, sealed...), nil
+}
+
+// OpenAnonymous decrypts a box created by SealAnonymous
+// (or libsodium's crypto_box_curve25519xchacha20poly1305_seal)
+// with the recipient's private key.
+//
+// ErrAuthFailed error is returned if the box is too short,
+// it was modified or it was sealed for another key.
+func OpenAnonymous(priv *ecdh.PrivateKey, box []byte) ([]byte, error) {
+	if priv == nil || priv.Curve() != ecdh.X25519() {
+		return nil, ErrBoxKey
+	}
+
+	if len(box) < BOX_OVERHEAD {
+		return nil, ErrAuthFailed
+	}
+
+	epk := box[:BOX_KEY_SIZE]
+
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(epk)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+
+	// Low order ephemeral keys result in an all-zero shared secret.
+	key, err := boxKey(priv, ephemeralPub)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	defer util.Wipe(key)
+
+	return openSecretBox(xorXChaCha20SecretBox, key, boxNonce(epk, priv.PublicKey().Bytes()), box[BOX_KEY_SIZE:])
+}
+
+// BoxKey derives the box key from the X25519 shared secret
+// like libsodium's crypto_box_curve25519xchacha20poly1305_beforenm.
+func boxKey(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) ([]byte, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(shared)
+
+	return hChaCha20(shared, make([]byte, HNONCE_SIZE))
+}
+
+// BoxNonce computes the sealed box nonce
+// BLAKE2b-192(ephemeral public key | recipient public key).
+func boxNonce(epk, recipientPub []byte) []byte {
+	// New only fails for invalid sizes or keys.
+	h, _ := blake2b.New(XNONCE_SIZE, nil)
+	h.Write(epk)
+	h.Write(recipientPub)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+// Sealed boxes generated with libsodium 1.0.18. The box field is the
+// output for the fixed ephemeral key (crypto_box_curve25519xchacha20poly1305_easy
+// with the sealed box nonce), sealedByLibsodium the output of
+// crypto_box_curve25519xchacha20poly1305_seal with a random ephemeral key.
+type libsodiumBoxSealFile struct {
+	Source  string `json:"source"`
+	Vectors []struct {
+		RecipientSecretKey string `json:"recipientSecretKey"`
+		RecipientPublicKey string `json:"recipientPublicKey"`
+		EphemeralSecretKey string `json:"ephemeralSecretKey"`
+		Message            string `json:"message"`
+		Box                string `json:"box"`
+		SealedByLibsodium  string `json:"sealedByLibsodium"`
+	} `json:"vectors"`
+}
+
+func TestSealAnonymousLibsodium(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "libsodium", "box_seal_xchacha20poly1305.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f libsodiumBoxSealFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Vectors) == 0 {
+		t.Fatal("no test vectors found")
+	}
+
+	for i, v := range f.Vectors {
+		priv, err := ecdh.X25519().NewPrivateKey(testutil.DecodeHex(t, v.RecipientSecretKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pub := testutil.DecodeHex(t, v.RecipientPublicKey); !bytes.Equal(priv.PublicKey().Bytes(), pub) {
+			t.Fatalf("vector %d: public key mismatch", i)
+		}
+
+		msg := testutil.DecodeHex(t, v.Message)
+		expected := testutil.DecodeHex(t, v.Box)
+
+		box, err := sealAnonymous(bytes.NewReader(testutil.DecodeHex(t, v.EphemeralSecretKey)), priv.PublicKey(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(box, expected) {
+			t.Fatalf("vector %d: seal mismatch:\nexpected %x\nfound    %x", i, expected, box)
+		}
+
+		for _, sealed := range [][]byte{expected, testutil.DecodeHex(t, v.SealedByLibsodium)} {
+			opened, err := OpenAnonymous(priv, sealed)
+			if err != nil {
+				t.Fatalf("vector %d: %v", i, err)
+			}
+
+			if !bytes.Equal(opened, msg) {
+				t.Fatalf("vector %d: open mismatch: expected %x, found %x", i, msg, opened)
+			}
+		}
+	}
+}
+
+func TestSealAnonymous(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box, err := SealAnonymous(priv.PublicKey(), aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(box) != len(aeadPlainText)+BOX_OVERHEAD {
+		t.Fatalf("expected %d bytes, found %d", len(aeadPlainText)+BOX_OVERHEAD, len(box))
+	}
+
+	// Every box uses a new ephemeral key.
+	other, err := SealAnonymous(priv.PublicKey(), aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(box[:BOX_KEY_SIZE], other[:BOX_KEY_SIZE]) {
+		t.Fatal("ephemeral key reused")
+	}
+
+	opened, err := OpenAnonymous(priv, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, aeadPlainText) {
+		t.Fatalf("open mismatch: expected %q, found %q", aeadPlainText, opened)
+	}
+
+	for i := range box {
+		modified := append([]byte(nil), box...)
+		modified[i] ^= 0x01
+
+		if _, err := OpenAnonymous(priv, modified); err != ErrAuthFailed {
+			t.Fatalf("byte %d: expected ErrAuthFailed for modified box, found %v", i, err)
+		}
+	}
+
+	if _, err := OpenAnonymous(priv, box[:BOX_OVERHEAD-1]); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for short box, found %v", err)
+	}
+
+	wrong, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenAnonymous(wrong, box); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for wrong key, found %v", err)
+	}
+
+	// An all-zero ephemeral key is a low order point.
+	lowOrder := append(make([]byte, BOX_KEY_SIZE), box[BOX_KEY_SIZE:]...)
+	if _, err := OpenAnonymous(priv, lowOrder); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for low order key, found %v", err)
+	}
+}
+
+func TestSealAnonymousKeyType(t *testing.T) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SealAnonymous(priv.PublicKey(), aeadPlainText); err != ErrBoxKey {
+		t.Fatalf("expected ErrBoxKey, found %v", err)
+	}
+
+	if _, err := OpenAnonymous(priv, make([]byte, BOX_OVERHEAD)); err != ErrBoxKey {
+		t.Fatalf("expected ErrBoxKey, found %v", err)
+	}
+}
@@ -29,15 +29,17 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
-	"math/bits"
 
+	"github.com/wedkarz02/chacha20/pkg/arx"
+	"github.com/wedkarz02/chacha20/pkg/blake2s"
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
 	"github.com/wedkarz02/chacha20/pkg/poly"
 	"github.com/wedkarz02/chacha20/pkg/util"
 )
 
 const (
 	// Size of the ChaCha20 state in uint32 words.
-	STATE_SIZE = 16
+	STATE_SIZE = arx.STATE_SIZE
 
 	// Size of the ChaCha20 state in bytes.
 	STATE_BYTE_SIZE = 64
@@ -83,12 +85,22 @@ var (
 	// Error returned if the length of the cipherText is smaller
 	// than the nonce length.
 	ErrCipherTextSize = errors.New("unable to strip the nonce from the ciphertext")
+
+	// Error returned if the nonce has the wrong length.
+	ErrNonceSize = errors.New("invalid nonce size")
+
+	// Error returned if the Poly1305 tag doesn't match the data.
+	ErrAuthFailed = errors.New("message authentication failed")
 )
 
 // Cipher structure contains information about the key,
 // the state, current counter number and the nonce.
+//
+// KeyID is an optional identifier of the key
+// recorded in the envelopes created by the cipher.
 type Cipher struct {
 	Key   []byte
+	KeyID []byte
 	state [STATE_SIZE]uint32
 	ctr   uint32
 	nonce *util.Nonce
@@ -120,12 +132,75 @@ func NewCipher(k []byte) (*Cipher, error) {
 	return &c, nil
 }
 
+// NewCipherFromHKDF initializes new ChaCha20 cipher with the key
+// derived from the secret using HKDF-SHA256 and generates a unique nonce.
+//
+// Different info values (tenant, purpose, file name) produce independent
+// keys from the same root secret. The salt is optional.
+//
+// https://datatracker.ietf.org/doc/html/rfc5869
+func NewCipherFromHKDF(secret, salt, info []byte) (*Cipher, error) {
+	key, err := hkdf.Key(secret, salt, info, KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	n, err := util.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRawCipher(key, n.Bytes[:])
+}
+
+// NewCipherFromBLAKE2s initializes new ChaCha20 cipher with the key
+// derived from the secret and the context using keyed BLAKE2s-256
+// and generates a unique nonce. It's an alternative to
+// NewCipherFromHKDF built on the same ARX round as ChaCha20.
+func NewCipherFromBLAKE2s(secret, context []byte) (*Cipher, error) {
+	key, err := blake2s.DeriveKey(secret, context)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	n, err := util.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRawCipher(key, n.Bytes[:])
+}
+
+// newRawCipher initializes a ChaCha20 cipher with the key
+// used as is (without hashing) and the given 96-bit nonce.
+// The key is copied, so the caller is free to clear it.
+func newRawCipher(key []byte, nonce []byte) (*Cipher, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	if len(nonce) != NONCE_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	c := Cipher{
+		Key:   append([]byte(nil), key...),
+		ctr:   INITIAL_CTR,
+		nonce: &util.Nonce{},
+	}
+
+	copy(c.nonce.Bytes[:], nonce)
+	c.resetState()
+
+	return &c, nil
+}
+
 // ClearKey sets all bytes of the key to 0x00 to make
 // sure that they can't be retrieved from memory.
 func (c *Cipher) ClearKey() {
-	for i := range c.Key {
-		c.Key[i] = 0x00
-	}
+	util.Wipe(c.Key)
 }
 
 // NewSHA256 returns a hashed byte slice of the input.
@@ -176,28 +251,12 @@ func (c *Cipher) resetState() {
 //
 // https://datatracker.ietf.org/doc/html/rfc8439#section-2.1
 func (c *Cipher) quarterRound(x, y, z, w int) {
-	c.state[x] += c.state[y]
-	c.state[w] ^= c.state[x]
-	c.state[w] = bits.RotateLeft32(c.state[w], 16)
-	c.state[z] += c.state[w]
-	c.state[y] ^= c.state[z]
-	c.state[y] = bits.RotateLeft32(c.state[y], 12)
-	c.state[x] += c.state[y]
-	c.state[w] ^= c.state[x]
-	c.state[w] = bits.RotateLeft32(c.state[w], 8)
-	c.state[z] += c.state[w]
-	c.state[y] ^= c.state[z]
-	c.state[y] = bits.RotateLeft32(c.state[y], 7)
+	arx.QuarterRound(&c.state, x, y, z, w, 0, 0, &arx.CHACHA)
 }
 
-// Block performs 20 quarter rounds to create
-// one block of ChaCha20 key stream.
-//
-// https://datatracker.ietf.org/doc/html/rfc8439#section-2.3
-func (c *Cipher) block() {
-	var initialState [STATE_SIZE]uint32
-	copy(initialState[:], c.state[:])
-
+// Rounds performs 20 quarter rounds on the state
+// without adding the initial state afterwards.
+func (c *Cipher) rounds() {
 	// 20 rounds of alternating column rounds and diagonal rounds.
 	for i := 0; i < NR/2; i++ {
 		// Column round
@@ -212,6 +271,17 @@ func (c *Cipher) block() {
 		c.quarterRound(2, 7, 8, 13)
 		c.quarterRound(3, 4, 9, 14)
 	}
+}
+
+// Block performs 20 quarter rounds to create
+// one block of ChaCha20 key stream.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.3
+func (c *Cipher) block() {
+	var initialState [STATE_SIZE]uint32
+	copy(initialState[:], c.state[:])
+
+	c.rounds()
 
 	// Adding the initial state using mod 2^32 addition.
 	for i, word := range initialState {
@@ -251,9 +321,15 @@ func streamBytes(data []byte, keyStream []byte) ([]byte, error) {
 
 // encryptionCore is used to encrypt/decrypt the data.
 func (c *Cipher) encryptionCore(data []byte) ([]byte, error) {
+	return c.xorKeyStream(data, INITIAL_CTR)
+}
+
+// XorKeyStream encrypts/decrypts the data with
+// the key stream starting at the given block counter.
+func (c *Cipher) xorKeyStream(data []byte, ctr uint32) ([]byte, error) {
 	var keyStream []byte
 
-	c.ctr = INITIAL_CTR
+	c.ctr = ctr
 	c.resetState()
 
 	for i := 0; i < len(data)/STATE_BYTE_SIZE+1; i++ {
@@ -291,8 +367,16 @@ func (c *Cipher) Encrypt(plainText []byte) ([]byte, error) {
 // Data decryption using ChaCha20 algorithm with a 96-bit nonce variant.
 // Cipher object nonce is overwritten by bytes stripped from the cipherText.
 //
+// Envelopes created by EncryptEnvelope are detected and
+// decrypted with DecryptEnvelope without associated data,
+// so only envelopes of the Poly1305 algorithms are accepted.
+//
 // https://datatracker.ietf.org/doc/html/rfc8439
 func (c *Cipher) Decrypt(cipherText []byte) ([]byte, error) {
+	if IsEnvelope(cipherText) {
+		return c.DecryptEnvelope(cipherText, nil)
+	}
+
 	if len(cipherText) < NONCE_SIZE {
 		return nil, ErrCipherTextSize
 	}
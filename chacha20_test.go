@@ -21,55 +21,59 @@
 package chacha20
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/binary"
 	"reflect"
 	"testing"
 
+	"github.com/wedkarz02/chacha20/pkg/blake2s"
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
 	"github.com/wedkarz02/chacha20/pkg/util"
 )
 
-func printState(state [16]uint32) {
-	for i, num := range state {
-		if i%4 == 0 {
-			fmt.Println()
-		}
-
-		fmt.Printf("%08x ", num)
+func TestNewNonce(t *testing.T) {
+	n1, err := util.NewNonce()
+	if err != nil {
+		t.Fatal(err)
 	}
-	fmt.Println()
-}
-
-func printBytes(bytes []byte) {
-	for i, b := range bytes {
-		if i%16 == 0 {
-			fmt.Println()
-		}
 
-		if i%4 == 0 && i%16 != 0 {
-			fmt.Print(" ")
-		}
-
-		fmt.Printf("%02x", b)
+	n2, err := util.NewNonce()
+	if err != nil {
+		t.Fatal(err)
 	}
-	fmt.Println()
-}
 
-func TestNewNonce(t *testing.T) {
-	n, err := util.NewNonce()
-	if err != nil {
-		panic(err)
+	if len(n1.Bytes) != NONCE_SIZE {
+		t.Fatalf("expected %d byte nonce, found %d", NONCE_SIZE, len(n1.Bytes))
 	}
 
-	printBytes(n.Bytes[:])
+	if reflect.DeepEqual(n1.Bytes, n2.Bytes) {
+		t.Fatalf("two random nonces are equal: %x", n1.Bytes)
+	}
 }
 
 func TestNewCipher(t *testing.T) {
 	c, err := NewCipher([]byte("asdf"))
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+
+	expectedKey := sha256.Sum256([]byte("asdf"))
+	if !reflect.DeepEqual(c.Key, expectedKey[:]) {
+		t.Fatalf("expected SHA256 hashed key %x, found %x", expectedKey, c.Key)
+	}
+
+	expectedState := [STATE_SIZE]uint32{CONSTANT_0, CONSTANT_1, CONSTANT_2, CONSTANT_3}
+	for i := 0; i < 8; i++ {
+		expectedState[i+4] = binary.LittleEndian.Uint32(expectedKey[i*4:])
+	}
+	expectedState[12] = INITIAL_CTR
+	for i := 0; i < 3; i++ {
+		expectedState[i+13] = binary.LittleEndian.Uint32(c.nonce.Bytes[i*4:])
 	}
 
-	printState(c.state)
+	if c.state != expectedState {
+		t.Fatalf("unexpected initial state:\n%08x\nexpected:\n%08x", c.state, expectedState)
+	}
 }
 
 func TestQuarterRound(t *testing.T) {
@@ -273,3 +277,60 @@ func TestDecrypt(t *testing.T) {
 		}
 	}
 }
+
+func TestNewCipherFromHKDF(t *testing.T) {
+	secret := []byte("root secret")
+	salt := []byte("salt")
+
+	c, err := NewCipherFromHKDF(secret, salt, []byte("tenant-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := hkdf.Key(secret, salt, []byte("tenant-a"), KEY_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.Key, expected) {
+		t.Fatalf("expected key %x, found %x", expected, c.Key)
+	}
+
+	other, err := NewCipherFromHKDF(secret, salt, []byte("tenant-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.DeepEqual(c.Key, other.Key) {
+		t.Fatalf("different info produced the same key")
+	}
+
+	cipherText, err := c.Encrypt([]byte("per tenant data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plainText, _ := other.Decrypt(cipherText); reflect.DeepEqual(plainText, []byte("per tenant data")) {
+		t.Fatalf("data decrypted with another tenant's key")
+	}
+}
+
+func TestNewCipherFromBLAKE2s(t *testing.T) {
+	c, err := NewCipherFromBLAKE2s([]byte("root secret"), []byte("purpose: backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := blake2s.DeriveKey([]byte("root secret"), []byte("purpose: backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.Key, expected) {
+		t.Fatalf("expected key %x, found %x", expected, c.Key)
+	}
+
+	if _, err := NewCipherFromBLAKE2s(nil, []byte("context")); err != blake2s.ErrKeySize {
+		t.Fatalf("expected blake2s.ErrKeySize for an empty secret, found %v", err)
+	}
+}
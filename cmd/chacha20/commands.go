@@ -0,0 +1,593 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/armor"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Error wrapped around authentication failures.
+var errAuth = errors.New("authentication failed: wrong key, wrong associated data or the data was modified")
+
+// Error returned by decrypt if -passphrase doesn't match the input.
+var errPassphraseSource = errors.New("-passphrase is required for passphrase protected streams and only for them")
+
+// Nonce-Encoding armor header of armored streams.
+const armorNonceEncodingStream = "stream"
+
+// Environment holds the standard streams of a command
+// and the passphrase prompt, which reads from the terminal.
+type environment struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	readPassphrase func(prompt io.Writer, message string) ([]byte, error)
+}
+
+// NewFlagSet creates a flag set reporting errors to stderr.
+func (env *environment) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("chacha20 "+name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	return fs
+}
+
+// Parse parses the flags and returns at most one positional argument.
+func parse(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", errUsage
+	}
+
+	switch fs.NArg() {
+	case 0:
+		return "", nil
+	case 1:
+		return fs.Arg(0), nil
+	}
+
+	fmt.Fprintf(fs.Output(), "%s: too many arguments\n", fs.Name())
+	return "", errUsage
+}
+
+func (env *environment) encrypt(args []string) error {
+	fs := env.newFlagSet("encrypt")
+	keys := addKeyFlags(fs)
+	keyID := fs.String("key-id", "", "key ID recorded in the stream header")
+	aad := addAADFlags(fs, "associated data authenticated with the message")
+	armored := fs.Bool("armor", false, "write ASCII armored output")
+	out := fs.String("out", "", "output file (default stdout)")
+
+	name, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := keys.check(env); err != nil {
+		return err
+	}
+
+	if len(*keyID) > chacha20.MAX_KEY_ID_SIZE {
+		fmt.Fprintf(env.stderr, "chacha20 encrypt: key ID longer than %d bytes\n", chacha20.MAX_KEY_ID_SIZE)
+		return errUsage
+	}
+
+	h := &streamHeader{}
+	var cipher *chacha20.Cipher
+
+	if *keys.passphrase {
+		passphrase, err := promptNewPassphrase(env)
+		if err != nil {
+			return err
+		}
+		defer util.Wipe(passphrase)
+
+		if h, err = newPassphraseHeader(); err != nil {
+			return err
+		}
+
+		if cipher, err = h.passphraseCipher(passphrase); err != nil {
+			return err
+		}
+	} else {
+		if cipher, err = keys.cipher(env); err != nil {
+			return err
+		}
+
+		h.keyID = append([]byte(nil), cipher.KeyID...)
+	}
+	defer cipher.ClearKey()
+
+	if *keyID != "" {
+		h.keyID = []byte(*keyID)
+	}
+
+	in, err := env.openInput(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return env.writeOutput(*out, func(w io.Writer) error {
+		if !*armored {
+			return encryptStream(w, in, cipher, h, aad.bytes())
+		}
+
+		headers := chacha20.ArmorHeaders(false)
+		headers["Nonce-Encoding"] = armorNonceEncodingStream
+
+		aw := armor.NewWriter(w, chacha20.ARMOR_TYPE, headers)
+		if err := encryptStream(aw, in, cipher, h, aad.bytes()); err != nil {
+			return err
+		}
+
+		return aw.Close()
+	})
+}
+
+func (env *environment) decrypt(args []string) error {
+	fs := env.newFlagSet("decrypt")
+	keys := addKeyFlags(fs)
+	aad := addAADFlags(fs, "associated data used during encryption")
+	out := fs.String("out", "", "output file (default stdout)")
+
+	name, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if err := keys.check(env); err != nil {
+		return err
+	}
+
+	in, err := env.openInput(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stream, data, err := readEncrypted(in)
+	if err != nil {
+		return err
+	}
+
+	if stream == nil {
+		return env.decryptMessage(keys, data, aad.bytes(), *out)
+	}
+
+	h, header, err := readStreamHeader(stream)
+	if err != nil {
+		return err
+	}
+
+	if (h.kdf == kdfPBKDF2) != *keys.passphrase {
+		return errPassphraseSource
+	}
+
+	var cipher *chacha20.Cipher
+
+	if *keys.passphrase {
+		passphrase, err := env.readPassphrase(env.stderr, "Passphrase: ")
+		if err != nil {
+			return fmt.Errorf("reading passphrase: %w", err)
+		}
+
+		cipher, err = h.passphraseCipher(passphrase)
+		util.Wipe(passphrase)
+		if err != nil {
+			return err
+		}
+	} else if cipher, err = keys.cipher(env); err != nil {
+		return err
+	}
+	defer cipher.ClearKey()
+
+	err = env.writeOutput(*out, func(w io.Writer) error {
+		return decryptStream(w, stream, cipher, header, aad.bytes())
+	})
+
+	if errors.Is(err, chacha20.ErrAuthFailed) || errors.Is(err, chacha20.ErrAADSize) {
+		return errAuth
+	}
+
+	return err
+}
+
+// DecryptMessage decrypts a single envelope or nonce|cipherText
+// message produced by the chacha20 package.
+func (env *environment) decryptMessage(keys *keyFlags, cipherText, aad []byte, out string) error {
+	if *keys.passphrase {
+		return errPassphraseSource
+	}
+
+	cipher, err := keys.cipher(env)
+	if err != nil {
+		return err
+	}
+	defer cipher.ClearKey()
+
+	var plainText []byte
+	if chacha20.IsEnvelope(cipherText) {
+		plainText, err = cipher.DecryptEnvelope(cipherText, aad)
+	} else {
+		plainText, err = cipher.Decrypt(cipherText)
+	}
+
+	switch {
+	case errors.Is(err, chacha20.ErrAuthFailed), errors.Is(err, chacha20.ErrAADSize):
+		return errAuth
+	case err != nil:
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	return env.writeOutput(out, func(w io.Writer) error {
+		_, err := w.Write(plainText)
+		return err
+	})
+}
+
+func (env *environment) keygen(args []string) error {
+	fs := env.newFlagSet("keygen")
+	out := fs.String("out", "", "output file (default stdout)")
+	passphrase := fs.Bool("passphrase", false, "encrypt the key file with a passphrase")
+
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var secret []byte
+	if *passphrase {
+		var err error
+		if secret, err = promptNewPassphrase(env); err != nil {
+			return err
+		}
+		defer util.Wipe(secret)
+	}
+
+	key, err := chacha20.GenerateKey()
+	if err != nil {
+		return err
+	}
+	defer key.Clear()
+
+	if *out != "" && *out != "-" {
+		if err := chacha20.SaveKey(*out, key, secret); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(env.stderr, "Key %s written to %s\n", key.ID, *out)
+		return nil
+	}
+
+	data, err := key.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	_, err = env.stdout.Write(data)
+	return err
+}
+
+// PromptNewPassphrase asks for a passphrase twice.
+func promptNewPassphrase(env *environment) ([]byte, error) {
+	first, err := env.readPassphrase(env.stderr, "New passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+
+	if len(first) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	second, err := env.readPassphrase(env.stderr, "Confirm passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	defer util.Wipe(second)
+
+	if !bytes.Equal(first, second) {
+		util.Wipe(first)
+		return nil, errors.New("passphrases don't match")
+	}
+
+	return first, nil
+}
+
+func (env *environment) inspect(args []string) error {
+	fs := env.newFlagSet("inspect")
+
+	name, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	in, err := env.openInput(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stream, data, err := readEncrypted(in)
+	if err != nil {
+		return err
+	}
+
+	if stream != nil {
+		h, _, err := readStreamHeader(stream)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(env.stdout, "Format:     stream v%d\n", streamVersion)
+		fmt.Fprintf(env.stdout, "Algorithm:  %v envelopes\n", chacha20.ALG_XCHACHA20_POLY1305)
+		if h.kdf == kdfPBKDF2 {
+			fmt.Fprintf(env.stdout, "KDF:        %s, %d iterations\n", chacha20.KDF_PBKDF2_SHA256, h.iterations)
+		}
+		fmt.Fprintf(env.stdout, "Key ID:     %q\n", h.keyID)
+		fmt.Fprintf(env.stdout, "Chunk size: %d\n", streamChunkSize)
+		return nil
+	}
+
+	e, err := chacha20.ParseEnvelope(data)
+	if err != nil {
+		if len(data) < chacha20.NONCE_SIZE {
+			return fmt.Errorf("not an envelope and too short for the raw layout: %w", err)
+		}
+
+		fmt.Fprintf(env.stdout, "Format:     raw (nonce|ciphertext)\n")
+		fmt.Fprintf(env.stdout, "Algorithm:  %v\n", chacha20.ALG_CHACHA20)
+		fmt.Fprintf(env.stdout, "Nonce:      %x\n", data[:chacha20.NONCE_SIZE])
+		fmt.Fprintf(env.stdout, "Body size:  %d\n", len(data)-chacha20.NONCE_SIZE)
+		return nil
+	}
+
+	fmt.Fprintf(env.stdout, "Format:     envelope v%d\n", e.Version)
+	fmt.Fprintf(env.stdout, "Algorithm:  %v\n", e.Algorithm)
+	fmt.Fprintf(env.stdout, "Key ID:     %q\n", e.KeyID)
+	fmt.Fprintf(env.stdout, "Nonce:      %x\n", e.Nonce)
+	fmt.Fprintf(env.stdout, "AAD size:   %d\n", e.AADSize)
+	fmt.Fprintf(env.stdout, "Body size:  %d\n", len(e.Body))
+	if len(e.Tag) > 0 {
+		fmt.Fprintf(env.stdout, "Tag:        %x\n", e.Tag)
+	}
+
+	return nil
+}
+
+// OpenInput opens the file, or stdin if the name is empty or "-".
+func (env *environment) openInput(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return io.NopCloser(env.stdin), nil
+	}
+
+	return os.Open(name)
+}
+
+// ReadEncrypted returns a reader positioned at the stream header if
+// the input is a stream. Anything else is read whole and returned as
+// data, with the ASCII armor removed: armored blocks are decoded in
+// one piece, and the envelope and nonce|cipherText layouts of the
+// chacha20 package are single messages.
+func readEncrypted(in io.Reader) (io.Reader, []byte, error) {
+	r := bufio.NewReader(in)
+	if isStream(r) {
+		return r, nil, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN "+chacha20.ARMOR_TYPE+"-----")) {
+		if data, err = chacha20.Dearmor(data); err != nil {
+			return nil, nil, fmt.Errorf("invalid armored input: %w", err)
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte(streamMagic)) {
+		return bytes.NewReader(data), nil, nil
+	}
+
+	return nil, data, nil
+}
+
+// WriteOutput calls write with the output file, or stdout if the name is empty or "-".
+// A partially written file is removed if write fails.
+func (env *environment) writeOutput(name string, write func(io.Writer) error) error {
+	if name == "" || name == "-" {
+		return write(env.stdout)
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+
+	return f.Close()
+}
+
+// AADFlags holds the associated data flags shared by encrypt and decrypt.
+type aadFlags struct {
+	aad     *string
+	context contextFlag
+}
+
+func addAADFlags(fs *flag.FlagSet, usage string) *aadFlags {
+	a := &aadFlags{
+		aad:     fs.String("aad", "", usage),
+		context: make(contextFlag),
+	}
+
+	fs.Var(a.context, "context", "key=value pair bound to the message, may be repeated")
+	return a
+}
+
+// Bytes returns the -aad value followed by the canonical
+// encoding of the -context pairs.
+func (a *aadFlags) bytes() []byte {
+	return append([]byte(*a.aad), chacha20.ContextAAD(a.context)...)
+}
+
+// ContextFlag collects repeated key=value flags.
+type contextFlag map[string]string
+
+func (c contextFlag) String() string {
+	pairs := make([]string, 0, len(c))
+	for k, v := range c {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (c contextFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok {
+		return errors.New("expected key=value")
+	}
+
+	if _, ok := c[k]; ok {
+		return fmt.Errorf("duplicate context key %q", k)
+	}
+
+	c[k] = v
+	return nil
+}
+
+// KeyFlags holds the key source flags shared by encrypt and decrypt.
+type keyFlags struct {
+	file       *string
+	env        *string
+	passphrase *bool
+}
+
+func addKeyFlags(fs *flag.FlagSet) *keyFlags {
+	return &keyFlags{
+		file:       fs.String("key-file", "", "read the key from a file"),
+		env:        fs.String("key-env", "", "read the key from an environment variable"),
+		passphrase: fs.Bool("passphrase", false, "prompt for a passphrase on the terminal"),
+	}
+}
+
+// Check makes sure exactly one key source is given.
+func (k *keyFlags) check(env *environment) error {
+	sources := 0
+	for _, set := range []bool{*k.file != "", *k.env != "", *k.passphrase} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		fmt.Fprintln(env.stderr, "chacha20: exactly one of -key-file, -key-env or -passphrase is required")
+		return errUsage
+	}
+
+	return nil
+}
+
+// Cipher reads the key from -key-file or -key-env and creates the cipher.
+// Key files written by keygen are used as is and set the key ID.
+// Any other key material is hex decoded if possible and hashed by NewCipher.
+// Passphrases are handled by the commands, since their key is derived
+// with the PBKDF2 parameters of the stream.
+func (k *keyFlags) cipher(env *environment) (*chacha20.Cipher, error) {
+	var key []byte
+	var err error
+
+	switch {
+	case *k.file != "":
+		key, err = os.ReadFile(*k.file)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+
+		if bytes.Contains(key, []byte("-----BEGIN CHACHA20 ")) {
+			util.Wipe(key)
+			return loadKeyFile(env, *k.file)
+		}
+		key = decodeKey(key)
+	default:
+		value, ok := os.LookupEnv(*k.env)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", *k.env)
+		}
+		key = decodeKey([]byte(value))
+	}
+	defer util.Wipe(key)
+
+	return chacha20.NewCipher(key)
+}
+
+// LoadKeyFile loads a key file, prompting for the passphrase if it's encrypted.
+func loadKeyFile(env *environment, path string) (*chacha20.Cipher, error) {
+	key, err := chacha20.LoadKey(path, nil)
+
+	if errors.Is(err, chacha20.ErrPassphraseRequired) {
+		var passphrase []byte
+		if passphrase, err = env.readPassphrase(env.stderr, "Key file passphrase: "); err != nil {
+			return nil, fmt.Errorf("reading passphrase: %w", err)
+		}
+
+		key, err = chacha20.LoadKey(path, passphrase)
+		util.Wipe(passphrase)
+
+		if errors.Is(err, chacha20.ErrAuthFailed) {
+			return nil, errors.New("wrong key file passphrase")
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("loading key file: %w", err)
+	}
+	defer key.Clear()
+
+	return key.NewCipher()
+}
+
+// DecodeKey decodes hex encoded key material.
+func decodeKey(key []byte) []byte {
+	trimmed := strings.TrimSpace(string(key))
+
+	if decoded, err := hex.DecodeString(trimmed); err == nil && len(decoded) > 0 {
+		return decoded
+	}
+
+	return key
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Command chacha20 encrypts, decrypts and inspects data
+// produced by the chacha20 package.
+//
+// Usage:
+//
+//	chacha20 encrypt [flags] [file]
+//	chacha20 decrypt [flags] [file]
+//	chacha20 keygen  [flags]
+//	chacha20 inspect [flags] [file]
+//
+// Data is read from the file argument or stdin and written to stdout
+// unless -out is given. Encrypt processes it in 64 KiB chunks, so input
+// of any size can be piped through it. The key is read from a file
+// (-key-file), an environment variable (-key-env) or derived from
+// a passphrase with PBKDF2-SHA256 like in key files (-passphrase).
+//
+// Decrypt and inspect also accept single messages produced by the
+// chacha20 package, such as envelopes. Those, and ASCII armored
+// input, are read whole.
+//
+// Exit codes: 0 on success, 1 on errors, 2 on usage errors
+// and 3 if the message authentication failed.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitAuthFail = 3
+)
+
+// Error returned for invalid command line usage.
+var errUsage = errors.New("invalid usage")
+
+const usage = `Usage: chacha20 <command> [flags] [file]
+
+Commands:
+  encrypt   encrypt a file or stdin
+  decrypt   decrypt a file or stdin
+  keygen    generate a random key
+  inspect   print the header of an encrypted file
+
+Run 'chacha20 <command> -h' for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Run executes the command line and returns the exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	env := &environment{
+		stdin:          stdin,
+		stdout:         stdout,
+		stderr:         stderr,
+		readPassphrase: readPassphrase,
+	}
+
+	return env.run(args)
+}
+
+// Run executes the command line in the environment.
+func (env *environment) run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(env.stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+
+	switch args[0] {
+	case "encrypt":
+		err = env.encrypt(args[1:])
+	case "decrypt":
+		err = env.decrypt(args[1:])
+	case "keygen":
+		err = env.keygen(args[1:])
+	case "inspect":
+		err = env.inspect(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(env.stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(env.stderr, "chacha20: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	return exitCode(err, env.stderr)
+}
+
+// ExitCode reports the error and maps it to an exit code.
+func exitCode(err error, stderr io.Writer) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errAuth):
+		fmt.Fprintf(stderr, "chacha20: %v\n", err)
+		return exitAuthFail
+	}
+
+	fmt.Fprintf(stderr, "chacha20: %v\n", err)
+	return exitError
+}
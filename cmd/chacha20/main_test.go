@@ -0,0 +1,357 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wedkarz02/chacha20"
+)
+
+// Execute runs the command line with the given stdin
+// and returns the exit code, stdout and stderr.
+func execute(stdin []byte, args ...string) (int, []byte, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, bytes.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.Bytes(), stderr.String()
+}
+
+// ExecutePassphrase is execute with every passphrase prompt
+// answered with the passphrase.
+func executePassphrase(stdin []byte, passphrase string, args ...string) (int, []byte, string) {
+	var stdout, stderr bytes.Buffer
+	env := &environment{
+		stdin:  bytes.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+		readPassphrase: func(io.Writer, string) ([]byte, error) {
+			return []byte(passphrase), nil
+		},
+	}
+
+	code := env.run(args)
+	return code, stdout.Bytes(), stderr.String()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "correct horse battery staple")
+
+	// Sizes around the chunk boundaries.
+	sizes := []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 5}
+
+	for _, size := range sizes {
+		plainText := bytes.Repeat([]byte("ops people shouldn't have to write Go\n"), size/38+1)[:size]
+
+		for _, armored := range []bool{false, true} {
+			args := []string{"encrypt", "-key-env", "CHACHA20_TEST_KEY"}
+			if armored {
+				args = append(args, "-armor")
+			}
+
+			code, cipherText, stderr := execute(plainText, args...)
+			if code != exitOK {
+				t.Fatalf("size %d: encrypt exited with %d: %s", size, code, stderr)
+			}
+
+			if armored && !bytes.HasPrefix(cipherText, []byte("-----BEGIN CHACHA20 MESSAGE-----")) {
+				t.Fatalf("size %d: expected armored output, found %q", size, cipherText)
+			}
+
+			if !armored && !bytes.HasPrefix(cipherText, []byte(streamMagic)) {
+				t.Fatalf("size %d: expected a stream, found %q", size, cipherText[:4])
+			}
+
+			code, decrypted, stderr := execute(cipherText, "decrypt", "-key-env", "CHACHA20_TEST_KEY")
+			if code != exitOK {
+				t.Fatalf("size %d: decrypt exited with %d: %s", size, code, stderr)
+			}
+
+			if !bytes.Equal(decrypted, plainText) {
+				t.Fatalf("size %d, armored %v: decrypted data mismatch", size, armored)
+			}
+		}
+	}
+}
+
+func TestTruncatedStream(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "key")
+
+	plainText := make([]byte, 2*streamChunkSize+10)
+
+	code, cipherText, stderr := execute(plainText, "encrypt", "-key-env", "CHACHA20_TEST_KEY")
+	if code != exitOK {
+		t.Fatalf("encrypt exited with %d: %s", code, stderr)
+	}
+
+	// Dropping the final chunk leaves a valid prefix of complete chunks.
+	last := len(cipherText) - (5 + chunkOverhead + 10)
+
+	code, _, stderr = execute(cipherText[:last], "decrypt", "-key-env", "CHACHA20_TEST_KEY")
+	if code != exitError || !strings.Contains(stderr, errStreamTruncated.Error()) {
+		t.Fatalf("expected %q without the final chunk, found exit code %d: %s", errStreamTruncated, code, stderr)
+	}
+
+	for _, n := range []int{last + 5, len(cipherText) - 1} {
+		if code, _, _ := execute(cipherText[:n], "decrypt", "-key-env", "CHACHA20_TEST_KEY"); code == exitOK {
+			t.Fatalf("truncated to %d bytes: decrypt succeeded", n)
+		}
+	}
+
+	// Data appended after the final chunk is rejected.
+	code, _, stderr = execute(append(cipherText, 0), "decrypt", "-key-env", "CHACHA20_TEST_KEY")
+	if code != exitError || !strings.Contains(stderr, errStreamFormat.Error()) {
+		t.Fatalf("expected %q for trailing data, found exit code %d: %s", errStreamFormat, code, stderr)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	defer func(iterations int) { kdfIterations = iterations }(kdfIterations)
+	kdfIterations = 1000
+
+	plainText := []byte("passphrase protected")
+
+	code, cipherText, stderr := executePassphrase(plainText, "hunter2", "encrypt", "-passphrase")
+	if code != exitOK {
+		t.Fatalf("encrypt exited with %d: %s", code, stderr)
+	}
+
+	h, _, err := readStreamHeader(bytes.NewReader(cipherText))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.kdf != kdfPBKDF2 || h.iterations != 1000 || len(h.salt) != chacha20.KDF_SALT_SIZE {
+		t.Fatalf("expected PBKDF2 parameters in the header, found %+v", h)
+	}
+
+	code, decrypted, stderr := executePassphrase(cipherText, "hunter2", "decrypt", "-passphrase")
+	if code != exitOK {
+		t.Fatalf("decrypt exited with %d: %s", code, stderr)
+	}
+
+	if !bytes.Equal(decrypted, plainText) {
+		t.Fatalf("expected %q, found %q", plainText, decrypted)
+	}
+
+	if code, _, _ := executePassphrase(cipherText, "hunter3", "decrypt", "-passphrase"); code != exitAuthFail {
+		t.Fatalf("expected exit code %d for the wrong passphrase, found %d", exitAuthFail, code)
+	}
+
+	// The passphrase isn't used as a raw key.
+	t.Setenv("CHACHA20_TEST_KEY", "hunter2")
+	if code, _, _ := execute(cipherText, "decrypt", "-key-env", "CHACHA20_TEST_KEY"); code == exitOK {
+		t.Fatal("passphrase stream decrypted with the passphrase as key")
+	}
+
+	_, stdout, _ := execute(cipherText, "inspect")
+	if !strings.Contains(string(stdout), "pbkdf2-sha256, 1000 iterations") {
+		t.Fatalf("KDF missing from inspect output:\n%s", stdout)
+	}
+}
+
+func TestDecryptEnvelope(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "envelope key")
+
+	cipher, err := chacha20.NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := cipher.EncryptEnvelope(chacha20.ALG_XCHACHA20_POLY1305, []byte("single message"), []byte("row 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range [][]byte{envelope, chacha20.Armor(envelope)} {
+		code, plainText, stderr := execute(input, "decrypt", "-key-env", "CHACHA20_TEST_KEY", "-aad", "row 1")
+		if code != exitOK {
+			t.Fatalf("decrypt exited with %d: %s", code, stderr)
+		}
+
+		if string(plainText) != "single message" {
+			t.Fatalf("expected %q, found %q", "single message", plainText)
+		}
+	}
+
+	code, _, stderr := executePassphrase(envelope, "envelope key", "decrypt", "-passphrase")
+	if code != exitError || !strings.Contains(stderr, errPassphraseSource.Error()) {
+		t.Fatalf("expected %q, found exit code %d: %s", errPassphraseSource, code, stderr)
+	}
+}
+
+func TestDecryptAuthFailure(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "right key")
+	t.Setenv("CHACHA20_WRONG_KEY", "wrong key")
+
+	code, cipherText, stderr := execute([]byte("secret"), "encrypt", "-key-env", "CHACHA20_TEST_KEY", "-aad", "row 1")
+	if code != exitOK {
+		t.Fatalf("encrypt exited with %d: %s", code, stderr)
+	}
+
+	testVectors := [][]string{
+		{"decrypt", "-key-env", "CHACHA20_WRONG_KEY", "-aad", "row 1"},
+		{"decrypt", "-key-env", "CHACHA20_TEST_KEY", "-aad", "row 2"},
+		{"decrypt", "-key-env", "CHACHA20_TEST_KEY"},
+	}
+
+	for _, args := range testVectors {
+		code, stdout, stderr := execute(cipherText, args...)
+		if code != exitAuthFail {
+			t.Fatalf("%v: expected exit code %d, found %d", args, exitAuthFail, code)
+		}
+
+		if len(stdout) != 0 {
+			t.Fatalf("%v: plaintext released on authentication failure", args)
+		}
+
+		if !strings.Contains(stderr, "authentication failed") {
+			t.Fatalf("%v: unclear error message %q", args, stderr)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "context key")
+
+	code, cipherText, stderr := execute([]byte("secret"), "encrypt", "-key-env", "CHACHA20_TEST_KEY", "-context", "table=users", "-context", "record=1")
+	if code != exitOK {
+		t.Fatalf("encrypt exited with %d: %s", code, stderr)
+	}
+
+	// The order of the pairs doesn't matter.
+	code, plainText, stderr := execute(cipherText, "decrypt", "-key-env", "CHACHA20_TEST_KEY", "-context", "record=1", "-context", "table=users")
+	if code != exitOK {
+		t.Fatalf("decrypt exited with %d: %s", code, stderr)
+	}
+
+	if string(plainText) != "secret" {
+		t.Fatalf("expected %q, found %q", "secret", plainText)
+	}
+
+	code, _, _ = execute(cipherText, "decrypt", "-key-env", "CHACHA20_TEST_KEY", "-context", "record=2", "-context", "table=users")
+	if code != exitAuthFail {
+		t.Fatalf("expected exit code %d for another record, found %d", exitAuthFail, code)
+	}
+
+	code, _, _ = execute(cipherText, "decrypt", "-key-env", "CHACHA20_TEST_KEY", "-context", "record")
+	if code != exitUsage {
+		t.Fatalf("expected exit code %d for a malformed pair, found %d", exitUsage, code)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	in := filepath.Join(dir, "plain.txt")
+	enc := filepath.Join(dir, "plain.txt.enc")
+
+	if code, _, stderr := execute(nil, "keygen", "-out", keyFile); code != exitOK {
+		t.Fatalf("keygen exited with %d: %s", code, stderr)
+	}
+
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(key), "-----BEGIN CHACHA20 KEY-----") {
+		t.Fatalf("expected a key file, found %q", key)
+	}
+
+	if err := os.WriteFile(in, []byte("file contents"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, stderr := execute(nil, "encrypt", "-key-file", keyFile, "-out", enc, in); code != exitOK {
+		t.Fatalf("encrypt exited with %d: %s", code, stderr)
+	}
+
+	code, decrypted, stderr := execute(nil, "decrypt", "-key-file", keyFile, enc)
+	if code != exitOK {
+		t.Fatalf("decrypt exited with %d: %s", code, stderr)
+	}
+
+	if string(decrypted) != "file contents" {
+		t.Fatalf("expected %q, found %q", "file contents", decrypted)
+	}
+
+	// The stream header records the ID of the key file.
+	k, err := chacha20.LoadKey(keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, stdout, _ := execute(nil, "inspect", enc)
+	if !strings.Contains(string(stdout), k.ID) {
+		t.Fatalf("key ID missing from the stream header:\n%s", stdout)
+	}
+
+	if err := os.Chmod(keyFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, stderr := execute(nil, "decrypt", "-key-file", keyFile, enc); runtime.GOOS == "linux" && code != exitError {
+		t.Fatalf("expected exit code %d for a world readable key file, found %d: %s", exitError, code, stderr)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "key")
+
+	_, cipherText, _ := execute([]byte("secret"), "encrypt", "-key-env", "CHACHA20_TEST_KEY", "-key-id", "2023-q4", "-armor")
+
+	code, stdout, stderr := execute(cipherText, "inspect")
+	if code != exitOK {
+		t.Fatalf("inspect exited with %d: %s", code, stderr)
+	}
+
+	for _, expected := range []string{"stream v1", "XChaCha20-Poly1305", `"2023-q4"`, "Chunk size: 65536"} {
+		if !strings.Contains(string(stdout), expected) {
+			t.Fatalf("inspect output doesn't contain %q:\n%s", expected, stdout)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	t.Setenv("CHACHA20_TEST_KEY", "key")
+
+	testVectors := [][]string{
+		{},
+		{"frobnicate"},
+		{"encrypt"},
+		{"encrypt", "-key-env", "CHACHA20_TEST_KEY", "-passphrase"},
+		{"encrypt", "-key-env", "CHACHA20_TEST_KEY", "-key-id", strings.Repeat("x", 256)},
+		{"decrypt", "-no-such-flag"},
+		{"inspect", "a", "b"},
+	}
+
+	for _, args := range testVectors {
+		if code, _, _ := execute(nil, args...); code != exitUsage {
+			t.Fatalf("%v: expected exit code %d, found %d", args, exitUsage, code)
+		}
+	}
+}
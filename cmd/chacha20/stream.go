@@ -0,0 +1,298 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/pbkdf2"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Encrypt writes a stream, so input of any size is processed in
+// fixed-size chunks without being held in memory:
+//
+//	magic       4 bytes   "CC2S"
+//	version     1 byte
+//	kdf         1 byte    kdfNone or kdfPBKDF2
+//	iterations  4 bytes   PBKDF2 iterations, 0 for kdfNone
+//	saltLen     1 byte
+//	salt        saltLen bytes
+//	keyIDLen    1 byte
+//	keyID       keyIDLen bytes
+//	streamID    16 bytes  random
+//	chunks      flag (1 byte) | 4-byte length | envelope
+//
+// Integers are little endian. Every chunk holds at most streamChunkSize
+// bytes and is sealed into an XChaCha20-Poly1305 envelope with the
+// header, the chunk index, the flag and the -aad/-context data as
+// associated data. The last chunk is flagged chunkFinal, so reordered,
+// dropped and truncated chunks fail to authenticate, and the random
+// stream ID keeps chunks from being spliced between streams.
+// With kdfPBKDF2 the key is derived from a passphrase like in
+// passphrase protected key files.
+
+const (
+	streamMagic   = "CC2S"
+	streamVersion = 1
+
+	// Plaintext bytes per chunk.
+	streamChunkSize = 64 << 10
+
+	// Size of the random stream ID.
+	streamIDSize = 16
+
+	// Size of a chunk envelope without its body.
+	chunkOverhead = len(chacha20.ENVELOPE_MAGIC) + 3 + chacha20.XNONCE_SIZE + 4 + chacha20.TAG_SIZE
+
+	// Stream key used as is, from a key file or a key variable.
+	kdfNone = 0
+
+	// Stream key derived from a passphrase with PBKDF2-SHA256.
+	kdfPBKDF2 = 1
+
+	// Chunk flags.
+	chunkMore  = 0
+	chunkFinal = 1
+)
+
+var (
+	// Error returned if the stream header or a chunk frame is invalid.
+	errStreamFormat = errors.New("malformed encrypted stream")
+
+	// Error returned if the stream ends without the final chunk.
+	errStreamTruncated = errors.New("encrypted stream is truncated")
+)
+
+// Number of PBKDF2 iterations used for new passphrase streams.
+var kdfIterations = chacha20.KDF_ITERATIONS
+
+// StreamHeader is the header of an encrypted stream.
+type streamHeader struct {
+	kdf        byte
+	iterations uint32
+	salt       []byte
+	keyID      []byte
+	id         []byte
+}
+
+func (h *streamHeader) marshal() []byte {
+	b := append([]byte(streamMagic), streamVersion, h.kdf)
+	b = binary.LittleEndian.AppendUint32(b, h.iterations)
+	b = append(b, byte(len(h.salt)))
+	b = append(b, h.salt...)
+	b = append(b, byte(len(h.keyID)))
+	b = append(b, h.keyID...)
+
+	return append(b, h.id...)
+}
+
+// IsStream reports whether the input starts with a stream header.
+func isStream(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(streamMagic))
+	return string(magic) == streamMagic
+}
+
+// ReadStreamHeader reads and validates the stream header.
+// The raw header is returned too, it's part of the associated data of the chunks.
+func readStreamHeader(r io.Reader) (*streamHeader, []byte, error) {
+	raw := make([]byte, len(streamMagic)+2+4+1)
+	if _, err := io.ReadFull(r, raw); err != nil || string(raw[:len(streamMagic)]) != streamMagic {
+		return nil, nil, errStreamFormat
+	}
+
+	fixed := raw[len(streamMagic):]
+	if fixed[0] != streamVersion {
+		return nil, nil, errStreamFormat
+	}
+
+	h := streamHeader{
+		kdf:        fixed[1],
+		iterations: binary.LittleEndian.Uint32(fixed[2:6]),
+	}
+
+	switch {
+	case h.kdf == kdfNone && h.iterations == 0 && fixed[6] == 0:
+	case h.kdf == kdfPBKDF2 && h.iterations > 0 && h.iterations <= chacha20.KDF_MAX_ITERATIONS && fixed[6] > 0:
+	default:
+		return nil, nil, errStreamFormat
+	}
+
+	rest := make([]byte, int(fixed[6])+1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, errStreamFormat
+	}
+	raw = append(raw, rest...)
+	h.salt = rest[:fixed[6]]
+
+	rest = make([]byte, int(rest[len(rest)-1])+streamIDSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, errStreamFormat
+	}
+	raw = append(raw, rest...)
+	h.keyID = rest[:len(rest)-streamIDSize]
+	h.id = rest[len(h.keyID):]
+
+	return &h, raw, nil
+}
+
+// PassphraseCipher derives the stream key from the passphrase.
+func (h *streamHeader) passphraseCipher(passphrase []byte) (*chacha20.Cipher, error) {
+	key, err := pbkdf2.Key(passphrase, h.salt, int(h.iterations), chacha20.KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	return chacha20.NewCipher(key)
+}
+
+// NewPassphraseHeader returns a header with a random PBKDF2 salt.
+func newPassphraseHeader() (*streamHeader, error) {
+	salt, err := util.RandomBytes(chacha20.KDF_SALT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamHeader{kdf: kdfPBKDF2, iterations: uint32(kdfIterations), salt: salt}, nil
+}
+
+// ChunkAAD returns the associated data of a chunk.
+func chunkAAD(header []byte, index uint64, flag byte, additionalData []byte) []byte {
+	ad := append([]byte(nil), header...)
+	ad = binary.LittleEndian.AppendUint64(ad, index)
+	ad = append(ad, flag)
+
+	return append(ad, additionalData...)
+}
+
+// EncryptStream encrypts everything read from r
+// and writes the stream to w, one chunk at a time.
+func encryptStream(w io.Writer, r io.Reader, cipher *chacha20.Cipher, h *streamHeader, additionalData []byte) error {
+	id, err := util.RandomBytes(streamIDSize)
+	if err != nil {
+		return err
+	}
+
+	h.id = id
+	header := h.marshal()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// The key ID is in the header, the envelopes don't repeat it.
+	c := *cipher
+	c.KeyID = nil
+
+	chunk := make([]byte, streamChunkSize)
+	defer util.Wipe(chunk)
+
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, chunk)
+
+		var flag byte = chunkMore
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			flag = chunkFinal
+		default:
+			return err
+		}
+
+		sealed, err := c.EncryptEnvelope(chacha20.ALG_XCHACHA20_POLY1305, chunk[:n], chunkAAD(header, index, flag, additionalData))
+		if err != nil {
+			return err
+		}
+
+		frame := binary.LittleEndian.AppendUint32([]byte{flag}, uint32(len(sealed)))
+		if _, err := w.Write(append(frame, sealed...)); err != nil {
+			return err
+		}
+
+		if flag == chunkFinal {
+			return nil
+		}
+	}
+}
+
+// DecryptStream decrypts the chunks following the header and writes
+// the plaintext of every authenticated chunk to w. Data written before
+// an error comes from authentic chunks, but the output is incomplete.
+func decryptStream(w io.Writer, r io.Reader, cipher *chacha20.Cipher, header, additionalData []byte) error {
+	c := *cipher
+	c.KeyID = nil
+
+	sealed := make([]byte, streamChunkSize+chunkOverhead)
+
+	for index := uint64(0); ; index++ {
+		var frame [5]byte
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errStreamTruncated
+			}
+			return err
+		}
+
+		flag := frame[0]
+		n := binary.LittleEndian.Uint32(frame[1:])
+		if flag > chunkFinal || n < uint32(chunkOverhead) || n > uint32(len(sealed)) {
+			return errStreamFormat
+		}
+
+		if _, err := io.ReadFull(r, sealed[:n]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errStreamTruncated
+			}
+			return err
+		}
+
+		// Only authenticated envelopes are accepted.
+		e, err := chacha20.ParseEnvelope(sealed[:n])
+		if err != nil || e.Algorithm != chacha20.ALG_XCHACHA20_POLY1305 {
+			return errStreamFormat
+		}
+
+		chunk, err := c.DecryptEnvelope(sealed[:n], chunkAAD(header, index, flag, additionalData))
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		util.Wipe(chunk)
+
+		if flag == chunkFinal {
+			break
+		}
+	}
+
+	// Nothing may follow the final chunk.
+	if _, err := io.ReadFull(r, make([]byte, 1)); err != io.EOF {
+		return errStreamFormat
+	}
+
+	return nil
+}
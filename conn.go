@@ -0,0 +1,344 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Secure channel on top of a net.Conn.
+//
+// Data is sent in records:
+//
+//	length      2 bytes, little endian, size of the sealed record
+//	sealed      ChaCha20-Poly1305 of type | data, with the length as AAD
+//
+// Every direction has its own key and a 64-bit record sequence number.
+// The nonce of a record is 4 zero bytes followed by the sequence number
+// in little endian, so a replayed, reordered or dropped record fails
+// authentication. A close record marks the end of the stream, which
+// makes truncation by a closed connection detectable.
+//
+// With a RekeyInterval, each direction replaces its key after every
+// RekeyInterval records, like the Noise REKEY function: the new key
+// is the first 32 bytes of the encryption of 32 zero bytes under the
+// maximum sequence number, which no record uses.
+
+const (
+	// Maximum number of data bytes in a single record.
+	MAX_RECORD_SIZE = 16 << 10
+
+	recordHeaderSize = 2
+
+	recordData  = 0x00
+	recordClose = 0x01
+
+	// Time allowed for sending the close record in Close.
+	closeTimeout = 5 * time.Second
+)
+
+var (
+	// Error returned for a record with an invalid length or type.
+	ErrRecordSize = errors.New("invalid record size")
+
+	// Error returned by Read if the connection ends without a close record.
+	ErrTruncated = errors.New("connection closed without a close record")
+
+	// Error returned if a direction runs out of record sequence numbers.
+	ErrSequence = errors.New("record sequence number exhausted")
+
+	// Error returned by writes after CloseWrite or Close.
+	ErrConnClosed = errors.New("write on a closed secure connection")
+)
+
+// Conn encrypts every write and decrypts every read of the wrapped
+// connection. Reads and writes may happen concurrently.
+//
+// Any error while reading, including an authentication failure,
+// is permanent: the stream can't be resynchronized afterwards.
+type Conn struct {
+	net.Conn
+
+	// Records between automatic rekeys of each direction,
+	// 0 disables rekeying. Both peers must use the same value
+	// and it must be set before the first Read or Write.
+	RekeyInterval uint64
+
+	readMu  sync.Mutex
+	recv    *Cipher
+	recvSeq uint64
+	readBuf []byte
+	readErr error
+
+	writeMu  sync.Mutex
+	send     *Cipher
+	sendSeq  uint64
+	writeErr error
+}
+
+// NewConn wraps conn using sendKey for outgoing and recvKey for incoming
+// records. Both keys must be 32 bytes and the peer must use them the
+// other way around. A key pair must not be used for more than one
+// connection, since the sequence numbers always start at zero.
+func NewConn(conn net.Conn, sendKey, recvKey []byte) (*Conn, error) {
+	send, err := newRawCipher(sendKey, make([]byte, NONCE_SIZE))
+	if err != nil {
+		return nil, err
+	}
+
+	recv, err := newRawCipher(recvKey, make([]byte, NONCE_SIZE))
+	if err != nil {
+		send.ClearKey()
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, send: send, recv: recv}, nil
+}
+
+// RecordNonce builds the nonce of the record with the sequence number.
+func recordNonce(seq uint64) []byte {
+	nonce := make([]byte, NONCE_SIZE)
+	binary.LittleEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// RekeyDue reports whether the key has to be replaced
+// after seq records have been sent or received.
+func (c *Conn) rekeyDue(seq uint64) bool {
+	return c.RekeyInterval > 0 && seq%c.RekeyInterval == 0
+}
+
+// RekeyRecordCipher returns a cipher with the next key and clears
+// the current one. The nonce of every record is set by Seal or Open.
+func rekeyRecordCipher(c *Cipher) (*Cipher, error) {
+	sealed, err := c.Seal(recordNonce(^uint64(0)), make([]byte, KEY_SIZE), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(sealed)
+
+	next, err := newRawCipher(sealed[:KEY_SIZE], make([]byte, NONCE_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	c.ClearKey()
+
+	return next, nil
+}
+
+// Write splits b into records of at most MAX_RECORD_SIZE bytes.
+// An empty write sends nothing.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	n := 0
+	for n < len(b) {
+		chunk := b[n:]
+		if len(chunk) > MAX_RECORD_SIZE {
+			chunk = chunk[:MAX_RECORD_SIZE]
+		}
+
+		if err := c.writeRecord(recordData, chunk); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+	}
+
+	return n, nil
+}
+
+// WriteRecord seals and sends a single record.
+func (c *Conn) writeRecord(recordType byte, data []byte) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	if c.sendSeq == ^uint64(0) {
+		c.writeErr = ErrSequence
+		return c.writeErr
+	}
+
+	plainText := make([]byte, 0, 1+len(data))
+	plainText = append(plainText, recordType)
+	plainText = append(plainText, data...)
+
+	header := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint16(header, uint16(len(plainText)+TAG_SIZE))
+
+	sealed, err := c.send.Seal(recordNonce(c.sendSeq), plainText, header)
+	if err != nil {
+		c.writeErr = err
+		return err
+	}
+	c.sendSeq++
+
+	if c.rekeyDue(c.sendSeq) {
+		next, err := rekeyRecordCipher(c.send)
+		if err != nil {
+			c.writeErr = err
+			return err
+		}
+		c.send = next
+	}
+
+	if _, err := c.Conn.Write(append(header, sealed...)); err != nil {
+		c.writeErr = err
+		return err
+	}
+
+	return nil
+}
+
+// Read decrypts the next records into b. It returns io.EOF after the
+// peer's close record and ErrTruncated if the connection ends without one.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.readBuf) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+
+		if len(b) == 0 {
+			return 0, nil
+		}
+
+		c.readBuf, c.readErr = c.readRecord()
+	}
+
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+
+	return n, nil
+}
+
+// ReadRecord receives and opens a single record.
+func (c *Conn) readRecord() ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		if err == io.EOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint16(header))
+	if size < 1+TAG_SIZE || size > 1+MAX_RECORD_SIZE+TAG_SIZE {
+		return nil, ErrRecordSize
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	plainText, err := c.recv.Open(recordNonce(c.recvSeq), sealed, header)
+	if err != nil {
+		return nil, err
+	}
+	c.recvSeq++
+
+	if c.rekeyDue(c.recvSeq) {
+		next, err := rekeyRecordCipher(c.recv)
+		if err != nil {
+			return nil, err
+		}
+		c.recv = next
+	}
+
+	switch plainText[0] {
+	case recordData:
+		return plainText[1:], nil
+	case recordClose:
+		if len(plainText) != 1 {
+			return nil, ErrRecordSize
+		}
+		return nil, io.EOF
+	}
+
+	return nil, ErrRecordSize
+}
+
+// CloseWrite sends the close record. Later writes fail,
+// while reading is still possible.
+func (c *Conn) CloseWrite() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	err := c.writeRecord(recordClose, nil)
+	if err == nil {
+		c.writeErr = ErrConnClosed
+	}
+
+	return err
+}
+
+// Close sends the close record, unless CloseWrite already did,
+// closes the wrapped connection and clears both keys. Sending is
+// abandoned if the peer doesn't read it within a few seconds, and
+// skipped while a Write is in progress, since the Write may be
+// blocked on the peer and only closing the connection ends it.
+func (c *Conn) Close() error {
+	if c.writeMu.TryLock() {
+		if c.writeErr == nil {
+			c.Conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+			c.writeRecord(recordClose, nil)
+		}
+		c.writeErr = ErrConnClosed
+		c.writeMu.Unlock()
+	}
+
+	err := c.Conn.Close()
+
+	// Closing the wrapped connection unblocks a pending Read or Write,
+	// so both sides can be locked and cleared afterwards.
+	c.writeMu.Lock()
+	c.writeErr = ErrConnClosed
+	c.send.ClearKey()
+	c.writeMu.Unlock()
+
+	c.readMu.Lock()
+	if c.readErr == nil {
+		c.readErr = net.ErrClosed
+	}
+	c.recv.ClearKey()
+	c.readBuf = nil
+	c.readMu.Unlock()
+
+	return err
+}
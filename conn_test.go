@@ -0,0 +1,410 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	connKeyA = bytes.Repeat([]byte{0xaa}, KEY_SIZE)
+	connKeyB = bytes.Repeat([]byte{0xbb}, KEY_SIZE)
+)
+
+// ConnPair returns two ends of a secure channel over net.Pipe.
+func connPair(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+
+	p1, p2 := net.Pipe()
+
+	c1, err := NewConn(p1, connKeyA, connKeyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := NewConn(p2, connKeyB, connKeyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c1, c2
+}
+
+func TestConn(t *testing.T) {
+	c1, c2 := connPair(t)
+
+	request := []byte("ping")
+	response := bytes.Repeat([]byte("pong"), MAX_RECORD_SIZE)
+
+	errc := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(request))
+		if _, err := io.ReadFull(c2, buf); err != nil {
+			errc <- err
+			return
+		}
+
+		if !bytes.Equal(buf, request) {
+			errc <- errors.New("request mismatch")
+			return
+		}
+
+		if _, err := c2.Write(response); err != nil {
+			errc <- err
+			return
+		}
+
+		errc <- c2.Close()
+	}()
+
+	if _, err := c1.Write(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// The response spans several records and ends with a close record.
+	received, err := io.ReadAll(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, response) {
+		t.Fatalf("response mismatch: %d bytes received", len(received))
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	c1.Close()
+}
+
+func TestConnKeySize(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	if _, err := NewConn(p1, connKeyA[1:], connKeyB); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+
+	if _, err := NewConn(p1, connKeyA, nil); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
+
+// CaptureRecords returns the raw records sent by a Conn
+// for each message, followed by its close record.
+func captureRecords(t *testing.T, messages ...string) [][]byte {
+	t.Helper()
+
+	p1, p2 := net.Pipe()
+
+	c, err := NewConn(p1, connKeyA, connKeyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for _, msg := range messages {
+			c.Write([]byte(msg))
+		}
+		c.Close()
+	}()
+
+	var records [][]byte
+
+	for {
+		header := make([]byte, recordHeaderSize)
+		if _, err := io.ReadFull(p2, header); err != nil {
+			break
+		}
+
+		body := make([]byte, int(header[0])|int(header[1])<<8)
+		if _, err := io.ReadFull(p2, body); err != nil {
+			t.Fatal(err)
+		}
+
+		records = append(records, append(header, body...))
+	}
+
+	if len(records) != len(messages)+1 {
+		t.Fatalf("expected %d records, found %d", len(messages)+1, len(records))
+	}
+
+	return records
+}
+
+// ReceiveRaw feeds raw bytes to a receiving Conn
+// and returns everything it reads with the final error.
+func receiveRaw(t *testing.T, raw []byte) ([]byte, error) {
+	t.Helper()
+
+	p1, p2 := net.Pipe()
+
+	c, err := NewConn(p2, connKeyB, connKeyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Conn.Close()
+
+	go func() {
+		p1.Write(raw)
+		p1.Close()
+	}()
+
+	var received []byte
+	buf := make([]byte, 64)
+
+	for {
+		n, err := c.Read(buf)
+		received = append(received, buf[:n]...)
+
+		if err != nil {
+			return received, err
+		}
+	}
+}
+
+func TestConnRecordManipulation(t *testing.T) {
+	records := captureRecords(t, "first", "second", "third")
+	r0, r1, r2, closeRecord := records[0], records[1], records[2], records[3]
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tampered := append([]byte(nil), r1...)
+	tampered[len(tampered)-1] ^= 0x01
+
+	testCases := []struct {
+		name     string
+		raw      []byte
+		received string
+		expected error
+	}{
+		{"intact", join(r0, r1, r2, closeRecord), "firstsecondthird", io.EOF},
+		{"replayed", join(r0, r0, r1), "first", ErrAuthFailed},
+		{"reordered", join(r0, r2, r1), "first", ErrAuthFailed},
+		{"dropped", join(r0, r2, closeRecord), "first", ErrAuthFailed},
+		{"tampered", join(r0, tampered, r2), "first", ErrAuthFailed},
+		{"truncated record", join(r0, r1[:len(r1)-1]), "first", io.ErrUnexpectedEOF},
+		{"truncated header", join(r0, r1[:1]), "first", io.ErrUnexpectedEOF},
+		{"missing close record", join(r0, r1, r2), "firstsecondthird", ErrTruncated},
+		{"early close record", join(r0, closeRecord), "first", ErrAuthFailed},
+		{"invalid length", join(r0, []byte{0x01, 0x00, 0x00}), "first", ErrRecordSize},
+	}
+
+	for _, tc := range testCases {
+		received, err := receiveRaw(t, tc.raw)
+
+		if string(received) != tc.received {
+			t.Fatalf("%s: expected %q, found %q", tc.name, tc.received, received)
+		}
+
+		if err != tc.expected {
+			t.Fatalf("%s: expected %v, found %v", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func TestConnReflection(t *testing.T) {
+	// Records sent with key A must not be accepted by
+	// the sender itself, which receives with key B.
+	records := captureRecords(t, "echo")
+
+	p1, p2 := net.Pipe()
+
+	c, err := NewConn(p2, connKeyA, connKeyB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Conn.Close()
+
+	go func() {
+		p1.Write(records[0])
+		p1.Close()
+	}()
+
+	if _, err := c.Read(make([]byte, 16)); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+
+	// Errors are permanent.
+	if _, err := c.Read(make([]byte, 16)); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed on the next read, found %v", err)
+	}
+}
+
+func TestConnCloseWrite(t *testing.T) {
+	c1, c2 := connPair(t)
+	defer c1.Close()
+
+	go func() {
+		c2.Write([]byte("last words"))
+		c2.CloseWrite()
+	}()
+
+	received, err := io.ReadAll(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(received) != "last words" {
+		t.Fatalf("expected %q, found %q", "last words", received)
+	}
+
+	if _, err := c2.Write([]byte("more")); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, found %v", err)
+	}
+
+	c2.Conn.Close()
+}
+
+func TestConnClose(t *testing.T) {
+	c1, c2 := connPair(t)
+	defer c2.Close()
+
+	// A pending Read must not keep Close from clearing the receive key.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c1.Read(make([]byte, 1))
+		readErr <- err
+	}()
+
+	go io.ReadAll(c2)
+
+	if err := c1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-readErr; err == nil {
+		t.Fatal("expected the pending read to fail")
+	}
+
+	zero := make([]byte, KEY_SIZE)
+	if !bytes.Equal(c1.send.Key, zero) || !bytes.Equal(c1.recv.Key, zero) {
+		t.Fatal("keys not cleared by Close")
+	}
+
+	if _, err := c1.Write([]byte("data")); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, found %v", err)
+	}
+
+	if _, err := c1.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected read after Close to fail")
+	}
+}
+
+func TestConnCloseBlockedWrite(t *testing.T) {
+	c1, c2 := connPair(t)
+	defer c2.Close()
+
+	// Nobody reads from c2, so the write blocks on the pipe.
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c1.Write([]byte("never read"))
+		writeErr <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c1.Close()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by a pending Write")
+	}
+
+	if err := <-writeErr; err == nil {
+		t.Fatal("expected the pending write to fail")
+	}
+
+	if !bytes.Equal(c1.send.Key, make([]byte, KEY_SIZE)) {
+		t.Fatal("send key not cleared by Close")
+	}
+
+	if _, err := c1.Write([]byte("data")); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, found %v", err)
+	}
+}
+
+func TestConnRekey(t *testing.T) {
+	c1, c2 := connPair(t)
+	c1.RekeyInterval = 2
+	c2.RekeyInterval = 2
+
+	sendKey := append([]byte(nil), c1.send.Key...)
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			c1.Write([]byte{byte(i)})
+		}
+		c1.Close()
+	}()
+
+	received, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received, []byte{0, 1, 2, 3, 4}) {
+		t.Fatalf("unexpected data %x", received)
+	}
+
+	if bytes.Equal(c2.recv.Key, sendKey) {
+		t.Fatal("receive key not replaced")
+	}
+
+	c2.Close()
+
+	// Peers disagreeing on the interval fail right after the first rekey.
+	c1, c2 = connPair(t)
+	c1.RekeyInterval = 2
+
+	go func() {
+		c1.Write([]byte("a"))
+		c1.Write([]byte("b"))
+		c1.Write([]byte("c"))
+	}()
+
+	buf := make([]byte, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := c2.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c2.Read(buf); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+
+	c2.Conn.Close()
+	c1.Conn.Close()
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Algorithm identifies the construction used to create an envelope.
+type Algorithm byte
+
+const (
+	// ChaCha20 with a 96-bit nonce, no authentication.
+	ALG_CHACHA20 Algorithm = 0x01
+
+	// AEAD_CHACHA20_POLY1305 from RFC 8439.
+	ALG_CHACHA20_POLY1305 Algorithm = 0x02
+
+	// XChaCha20 with a 192-bit nonce, no authentication.
+	ALG_XCHACHA20 Algorithm = 0x03
+
+	// AEAD_XChaCha20_Poly1305.
+	ALG_XCHACHA20_POLY1305 Algorithm = 0x04
+)
+
+const (
+	// Magic bytes at the start of every envelope.
+	ENVELOPE_MAGIC = "CC20"
+
+	// Current version of the envelope format.
+	ENVELOPE_VERSION = 1
+
+	// Maximum length of the key ID stored in an envelope.
+	MAX_KEY_ID_SIZE = 255
+
+	// Size of the fixed part of the header: magic, version,
+	// algorithm and key ID length.
+	envelopeFixedSize = len(ENVELOPE_MAGIC) + 3
+
+	// Size of the associated data length field.
+	envelopeAADSize = 4
+
+	// HKDF info prefix of the per-algorithm envelope keys,
+	// followed by the algorithm identifier.
+	envelopeKeyInfo = "chacha20 envelope key"
+)
+
+var (
+	// Error returned if the data is not a valid envelope.
+	ErrEnvelopeFormat = errors.New("malformed envelope")
+
+	// Error returned if the envelope version is not supported.
+	ErrEnvelopeVersion = errors.New("unsupported envelope version")
+
+	// Error returned if the algorithm identifier is unknown.
+	ErrAlgorithm = errors.New("unsupported algorithm")
+
+	// Error returned if the key ID is longer than MAX_KEY_ID_SIZE.
+	ErrKeyIDSize = errors.New("key id too long")
+
+	// Error returned if the envelope was created with a different key.
+	ErrKeyIDMismatch = errors.New("envelope key id doesn't match the cipher")
+
+	// Error returned if the associated data has a different
+	// length than the one recorded in the envelope.
+	ErrAADSize = errors.New("associated data length mismatch")
+
+	// Error returned by DecryptEnvelope for the algorithms
+	// without authentication.
+	ErrUnauthenticated = errors.New("envelope algorithm is not authenticated")
+)
+
+// Envelope is a self-describing container for encrypted data.
+//
+// Binary layout (version 1):
+//
+//	magic    4 bytes   "CC20"
+//	version  1 byte
+//	alg      1 byte
+//	kidLen   1 byte
+//	keyID    kidLen bytes
+//	nonce    12 or 24 bytes, depending on alg
+//	aadLen   4 bytes, little endian
+//	body     variable
+//	tag      16 bytes for the Poly1305 algorithms, absent otherwise
+//
+// The associated data itself is not stored,
+// only its length is recorded.
+//
+// Everything before the body is authenticated along with the
+// associated data by the Poly1305 algorithms, and every algorithm
+// encrypts under its own key derived from the cipher key with HKDF,
+// so an envelope can't be passed off as one of another algorithm.
+type Envelope struct {
+	Version   byte
+	Algorithm Algorithm
+	KeyID     []byte
+	Nonce     []byte
+	AADSize   uint32
+	Body      []byte
+	Tag       []byte
+}
+
+// NonceSize returns the nonce length used by the algorithm.
+func (a Algorithm) NonceSize() int {
+	switch a {
+	case ALG_CHACHA20, ALG_CHACHA20_POLY1305:
+		return NONCE_SIZE
+	case ALG_XCHACHA20, ALG_XCHACHA20_POLY1305:
+		return XNONCE_SIZE
+	}
+
+	return 0
+}
+
+// TagSize returns the tag length used by the algorithm.
+func (a Algorithm) TagSize() int {
+	switch a {
+	case ALG_CHACHA20_POLY1305, ALG_XCHACHA20_POLY1305:
+		return TAG_SIZE
+	}
+
+	return 0
+}
+
+// String returns the name of the algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case ALG_CHACHA20:
+		return "ChaCha20"
+	case ALG_CHACHA20_POLY1305:
+		return "ChaCha20-Poly1305"
+	case ALG_XCHACHA20:
+		return "XChaCha20"
+	case ALG_XCHACHA20_POLY1305:
+		return "XChaCha20-Poly1305"
+	}
+
+	return "unknown"
+}
+
+// Serialize converts the envelope to its binary form.
+func (e *Envelope) Serialize() ([]byte, error) {
+	if e.Version != ENVELOPE_VERSION {
+		return nil, ErrEnvelopeVersion
+	}
+
+	if e.Algorithm.NonceSize() == 0 {
+		return nil, ErrAlgorithm
+	}
+
+	if len(e.KeyID) > MAX_KEY_ID_SIZE {
+		return nil, ErrKeyIDSize
+	}
+
+	if len(e.Nonce) != e.Algorithm.NonceSize() || len(e.Tag) != e.Algorithm.TagSize() {
+		return nil, ErrEnvelopeFormat
+	}
+
+	data := append(e.header(), e.Body...)
+	return append(data, e.Tag...), nil
+}
+
+// Header returns the binary form of the envelope up to the body.
+func (e *Envelope) header() []byte {
+	size := envelopeFixedSize + len(e.KeyID) + len(e.Nonce) + envelopeAADSize + len(e.Body) + len(e.Tag)
+	data := make([]byte, 0, size)
+
+	data = append(data, ENVELOPE_MAGIC...)
+	data = append(data, e.Version, byte(e.Algorithm), byte(len(e.KeyID)))
+	data = append(data, e.KeyID...)
+	data = append(data, e.Nonce...)
+
+	return binary.LittleEndian.AppendUint32(data, e.AADSize)
+}
+
+// ParseEnvelope parses the binary form of an envelope.
+// The returned envelope references the input slice.
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	if len(data) < envelopeFixedSize || string(data[:len(ENVELOPE_MAGIC)]) != ENVELOPE_MAGIC {
+		return nil, ErrEnvelopeFormat
+	}
+
+	e := Envelope{
+		Version:   data[len(ENVELOPE_MAGIC)],
+		Algorithm: Algorithm(data[len(ENVELOPE_MAGIC)+1]),
+	}
+
+	if e.Version != ENVELOPE_VERSION {
+		return nil, ErrEnvelopeVersion
+	}
+
+	nonceSize := e.Algorithm.NonceSize()
+	if nonceSize == 0 {
+		return nil, ErrAlgorithm
+	}
+
+	keyIDSize := int(data[len(ENVELOPE_MAGIC)+2])
+	data = data[envelopeFixedSize:]
+
+	if len(data) < keyIDSize+nonceSize+envelopeAADSize+e.Algorithm.TagSize() {
+		return nil, ErrEnvelopeFormat
+	}
+
+	if keyIDSize > 0 {
+		e.KeyID = data[:keyIDSize]
+	}
+	data = data[keyIDSize:]
+
+	e.Nonce = data[:nonceSize]
+	data = data[nonceSize:]
+
+	e.AADSize = binary.LittleEndian.Uint32(data[:envelopeAADSize])
+	data = data[envelopeAADSize:]
+
+	e.Body = data[:len(data)-e.Algorithm.TagSize()]
+	if e.Algorithm.TagSize() > 0 {
+		e.Tag = data[len(data)-e.Algorithm.TagSize():]
+	}
+
+	return &e, nil
+}
+
+// IsEnvelope reports whether the data is a well-formed envelope.
+// Anything else is treated as the legacy nonce|cipherText layout.
+func IsEnvelope(data []byte) bool {
+	_, err := ParseEnvelope(data)
+	return err == nil
+}
+
+// EncryptEnvelope encrypts the plainText with the given algorithm
+// and returns the serialized envelope. A fresh random nonce
+// is generated for every call and the KeyID of the cipher is recorded.
+//
+// The additionalData is authenticated (but not stored), together with
+// the envelope header, by the Poly1305 algorithms and must be empty otherwise.
+func (c *Cipher) EncryptEnvelope(alg Algorithm, plainText []byte, additionalData []byte) ([]byte, error) {
+	nonceSize := alg.NonceSize()
+	if nonceSize == 0 {
+		return nil, ErrAlgorithm
+	}
+
+	if len(c.KeyID) > MAX_KEY_ID_SIZE {
+		return nil, ErrKeyIDSize
+	}
+
+	if alg.TagSize() == 0 && len(additionalData) != 0 {
+		return nil, ErrAADSize
+	}
+
+	nonce, err := util.RandomBytes(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := c.envelopeKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	e := Envelope{
+		Version:   ENVELOPE_VERSION,
+		Algorithm: alg,
+		KeyID:     c.KeyID,
+		Nonce:     nonce,
+		AADSize:   uint32(len(additionalData)),
+	}
+
+	ad := append(e.header(), additionalData...)
+
+	var sealed []byte
+
+	switch alg {
+	case ALG_CHACHA20:
+		sealed, err = xorChaCha20(key, nonce, plainText)
+	case ALG_CHACHA20_POLY1305:
+		sealed, err = sealChaCha20Poly1305(key, nonce, plainText, ad)
+	case ALG_XCHACHA20:
+		sealed, err = xorXChaCha20(key, nonce, plainText)
+	case ALG_XCHACHA20_POLY1305:
+		sealed, err = sealXChaCha20Poly1305(key, nonce, plainText, ad)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.Body = sealed[:len(sealed)-alg.TagSize()]
+	e.Tag = sealed[len(sealed)-alg.TagSize():]
+
+	return e.Serialize()
+}
+
+// DecryptEnvelope parses the envelope and decrypts its body.
+// The tag is verified before any plainText is returned.
+//
+// ErrUnauthenticated error is returned for the algorithms without
+// a tag, use DecryptEnvelopeUnauthenticated to accept those.
+// ErrKeyIDMismatch error is returned if both the cipher
+// and the envelope carry a key ID and they differ.
+func (c *Cipher) DecryptEnvelope(data []byte, additionalData []byte) ([]byte, error) {
+	return c.decryptEnvelope(data, additionalData, false)
+}
+
+// DecryptEnvelopeUnauthenticated decrypts envelopes of any algorithm,
+// including ALG_CHACHA20 and ALG_XCHACHA20. Their plainText is returned
+// as is, without any guarantee that the envelope wasn't modified.
+func (c *Cipher) DecryptEnvelopeUnauthenticated(data []byte) ([]byte, error) {
+	return c.decryptEnvelope(data, nil, true)
+}
+
+func (c *Cipher) decryptEnvelope(data []byte, additionalData []byte, allowUnauthenticated bool) ([]byte, error) {
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Algorithm.TagSize() == 0 && !allowUnauthenticated {
+		return nil, ErrUnauthenticated
+	}
+
+	if len(c.KeyID) > 0 && len(e.KeyID) > 0 && string(c.KeyID) != string(e.KeyID) {
+		return nil, ErrKeyIDMismatch
+	}
+
+	if uint64(e.AADSize) != uint64(len(additionalData)) {
+		return nil, ErrAADSize
+	}
+
+	key, err := c.envelopeKey(e.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(key)
+
+	ad := append(e.header(), additionalData...)
+	sealed := append(append([]byte(nil), e.Body...), e.Tag...)
+
+	switch e.Algorithm {
+	case ALG_CHACHA20:
+		return xorChaCha20(key, e.Nonce, sealed)
+	case ALG_CHACHA20_POLY1305:
+		return openChaCha20Poly1305(key, e.Nonce, sealed, ad)
+	case ALG_XCHACHA20:
+		return xorXChaCha20(key, e.Nonce, sealed)
+	case ALG_XCHACHA20_POLY1305:
+		return openXChaCha20Poly1305(key, e.Nonce, sealed, ad)
+	}
+
+	return nil, ErrAlgorithm
+}
+
+// EnvelopeKey derives the key of the algorithm from the cipher key,
+// so envelopes of different algorithms never share a keystream.
+func (c *Cipher) envelopeKey(alg Algorithm) ([]byte, error) {
+	return hkdf.Key(c.Key, nil, append([]byte(envelopeKeyInfo), byte(alg)), KEY_SIZE)
+}
+
+// XorChaCha20 encrypts/decrypts the data with the key and the nonce.
+func xorChaCha20(key []byte, nonce []byte, data []byte) ([]byte, error) {
+	tmp, err := newRawCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.ClearKey()
+
+	return tmp.encryptionCore(data)
+}
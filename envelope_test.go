@@ -0,0 +1,306 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"testing"
+)
+
+var envelopeAlgorithms = []Algorithm{
+	ALG_CHACHA20,
+	ALG_CHACHA20_POLY1305,
+	ALG_XCHACHA20,
+	ALG_XCHACHA20_POLY1305,
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	plainText := []byte("ChaCha20 encryption is really cool and also fast!")
+
+	for _, alg := range envelopeAlgorithms {
+		c, err := NewCipher([]byte("envelope key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.KeyID = []byte("key-1")
+
+		data, err := c.EncryptEnvelope(alg, plainText, nil)
+		if err != nil {
+			t.Fatalf("%v: encryption failed: %v", alg, err)
+		}
+
+		e, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatalf("%v: parsing failed: %v", alg, err)
+		}
+
+		if e.Algorithm != alg || string(e.KeyID) != "key-1" || len(e.Nonce) != alg.NonceSize() || len(e.Tag) != alg.TagSize() {
+			t.Fatalf("%v: unexpected envelope header: %+v", alg, e)
+		}
+
+		serialized, err := e.Serialize()
+		if err != nil {
+			t.Fatalf("%v: serialization failed: %v", alg, err)
+		}
+
+		if !bytes.Equal(serialized, data) {
+			t.Fatalf("%v: serialize(parse(x)) != x", alg)
+		}
+
+		decrypted, err := c.Decrypt(data)
+		if alg.TagSize() == 0 {
+			if err != ErrUnauthenticated {
+				t.Fatalf("%v: expected ErrUnauthenticated, found %v", alg, err)
+			}
+
+			decrypted, err = c.DecryptEnvelopeUnauthenticated(data)
+		}
+
+		if err != nil {
+			t.Fatalf("%v: decryption failed: %v", alg, err)
+		}
+
+		if !bytes.Equal(decrypted, plainText) {
+			t.Fatalf("%v: expected %q, found %q", alg, plainText, decrypted)
+		}
+	}
+}
+
+func TestEnvelopeAssociatedData(t *testing.T) {
+	c, err := NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aad := []byte("record 42")
+
+	data, err := c.EncryptEnvelope(ALG_CHACHA20_POLY1305, []byte("secret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Decrypt(data); err != ErrAADSize {
+		t.Fatalf("expected ErrAADSize without associated data, found %v", err)
+	}
+
+	if _, err := c.DecryptEnvelope(data, []byte("record 43")); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for wrong associated data, found %v", err)
+	}
+
+	if _, err := c.DecryptEnvelope(data, aad); err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+
+	if _, err := c.EncryptEnvelope(ALG_CHACHA20, []byte("secret"), aad); err != ErrAADSize {
+		t.Fatalf("expected ErrAADSize for unauthenticated algorithm, found %v", err)
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	c, err := NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []Algorithm{ALG_CHACHA20_POLY1305, ALG_XCHACHA20_POLY1305} {
+		data, err := c.EncryptEnvelope(alg, []byte("secret"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data[len(data)-TAG_SIZE-1] ^= 0x80
+
+		if _, err := c.Decrypt(data); err != ErrAuthFailed {
+			t.Fatalf("%v: expected ErrAuthFailed, found %v", alg, err)
+		}
+	}
+}
+
+func TestEnvelopeHeaderTampering(t *testing.T) {
+	c, err := NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.KeyID = []byte("key-1")
+
+	plainText := []byte("pay 100 to bob")
+
+	for _, alg := range []Algorithm{ALG_CHACHA20_POLY1305, ALG_XCHACHA20_POLY1305} {
+		data, err := c.EncryptEnvelope(alg, plainText, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Fields checked while parsing fail early, the rest fails the tag.
+		header := envelopeFixedSize + len(c.KeyID) + alg.NonceSize() + envelopeAADSize
+		for i := 0; i < header; i++ {
+			modified := append([]byte(nil), data...)
+			modified[i] ^= 0x01
+
+			if _, err := c.DecryptEnvelope(modified, nil); err == nil {
+				t.Fatalf("%v: modified header byte %d accepted", alg, i)
+			}
+		}
+
+		// The key ID is bound to the tag, even if the cipher has none.
+		anonymous := &Cipher{Key: c.Key}
+		modified := append([]byte(nil), data...)
+		modified[envelopeFixedSize] ^= 0x01
+
+		if _, err := anonymous.DecryptEnvelope(modified, nil); err != ErrAuthFailed {
+			t.Fatalf("%v: expected ErrAuthFailed for a modified key ID, found %v", alg, err)
+		}
+	}
+}
+
+func TestEnvelopeDowngrade(t *testing.T) {
+	c, err := NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainText := []byte("pay 100 to bob")
+	forged := []byte("pay 900 to bob")
+
+	downgrades := map[Algorithm]Algorithm{
+		ALG_CHACHA20_POLY1305:  ALG_CHACHA20,
+		ALG_XCHACHA20_POLY1305: ALG_XCHACHA20,
+	}
+
+	for alg, unauthenticated := range downgrades {
+		data, err := c.EncryptEnvelope(alg, plainText, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Relabel the envelope and flip the body into the forged message,
+		// which would work if both algorithms shared the keystream.
+		e, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e.Algorithm = unauthenticated
+		e.Tag = nil
+		e.Body = append([]byte(nil), e.Body...)
+		for i := range e.Body {
+			e.Body[i] ^= plainText[i] ^ forged[i]
+		}
+
+		downgraded, err := e.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Decrypt(downgraded); err != ErrUnauthenticated {
+			t.Fatalf("%v: expected ErrUnauthenticated, found %v", alg, err)
+		}
+
+		if _, err := c.DecryptEnvelope(downgraded, nil); err != ErrUnauthenticated {
+			t.Fatalf("%v: expected ErrUnauthenticated, found %v", alg, err)
+		}
+
+		decrypted, err := c.DecryptEnvelopeUnauthenticated(downgraded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Equal(decrypted, forged) {
+			t.Fatalf("%v: %v envelope shares the keystream", alg, unauthenticated)
+		}
+	}
+}
+
+func TestEnvelopeKeyID(t *testing.T) {
+	c, err := NewCipher([]byte("envelope key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.KeyID = []byte("old")
+
+	data, err := c.EncryptEnvelope(ALG_CHACHA20_POLY1305, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.KeyID = []byte("new")
+	if _, err := c.Decrypt(data); err != ErrKeyIDMismatch {
+		t.Fatalf("expected ErrKeyIDMismatch, found %v", err)
+	}
+
+	c.KeyID = make([]byte, MAX_KEY_ID_SIZE+1)
+	if _, err := c.EncryptEnvelope(ALG_CHACHA20_POLY1305, []byte("secret"), nil); err != ErrKeyIDSize {
+		t.Fatalf("expected ErrKeyIDSize, found %v", err)
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	valid := append([]byte(ENVELOPE_MAGIC), ENVELOPE_VERSION, byte(ALG_CHACHA20), 0)
+	valid = append(valid, make([]byte, NONCE_SIZE+envelopeAADSize)...)
+
+	testVectors := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", nil, ErrEnvelopeFormat},
+		{"bad magic", append([]byte("CC21"), valid[4:]...), ErrEnvelopeFormat},
+		{"bad version", append(append([]byte(ENVELOPE_MAGIC), 2), valid[5:]...), ErrEnvelopeVersion},
+		{"bad algorithm", append(append([]byte(ENVELOPE_MAGIC), ENVELOPE_VERSION, 0xff), valid[6:]...), ErrAlgorithm},
+		{"truncated", valid[:len(valid)-1], ErrEnvelopeFormat},
+		{"missing tag", append(append([]byte(ENVELOPE_MAGIC), ENVELOPE_VERSION, byte(ALG_CHACHA20_POLY1305)), valid[6:]...), ErrEnvelopeFormat},
+		{"key id overflow", append(append([]byte(ENVELOPE_MAGIC), ENVELOPE_VERSION, byte(ALG_CHACHA20), 0xff), valid[7:]...), ErrEnvelopeFormat},
+		{"valid", valid, nil},
+	}
+
+	for _, tv := range testVectors {
+		if _, err := ParseEnvelope(tv.data); err != tv.expected {
+			t.Fatalf("%s: expected %v, found %v", tv.name, tv.expected, err)
+		}
+	}
+}
+
+func TestDecryptLegacyFallback(t *testing.T) {
+	c, err := NewCipher([]byte("legacy key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainText := []byte("legacy nonce|cipherText layout")
+
+	cipherText, err := c.Encrypt(plainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsEnvelope(cipherText) {
+		t.Fatalf("legacy ciphertext detected as an envelope")
+	}
+
+	decrypted, err := c.Decrypt(cipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plainText) {
+		t.Fatalf("expected %q, found %q", plainText, decrypted)
+	}
+}
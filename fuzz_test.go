@@ -0,0 +1,231 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Differential fuzz targets comparing this implementation
+// byte for byte with golang.org/x/crypto.
+//
+// Run with: go test -fuzz=FuzzXORKeyStream
+
+// fitBytes returns exactly n bytes of b, truncated or padded with zeros.
+func fitBytes(b []byte, n int) []byte {
+	fitted := make([]byte, n)
+	copy(fitted, b)
+	return fitted
+}
+
+func FuzzXORKeyStream(f *testing.F) {
+	f.Add(make([]byte, KEY_SIZE), make([]byte, XNONCE_SIZE), uint32(0), make([]byte, 64))
+	f.Add(bytes.Repeat([]byte{0xff}, KEY_SIZE), bytes.Repeat([]byte{0x4a}, XNONCE_SIZE), uint32(1), []byte("Ladies and Gentlemen"))
+	f.Add([]byte("key"), []byte("nonce"), uint32(42), bytes.Repeat([]byte{0x01}, 1000))
+	f.Add([]byte{}, []byte{}, ^uint32(0), []byte{0x00})
+
+	f.Fuzz(func(t *testing.T, key, nonce []byte, ctr uint32, data []byte) {
+		key = fitBytes(key, KEY_SIZE)
+
+		// x/crypto panics instead of wrapping the 32-bit counter.
+		blocks := uint64(len(data))/STATE_BYTE_SIZE + 1
+		if uint64(ctr)+blocks > 1<<32 {
+			ctr = 0
+		}
+
+		// ChaCha20 with a 96-bit nonce and an arbitrary counter.
+		n := fitBytes(nonce, NONCE_SIZE)
+
+		c, err := newRawCipher(key, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := c.xorKeyStream(data, ctr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err := chacha20.NewUnauthenticatedCipher(key, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref.SetCounter(ctr)
+
+		expected := make([]byte, len(data))
+		ref.XORKeyStream(expected, data)
+
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("ChaCha20 mismatch (ctr %d):\nexpected %x\nfound    %x", ctr, expected, actual)
+		}
+
+		// XChaCha20, starting at INITIAL_CTR like Encrypt.
+		xn := fitBytes(nonce, XNONCE_SIZE)
+
+		actual, err = xorXChaCha20(key, xn, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ref, err = chacha20.NewUnauthenticatedCipher(key, xn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref.SetCounter(INITIAL_CTR)
+		ref.XORKeyStream(expected, data)
+
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("XChaCha20 mismatch:\nexpected %x\nfound    %x", expected, actual)
+		}
+	})
+}
+
+func FuzzAEADRoundTrip(f *testing.F) {
+	f.Add(make([]byte, KEY_SIZE), make([]byte, XNONCE_SIZE), []byte{}, []byte{})
+	f.Add(bytes.Repeat([]byte{0x80}, KEY_SIZE), []byte("nonce"), []byte("aad"), []byte("Ladies and Gentlemen of the class of '99"))
+	f.Add([]byte("key"), []byte{}, bytes.Repeat([]byte{0xaa}, 17), bytes.Repeat([]byte{0x55}, 129))
+
+	f.Fuzz(func(t *testing.T, key, nonce, aad, plainText []byte) {
+		key = fitBytes(key, KEY_SIZE)
+
+		testCases := []struct {
+			name      string
+			nonceSize int
+			newRef    func([]byte) (interface {
+				Seal(dst, nonce, plaintext, additionalData []byte) []byte
+				Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+			}, error)
+			seal aeadFunc
+			open aeadFunc
+		}{
+			{
+				name:      "ChaCha20-Poly1305",
+				nonceSize: NONCE_SIZE,
+				newRef: func(k []byte) (interface {
+					Seal(dst, nonce, plaintext, additionalData []byte) []byte
+					Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+				}, error) {
+					return chacha20poly1305.New(k)
+				},
+				seal: sealChaCha20Poly1305,
+				open: openChaCha20Poly1305,
+			},
+			{
+				name:      "XChaCha20-Poly1305",
+				nonceSize: XNONCE_SIZE,
+				newRef: func(k []byte) (interface {
+					Seal(dst, nonce, plaintext, additionalData []byte) []byte
+					Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+				}, error) {
+					return chacha20poly1305.NewX(k)
+				},
+				seal: sealXChaCha20Poly1305,
+				open: openXChaCha20Poly1305,
+			},
+		}
+
+		for _, tc := range testCases {
+			n := fitBytes(nonce, tc.nonceSize)
+
+			ref, err := tc.newRef(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := ref.Seal(nil, n, plainText, aad)
+
+			actual, err := tc.seal(key, n, plainText, aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(actual, expected) {
+				t.Fatalf("%s: seal mismatch:\nexpected %x\nfound    %x", tc.name, expected, actual)
+			}
+
+			opened, err := tc.open(key, n, actual, aad)
+			if err != nil {
+				t.Fatalf("%s: open failed: %v", tc.name, err)
+			}
+
+			if !bytes.Equal(opened, plainText) {
+				t.Fatalf("%s: round trip mismatch", tc.name)
+			}
+
+			// Any single bit flip must be rejected by both implementations.
+			actual[len(plainText)%len(actual)] ^= 0x01
+
+			if _, err := tc.open(key, n, actual, aad); err != ErrAuthFailed {
+				t.Fatalf("%s: expected ErrAuthFailed for modified data, found %v", tc.name, err)
+			}
+
+			if _, err := ref.Open(nil, n, actual, aad); err == nil {
+				t.Fatalf("%s: reference accepted modified data", tc.name)
+			}
+		}
+	})
+}
+
+func FuzzDecryptGarbage(f *testing.F) {
+	c, err := NewCipher([]byte("fuzz key"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, alg := range envelopeAlgorithms {
+		data, err := c.EncryptEnvelope(alg, []byte("valid envelope"), nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	legacy, err := c.Encrypt([]byte("legacy layout"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(legacy)
+	f.Add([]byte{})
+	f.Add(make([]byte, NONCE_SIZE-1))
+	f.Add(make([]byte, NONCE_SIZE))
+	f.Add([]byte(ENVELOPE_MAGIC))
+	f.Add(append([]byte(ENVELOPE_MAGIC), ENVELOPE_VERSION, byte(ALG_XCHACHA20_POLY1305), 0xff))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		plainText, err := c.Decrypt(data)
+
+		if err == nil && !IsEnvelope(data) && len(plainText) != len(data)-NONCE_SIZE {
+			t.Fatalf("legacy decryption returned %d bytes for %d bytes of input", len(plainText), len(data))
+		}
+
+		if len(data) < NONCE_SIZE && !IsEnvelope(data) && err != ErrCipherTextSize {
+			t.Fatalf("expected ErrCipherTextSize for %d bytes, found %v", len(data), err)
+		}
+
+		c.DecryptEnvelope(data, []byte("aad"))
+		Dearmor(data)
+	})
+}
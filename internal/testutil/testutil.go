@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package testutil implements helpers shared by the tests
+// of the chacha20 packages.
+package testutil
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// DecodeHex decodes a hex encoded test vector
+// and fails the test if it's malformed.
+func DecodeHex(t testing.TB, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex in test vector: %v", err)
+	}
+
+	return b
+}
+
+// Sequence returns the bytes from, from+1, ..., to-1,
+// the inputs used by the RFC and reference test vectors.
+func Sequence(from, to int) []byte {
+	b := make([]byte, 0, to-from)
+	for i := from; i < to; i++ {
+		b = append(b, byte(i))
+	}
+
+	return b
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/wedkarz02/chacha20/pkg/armor"
+	"github.com/wedkarz02/chacha20/pkg/pbkdf2"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Key files are armored blocks (see the armor package) with
+// the metadata stored in the headers:
+//
+//	-----BEGIN CHACHA20 KEY-----
+//	Created: 2023-10-18T12:00:00Z
+//	Key-ID: 3f9a0c1d2e4b5a69
+//	Version: 1
+//
+//	<base64 of the raw 32-byte key>
+//	=<CRC-24>
+//	-----END CHACHA20 KEY-----
+//
+// Passphrase protected key files use the "CHACHA20 ENCRYPTED KEY" type
+// and additionally record the KDF parameters and the nonce:
+//
+//	KDF: pbkdf2-sha256
+//	KDF-Iterations: 600000
+//	KDF-Salt: <base64 of 16 random bytes>
+//	Nonce: <base64 of 12 random bytes>
+//
+// The body is then the key encrypted with ChaCha20-Poly1305 under
+// the PBKDF2-derived key, followed by the tag. All the headers are
+// authenticated as associated data, so they can't be modified either.
+const (
+	// Type of the plain key file blocks.
+	KEY_FILE_TYPE = "CHACHA20 KEY"
+
+	// Type of the passphrase protected key file blocks.
+	ENCRYPTED_KEY_FILE_TYPE = "CHACHA20 ENCRYPTED KEY"
+
+	// Current version of the key file format.
+	KEY_FILE_VERSION = 1
+
+	// Size of the randomly generated key IDs in bytes.
+	KEY_ID_SIZE = 8
+
+	// Name of the only supported passphrase KDF.
+	KDF_PBKDF2_SHA256 = "pbkdf2-sha256"
+
+	// Default number of PBKDF2 iterations.
+	KDF_ITERATIONS = 600000
+
+	// Upper limit of PBKDF2 iterations accepted when loading a key,
+	// so a crafted key file can't stall LoadKey for minutes.
+	KDF_MAX_ITERATIONS = 2000000
+
+	// Size of the PBKDF2 salt in bytes.
+	KDF_SALT_SIZE = 16
+)
+
+const (
+	headerVersion       = "Version"
+	headerKeyID         = "Key-ID"
+	headerCreated       = "Created"
+	headerKDF           = "KDF"
+	headerKDFIterations = "KDF-Iterations"
+	headerKDFSalt       = "KDF-Salt"
+	headerNonce         = "Nonce"
+)
+
+var (
+	// Error returned if the key file is malformed.
+	ErrKeyFileFormat = errors.New("malformed key file")
+
+	// Error returned if the key file version is not supported.
+	ErrKeyFileVersion = errors.New("unsupported key file version")
+
+	// Error returned when loading an encrypted key file without a passphrase.
+	ErrPassphraseRequired = errors.New("key file is encrypted, passphrase required")
+
+	// Error returned if the key file is accessible by other users.
+	ErrKeyFilePermissions = errors.New("key file permissions too open, expected 0600")
+)
+
+// Number of PBKDF2 iterations used when saving new key files.
+var kdfIterations = KDF_ITERATIONS
+
+// Key is a raw 256-bit ChaCha20 key with its metadata.
+type Key struct {
+	ID      string
+	Created time.Time
+	Bytes   []byte
+}
+
+// GenerateKey returns a new random key with a random ID.
+func GenerateKey() (*Key, error) {
+	key, err := util.RandomBytes(KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := util.RandomBytes(KEY_ID_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:      hex.EncodeToString(id),
+		Created: time.Now().UTC().Truncate(time.Second),
+		Bytes:   key,
+	}, nil
+}
+
+// NewCipher initializes a cipher using the key as is (no hashing)
+// with a fresh random nonce. The key ID is set on the cipher.
+func (k *Key) NewCipher() (*Cipher, error) {
+	n, err := util.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newRawCipher(k.Bytes, n.Bytes[:])
+	if err != nil {
+		return nil, err
+	}
+
+	c.KeyID = []byte(k.ID)
+
+	return c, nil
+}
+
+// Clear sets all bytes of the key to 0x00.
+func (k *Key) Clear() {
+	util.Wipe(k.Bytes)
+}
+
+// Marshal encodes the key in the key file format.
+// If the passphrase is not empty, the key is encrypted with it.
+func (k *Key) Marshal(passphrase []byte) ([]byte, error) {
+	if len(k.Bytes) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	b := armor.Block{
+		Type: KEY_FILE_TYPE,
+		Headers: map[string]string{
+			headerVersion: strconv.Itoa(KEY_FILE_VERSION),
+			headerKeyID:   k.ID,
+			headerCreated: k.Created.UTC().Format(time.RFC3339),
+		},
+		Bytes: k.Bytes,
+	}
+
+	if len(passphrase) == 0 {
+		return armor.Encode(&b), nil
+	}
+
+	salt, err := util.RandomBytes(KDF_SALT_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := util.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	b.Type = ENCRYPTED_KEY_FILE_TYPE
+	b.Headers[headerKDF] = KDF_PBKDF2_SHA256
+	b.Headers[headerKDFIterations] = strconv.Itoa(kdfIterations)
+	b.Headers[headerKDFSalt] = base64.StdEncoding.EncodeToString(salt)
+	b.Headers[headerNonce] = base64.StdEncoding.EncodeToString(nonce.Bytes[:])
+
+	wrappingKey, err := pbkdf2.Key(passphrase, salt, kdfIterations, KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(wrappingKey)
+
+	b.Bytes, err = sealChaCha20Poly1305(wrappingKey, nonce.Bytes[:], k.Bytes, keyFileAAD(&b))
+	if err != nil {
+		return nil, err
+	}
+
+	return armor.Encode(&b), nil
+}
+
+// ParseKey decodes a key in the key file format.
+// The passphrase is required for encrypted key files and
+// ErrAuthFailed error is returned if it's wrong.
+func ParseKey(data []byte, passphrase []byte) (*Key, error) {
+	b, _, err := armor.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Type != KEY_FILE_TYPE && b.Type != ENCRYPTED_KEY_FILE_TYPE {
+		return nil, ErrKeyFileFormat
+	}
+
+	if b.Headers[headerVersion] != strconv.Itoa(KEY_FILE_VERSION) {
+		return nil, ErrKeyFileVersion
+	}
+
+	created, err := time.Parse(time.RFC3339, b.Headers[headerCreated])
+	if err != nil {
+		return nil, ErrKeyFileFormat
+	}
+
+	k := Key{
+		ID:      b.Headers[headerKeyID],
+		Created: created,
+		Bytes:   b.Bytes,
+	}
+
+	if b.Type == ENCRYPTED_KEY_FILE_TYPE {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+
+		if k.Bytes, err = openKeyFile(b, passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(k.Bytes) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	return &k, nil
+}
+
+// OpenKeyFile derives the wrapping key and decrypts the key file body.
+func openKeyFile(b *armor.Block, passphrase []byte) ([]byte, error) {
+	if b.Headers[headerKDF] != KDF_PBKDF2_SHA256 {
+		return nil, ErrKeyFileFormat
+	}
+
+	iter, err := strconv.Atoi(b.Headers[headerKDFIterations])
+	if err != nil || iter < 1 || iter > KDF_MAX_ITERATIONS {
+		return nil, ErrKeyFileFormat
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(b.Headers[headerKDFSalt])
+	if err != nil || len(salt) == 0 {
+		return nil, ErrKeyFileFormat
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(b.Headers[headerNonce])
+	if err != nil || len(nonce) != NONCE_SIZE {
+		return nil, ErrKeyFileFormat
+	}
+
+	wrappingKey, err := pbkdf2.Key(passphrase, salt, iter, KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(wrappingKey)
+
+	return openChaCha20Poly1305(wrappingKey, nonce, b.Bytes, keyFileAAD(b))
+}
+
+// KeyFileAAD serializes the headers in sorted order
+// to be authenticated as the associated data.
+func keyFileAAD(b *armor.Block) []byte {
+	keys := make([]string, 0, len(b.Headers))
+	for key := range b.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	aad := []byte(b.Type + "\n")
+	for _, key := range keys {
+		aad = append(aad, key+": "+b.Headers[key]+"\n"...)
+	}
+
+	return aad
+}
+
+// SaveKey writes the key file with 0600 permissions,
+// encrypting the key if the passphrase is not empty.
+// Existing files are overwritten.
+func SaveKey(path string, k *Key, passphrase []byte) error {
+	data, err := k.Marshal(passphrase)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	// OpenFile doesn't change the mode of existing files.
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LoadKey reads a key file written by SaveKey.
+// On Linux, ErrKeyFilePermissions error is returned if
+// the file is readable or writable by the group or others.
+func LoadKey(path string, passphrase []byte) (*Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The permissions are checked on the opened file, so it can't
+	// be replaced between the check and the read.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyFilePermissions(info); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseKey(data, passphrase)
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	k1, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(k1.Bytes) != KEY_SIZE || len(k1.ID) != 2*KEY_ID_SIZE {
+		t.Fatalf("unexpected key: %d byte key, id %q", len(k1.Bytes), k1.ID)
+	}
+
+	if bytes.Equal(k1.Bytes, k2.Bytes) || k1.ID == k2.ID {
+		t.Fatalf("two generated keys are equal")
+	}
+}
+
+func TestSaveLoadKey(t *testing.T) {
+	kdfIterations = 1000
+	defer func() { kdfIterations = KDF_ITERATIONS }()
+
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, passphrase := range [][]byte{nil, []byte("hunter2")} {
+		path := filepath.Join(t.TempDir(), "key")
+
+		if err := SaveKey(path, k, passphrase); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+			t.Fatalf("expected 0600 permissions, found %o", info.Mode().Perm())
+		}
+
+		loaded, err := LoadKey(path, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.ID != k.ID || !loaded.Created.Equal(k.Created) || !bytes.Equal(loaded.Bytes, k.Bytes) {
+			t.Fatalf("loaded key differs: %+v, expected %+v", loaded, k)
+		}
+
+		data, _ := os.ReadFile(path)
+		if len(passphrase) > 0 {
+			if !strings.HasPrefix(string(data), "-----BEGIN CHACHA20 ENCRYPTED KEY-----") {
+				t.Fatalf("unexpected encrypted key file:\n%s", data)
+			}
+
+			if _, err := LoadKey(path, nil); err != ErrPassphraseRequired {
+				t.Fatalf("expected ErrPassphraseRequired, found %v", err)
+			}
+
+			if _, err := LoadKey(path, []byte("hunter3")); err != ErrAuthFailed {
+				t.Fatalf("expected ErrAuthFailed for wrong passphrase, found %v", err)
+			}
+		}
+	}
+}
+
+func TestEncryptedKeyHeaders(t *testing.T) {
+	kdfIterations = 1000
+	defer func() { kdfIterations = KDF_ITERATIONS }()
+
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := k.Marshal([]byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := strings.Replace(string(data), "Key-ID: "+k.ID, "Key-ID: 0000000000000000", 1)
+	if _, err := ParseKey([]byte(tampered), []byte("hunter2")); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for modified key ID, found %v", err)
+	}
+
+	tampered = strings.Replace(string(data), "KDF-Iterations: 1000", "KDF-Iterations: 0", 1)
+	if _, err := ParseKey([]byte(tampered), []byte("hunter2")); err != ErrKeyFileFormat {
+		t.Fatalf("expected ErrKeyFileFormat for invalid iterations, found %v", err)
+	}
+
+	tampered = strings.Replace(string(data), "KDF-Iterations: 1000", "KDF-Iterations: 2000001", 1)
+	if _, err := ParseKey([]byte(tampered), []byte("hunter2")); err != ErrKeyFileFormat {
+		t.Fatalf("expected ErrKeyFileFormat for too many iterations, found %v", err)
+	}
+}
+
+func TestLoadKeyPermissions(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("permissions are only enforced on Linux")
+	}
+
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := SaveKey(path, k, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKey(path, nil); err != ErrKeyFilePermissions {
+		t.Fatalf("expected ErrKeyFilePermissions, found %v", err)
+	}
+
+	// SaveKey fixes the permissions of existing files.
+	if err := SaveKey(path, k, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKey(path, nil); err != nil {
+		t.Fatalf("expected the key to load after SaveKey, found %v", err)
+	}
+}
+
+func TestKeyNewCipher(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := k.NewCipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(c.Key, k.Bytes) || string(c.KeyID) != k.ID {
+		t.Fatalf("cipher doesn't use the raw key and its ID")
+	}
+
+	data, err := c.EncryptEnvelope(ALG_XCHACHA20_POLY1305, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(e.KeyID) != k.ID {
+		t.Fatalf("expected key ID %q in the envelope, found %q", k.ID, e.KeyID)
+	}
+}
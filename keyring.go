@@ -0,0 +1,358 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+var (
+	// Error returned if the keyring has no key with the requested ID.
+	ErrKeyNotFound = errors.New("key not found in the keyring")
+
+	// Error returned if a key with the same ID is already in the keyring.
+	ErrDuplicateKeyID = errors.New("duplicate key id")
+
+	// Error returned if the primary key is removed from the keyring.
+	ErrPrimaryKey = errors.New("can't remove the primary key")
+)
+
+// Keyring holds multiple keys by their IDs, one of which is primary.
+//
+// New data is encrypted with the primary key and its ID is recorded
+// in the envelope. Decryption selects the key by the envelope key ID,
+// so data encrypted before a rotation can still be decrypted.
+// Data encrypted with Encrypt before the keyring was introduced is
+// decrypted with the key set by SetLegacyKey.
+//
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	// Algorithm used for encryption, ALG_XCHACHA20_POLY1305 if not set.
+	Algorithm Algorithm
+
+	mu      sync.RWMutex
+	keys    map[string]*Key
+	primary string
+	legacy  []byte
+}
+
+// NewKeyring returns a keyring with the given keys.
+// The first key becomes primary.
+func NewKeyring(keys ...*Key) (*Keyring, error) {
+	kr := Keyring{keys: map[string]*Key{}}
+
+	for _, k := range keys {
+		if err := kr.Add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return &kr, nil
+}
+
+// Add adds the key to the keyring. The key becomes primary
+// only if the keyring was empty.
+func (kr *Keyring) Add(k *Key) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	return kr.add(k)
+}
+
+// Rotate generates a new key, adds it to the keyring
+// and makes it primary. Old keys are kept for decryption.
+func (kr *Keyring) Rotate() (*Key, error) {
+	k, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	// Both steps happen under the same lock, so no other
+	// goroutine can see the new key before it's primary.
+	if err := kr.add(k); err != nil {
+		return nil, err
+	}
+
+	kr.primary = k.ID
+
+	return k, nil
+}
+
+// Add adds the key to the keyring, kr.mu has to be locked.
+func (kr *Keyring) add(k *Key) error {
+	if len(k.Bytes) != KEY_SIZE {
+		return ErrKeySize
+	}
+
+	if len(k.ID) == 0 || len(k.ID) > MAX_KEY_ID_SIZE {
+		return ErrKeyIDSize
+	}
+
+	if kr.keys == nil {
+		kr.keys = map[string]*Key{}
+	}
+
+	if _, ok := kr.keys[k.ID]; ok {
+		return ErrDuplicateKeyID
+	}
+
+	kr.keys[k.ID] = k
+	if kr.primary == "" {
+		kr.primary = k.ID
+	}
+
+	return nil
+}
+
+// SetPrimary marks the key with the given ID as primary.
+func (kr *Keyring) SetPrimary(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+
+	kr.primary = id
+
+	return nil
+}
+
+// SetLegacyKey sets the key material which was passed to NewCipher
+// to encrypt data with Encrypt. That nonce|cipherText layout has no
+// key ID and no tag, so it's decrypted with this key and isn't
+// authenticated. A nil key removes the legacy key.
+func (kr *Keyring) SetLegacyKey(key []byte) error {
+	var legacy []byte
+
+	if key != nil {
+		c, err := NewCipher(key)
+		if err != nil {
+			return err
+		}
+		legacy = c.Key
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	util.Wipe(kr.legacy)
+	kr.legacy = legacy
+
+	return nil
+}
+
+// Primary returns the primary key or nil if the keyring is empty.
+func (kr *Keyring) Primary() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.keys[kr.primary]
+}
+
+// Get returns the key with the given ID.
+func (kr *Keyring) Get(id string) (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return k, nil
+}
+
+// Remove removes the key with the given ID and clears it.
+// Data encrypted with it can't be decrypted anymore.
+func (kr *Keyring) Remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	k, ok := kr.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	if id == kr.primary {
+		return ErrPrimaryKey
+	}
+
+	delete(kr.keys, id)
+	k.Clear()
+
+	return nil
+}
+
+// PrimaryCopy returns a copy of the primary key. Keys are copied
+// while kr.mu is held, so a concurrent Remove can't clear them while
+// they are used. The caller clears the copy.
+func (kr *Keyring) primaryCopy() (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.copyKey(kr.primary)
+}
+
+// KeyCopy returns a copy of the key with the given ID, like primaryCopy.
+func (kr *Keyring) keyCopy(id string) (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.copyKey(id)
+}
+
+// CopyKey copies the key with the given ID, kr.mu has to be locked.
+func (kr *Keyring) copyKey(id string) (*Key, error) {
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	c := *k
+	c.Bytes = append([]byte(nil), k.Bytes...)
+
+	return &c, nil
+}
+
+// LegacyCipher creates a cipher with the legacy key.
+func (kr *Keyring) legacyCipher() (*Cipher, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.legacy == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return newRawCipher(kr.legacy, make([]byte, NONCE_SIZE))
+}
+
+// IDs returns the IDs of all the keys in the keyring.
+func (kr *Keyring) IDs() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Encrypt encrypts the plainText with the primary key
+// and returns an envelope carrying the primary key ID.
+func (kr *Keyring) Encrypt(plainText []byte) ([]byte, error) {
+	return kr.EncryptWithAAD(plainText, nil)
+}
+
+// EncryptWithAAD works like Encrypt and binds the envelope
+// to the additionalData, for example the output of ContextAAD.
+//
+// ErrUnauthenticated error is returned if the keyring uses
+// an unauthenticated algorithm, which Decrypt would reject.
+func (kr *Keyring) EncryptWithAAD(plainText []byte, additionalData []byte) ([]byte, error) {
+	alg := kr.Algorithm
+	if alg == 0 {
+		alg = ALG_XCHACHA20_POLY1305
+	}
+
+	if alg.NonceSize() != 0 && alg.TagSize() == 0 {
+		return nil, ErrUnauthenticated
+	}
+
+	k, err := kr.primaryCopy()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Clear()
+
+	c, err := k.NewCipher()
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	return c.EncryptEnvelope(alg, plainText, additionalData)
+}
+
+// Decrypt decrypts an envelope with the key matching its key ID.
+//
+// ErrKeyNotFound error is returned for envelopes without a key ID
+// and unknown key IDs. The key ID is authenticated by the tag, so
+// changing it to the ID of another key fails too. Data which doesn't
+// start with ENVELOPE_MAGIC is decrypted as the nonce|cipherText layout
+// of Encrypt with the legacy key, if there is one. Other malformed
+// data fails with the ParseEnvelope error.
+func (kr *Keyring) Decrypt(cipherText []byte) ([]byte, error) {
+	return kr.DecryptWithAAD(cipherText, nil)
+}
+
+// DecryptWithAAD decrypts an envelope created by EncryptWithAAD.
+// The additionalData must match the one used during encryption.
+func (kr *Keyring) DecryptWithAAD(cipherText []byte, additionalData []byte) ([]byte, error) {
+	e, err := ParseEnvelope(cipherText)
+	if err != nil && !bytes.HasPrefix(cipherText, []byte(ENVELOPE_MAGIC)) {
+		return kr.decryptLegacy(cipherText, additionalData, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := kr.keyCopy(string(e.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	defer k.Clear()
+
+	c, err := k.NewCipher()
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	return c.DecryptEnvelope(cipherText, additionalData)
+}
+
+// DecryptLegacy decrypts the nonce|cipherText layout with the legacy key.
+// Without a legacy key the ParseEnvelope error is returned.
+func (kr *Keyring) decryptLegacy(cipherText []byte, additionalData []byte, parseErr error) ([]byte, error) {
+	c, err := kr.legacyCipher()
+	if err != nil {
+		return nil, parseErr
+	}
+	defer c.ClearKey()
+
+	// The legacy layout can't authenticate associated data.
+	if len(additionalData) != 0 {
+		return nil, ErrAADSize
+	}
+
+	return c.Decrypt(cipherText)
+}
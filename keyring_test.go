@@ -0,0 +1,265 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	first, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyring(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := kr.Encrypt([]byte("encrypted before the rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := kr.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kr.Primary() != second {
+		t.Fatalf("rotated key is not primary")
+	}
+
+	current, err := kr.Encrypt([]byte("encrypted after the rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseEnvelope(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(e.KeyID) != second.ID || e.Algorithm != ALG_XCHACHA20_POLY1305 {
+		t.Fatalf("expected key ID %q with XChaCha20-Poly1305, found %q with %v", second.ID, e.KeyID, e.Algorithm)
+	}
+
+	for cipherText, expected := range map[*[]byte]string{
+		&old:     "encrypted before the rotation",
+		&current: "encrypted after the rotation",
+	} {
+		plainText, err := kr.Decrypt(*cipherText)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plainText) != expected {
+			t.Fatalf("expected %q, found %q", expected, plainText)
+		}
+	}
+
+	if err := kr.Remove(second.ID); err != ErrPrimaryKey {
+		t.Fatalf("expected ErrPrimaryKey, found %v", err)
+	}
+
+	if err := kr.Remove(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kr.Decrypt(old); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound after removing the key, found %v", err)
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kr Keyring
+
+	if _, err := kr.Encrypt([]byte("data")); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound for an empty keyring, found %v", err)
+	}
+
+	if err := kr.Add(k); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Add(k); err != ErrDuplicateKeyID {
+		t.Fatalf("expected ErrDuplicateKeyID, found %v", err)
+	}
+
+	if err := kr.Add(&Key{Bytes: make([]byte, KEY_SIZE)}); err != ErrKeyIDSize {
+		t.Fatalf("expected ErrKeyIDSize for a key without ID, found %v", err)
+	}
+
+	if err := kr.SetPrimary("missing"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, found %v", err)
+	}
+
+	c, err := NewCipher([]byte("not in the keyring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := c.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kr.Decrypt(legacy); err != ErrEnvelopeFormat {
+		t.Fatalf("expected ErrEnvelopeFormat for the legacy layout, found %v", err)
+	}
+}
+
+func TestKeyringLegacy(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyring(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("key used before the keyring")
+
+	c, err := NewCipher(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := c.Encrypt([]byte("legacy data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.SetLegacyKey(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	plainText, err := kr.Decrypt(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plainText) != "legacy data" {
+		t.Fatalf("expected %q, found %q", "legacy data", plainText)
+	}
+
+	if _, err := kr.DecryptWithAAD(legacy, []byte("aad")); err != ErrAADSize {
+		t.Fatalf("expected ErrAADSize for the legacy layout with AAD, found %v", err)
+	}
+
+	// Envelopes still go through the keyring.
+	current, err := kr.Encrypt([]byte("current data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plainText, err := kr.Decrypt(current); err != nil || string(plainText) != "current data" {
+		t.Fatalf("decryption failed: %q, %v", plainText, err)
+	}
+
+	if _, err := kr.Decrypt(current[:len(ENVELOPE_MAGIC)+1]); err != ErrEnvelopeFormat {
+		t.Fatalf("expected ErrEnvelopeFormat for a truncated envelope, found %v", err)
+	}
+
+	if err := kr.SetLegacyKey(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kr.Decrypt(legacy); err != ErrEnvelopeFormat {
+		t.Fatalf("expected ErrEnvelopeFormat after removing the legacy key, found %v", err)
+	}
+}
+
+func TestKeyringKeyIDBinding(t *testing.T) {
+	// The same key material under two IDs of the same length,
+	// so only the authenticated key ID tells the envelopes apart.
+	material := make([]byte, KEY_SIZE)
+
+	kr, err := NewKeyring(
+		&Key{ID: "key-a", Bytes: append([]byte(nil), material...)},
+		&Key{ID: "key-b", Bytes: append([]byte(nil), material...)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipherText, err := kr.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseEnvelope(cipherText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.KeyID = []byte("key-b")
+
+	swapped, err := e.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kr.Decrypt(swapped); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for a changed key ID, found %v", err)
+	}
+}
+
+func TestKeyringAlgorithm(t *testing.T) {
+	k, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyring(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.Algorithm = ALG_CHACHA20_POLY1305
+
+	cipherText, err := kr.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, _ := ParseEnvelope(cipherText); e.Algorithm != ALG_CHACHA20_POLY1305 {
+		t.Fatalf("expected %v, found %v", ALG_CHACHA20_POLY1305, e.Algorithm)
+	}
+
+	plainText, err := kr.Decrypt(cipherText)
+	if err != nil || !bytes.Equal(plainText, []byte("data")) {
+		t.Fatalf("decryption failed: %q, %v", plainText, err)
+	}
+
+	kr.Algorithm = ALG_XCHACHA20
+	if _, err := kr.Encrypt([]byte("data")); err != ErrUnauthenticated {
+		t.Fatalf("expected ErrUnauthenticated, found %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/wedkarz02/chacha20/pkg/poly"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// OpenSSHCipher implements the chacha20-poly1305@openssh.com packet
+// cipher. The 64-byte key is split into K_2 (the first half), which
+// encrypts the packet body and derives the Poly1305 key, and K_1 (the
+// second half), which only encrypts the 4-byte packet length, so the
+// receiver can decrypt the length before the rest of the packet arrives.
+//
+// Both use the original ChaCha20 with the 64-bit big endian packet
+// sequence number as the nonce. The Poly1305 key comes from block 0 of
+// K_2, the body is encrypted from block 1 and the tag covers the
+// encrypted length and body:
+//
+//	encrypted length (4) | encrypted body | tag (16)
+//
+// https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.chacha20poly1305
+type OpenSSHCipher struct {
+	payloadKey [KEY_SIZE]byte
+	lengthKey  [KEY_SIZE]byte
+}
+
+const (
+	// Size of the chacha20-poly1305@openssh.com key in bytes.
+	OPENSSH_KEY_SIZE = 2 * KEY_SIZE
+
+	// Size of the packet length field in bytes.
+	OPENSSH_LENGTH_SIZE = 4
+
+	// Largest packet length accepted, the same limit as OpenSSH.
+	OPENSSH_MAX_PACKET_SIZE = 256 * 1024
+
+	// Packets are padded to a multiple of 8 bytes (RFC 4253 section 6),
+	// not counting the length field, with at least 4 bytes of padding.
+	opensshPaddingMultiple = 8
+	opensshMinPadding      = 4
+)
+
+var (
+	// Error returned if the decrypted packet length is invalid.
+	ErrPacketSize = errors.New("invalid ssh packet length")
+
+	// Error returned if the decrypted packet has invalid padding.
+	ErrPacketPadding = errors.New("invalid ssh packet padding")
+)
+
+// NewOpenSSHCipher returns the packet cipher for one direction
+// of an SSH connection using the 64-byte key from the key exchange.
+func NewOpenSSHCipher(key []byte) (*OpenSSHCipher, error) {
+	if len(key) != OPENSSH_KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	var c OpenSSHCipher
+	copy(c.payloadKey[:], key[:KEY_SIZE])
+	copy(c.lengthKey[:], key[KEY_SIZE:])
+
+	return &c, nil
+}
+
+// Seal builds the binary packet for the payload with random padding
+// (RFC 4253 section 6), encrypts and authenticates it
+// with the packet sequence number.
+func (c *OpenSSHCipher) Seal(seq uint32, payload []byte) ([]byte, error) {
+	return c.seal(rand.Reader, seq, payload)
+}
+
+// Seal reads the padding from the random source,
+// so the packets are reproducible in tests.
+func (c *OpenSSHCipher) seal(random io.Reader, seq uint32, payload []byte) ([]byte, error) {
+	padding := opensshPaddingMultiple - (1+len(payload))%opensshPaddingMultiple
+	if padding < opensshMinPadding {
+		padding += opensshPaddingMultiple
+	}
+
+	length := 1 + len(payload) + padding
+	if length > OPENSSH_MAX_PACKET_SIZE {
+		return nil, ErrPacketSize
+	}
+
+	packet := make([]byte, OPENSSH_LENGTH_SIZE+length)
+	binary.BigEndian.PutUint32(packet, uint32(length))
+	packet[OPENSSH_LENGTH_SIZE] = byte(padding)
+	copy(packet[OPENSSH_LENGTH_SIZE+1:], payload)
+
+	if _, err := io.ReadFull(random, packet[OPENSSH_LENGTH_SIZE+1+len(payload):]); err != nil {
+		return nil, err
+	}
+
+	encryptedLength, err := c.xorLength(seq, packet[:OPENSSH_LENGTH_SIZE])
+	if err != nil {
+		return nil, err
+	}
+
+	p := c.payloadCipher(seq)
+	defer p.ClearKey()
+
+	body, err := p.xorKeyStream(packet[OPENSSH_LENGTH_SIZE:], 1)
+	util.Wipe(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := append(encryptedLength, body...)
+
+	tag, err := poly.Sum(sealed, p.polyKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return append(sealed, tag[:]...), nil
+}
+
+// PacketLength decrypts the first 4 bytes of a packet. The rest of the
+// packet is the returned number of bytes followed by the tag.
+// The length isn't authenticated until the whole packet is opened.
+//
+// ErrPacketSize error is returned if the length
+// exceeds OPENSSH_MAX_PACKET_SIZE.
+func (c *OpenSSHCipher) PacketLength(seq uint32, encryptedLength []byte) (uint32, error) {
+	if len(encryptedLength) < OPENSSH_LENGTH_SIZE {
+		return 0, ErrPacketSize
+	}
+
+	b, err := c.xorLength(seq, encryptedLength[:OPENSSH_LENGTH_SIZE])
+	if err != nil {
+		return 0, err
+	}
+
+	length := binary.BigEndian.Uint32(b)
+	if length > OPENSSH_MAX_PACKET_SIZE {
+		return 0, ErrPacketSize
+	}
+
+	return length, nil
+}
+
+// Open verifies and decrypts a whole packet created by Seal (or an SSH
+// peer) with the packet sequence number and returns its payload.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No payload is released in that case.
+func (c *OpenSSHCipher) Open(seq uint32, packet []byte) ([]byte, error) {
+	length, err := c.PacketLength(seq, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(packet)) != OPENSSH_LENGTH_SIZE+uint64(length)+TAG_SIZE {
+		return nil, ErrPacketSize
+	}
+
+	p := c.payloadCipher(seq)
+	defer p.ClearKey()
+
+	sealed := packet[:len(packet)-TAG_SIZE]
+	tag := packet[len(packet)-TAG_SIZE:]
+
+	expected, err := poly.Sum(sealed, p.polyKey())
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(tag, expected[:]) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	body, err := p.xorKeyStream(sealed[OPENSSH_LENGTH_SIZE:], 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, ErrPacketPadding
+	}
+
+	padding := int(body[0])
+	if padding < opensshMinPadding || padding+1 > len(body) {
+		return nil, ErrPacketPadding
+	}
+
+	return body[1 : len(body)-padding], nil
+}
+
+// Clear sets both keys to 0x00.
+func (c *OpenSSHCipher) Clear() {
+	util.Wipe(c.payloadKey[:])
+	util.Wipe(c.lengthKey[:])
+}
+
+// XorLength encrypts/decrypts the length field with K_1.
+func (c *OpenSSHCipher) xorLength(seq uint32, length []byte) ([]byte, error) {
+	l, err := newRawCipher(c.lengthKey[:], opensshNonce(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer l.ClearKey()
+
+	return l.xorKeyStream(length, 0)
+}
+
+// PayloadCipher returns the K_2 cipher for the packet.
+func (c *OpenSSHCipher) payloadCipher(seq uint32) *Cipher {
+	// The key and the nonce have fixed sizes, so this can't fail.
+	p, _ := newRawCipher(c.payloadKey[:], opensshNonce(seq))
+
+	return p
+}
+
+// OpensshNonce converts the sequence number into the 96-bit nonce.
+// The 64-bit nonce of the original ChaCha20 fills the last 8 bytes,
+// the first 4 bytes stand for the upper half of its 64-bit counter.
+func opensshNonce(seq uint32) []byte {
+	nonce := make([]byte, NONCE_SIZE)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(seq))
+
+	return nonce
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+	"github.com/wedkarz02/chacha20/pkg/poly"
+)
+
+// Packets sent by the OpenSSH client, with the key logged by the server.
+type openSSHTranscript struct {
+	Source  string `json:"source"`
+	Key     string `json:"key"`
+	Packets []struct {
+		Seq     uint32 `json:"seq"`
+		Packet  string `json:"packet"`
+		Payload string `json:"payload"`
+	} `json:"packets"`
+}
+
+func TestOpenSSHTranscript(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "openssh", "chacha20_poly1305_transcript.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f openSSHTranscript
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Packets) == 0 {
+		t.Fatal("no packets found")
+	}
+
+	c, err := NewOpenSSHCipher(testutil.DecodeHex(t, f.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range f.Packets {
+		packet := testutil.DecodeHex(t, p.Packet)
+		expected := testutil.DecodeHex(t, p.Payload)
+
+		length, err := c.PacketLength(p.Seq, packet[:OPENSSH_LENGTH_SIZE])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int(length) != len(packet)-OPENSSH_LENGTH_SIZE-TAG_SIZE {
+			t.Fatalf("packet %d: expected length %d, found %d", p.Seq, len(packet)-OPENSSH_LENGTH_SIZE-TAG_SIZE, length)
+		}
+
+		payload, err := c.Open(p.Seq, packet)
+		if err != nil {
+			t.Fatalf("packet %d: %v", p.Seq, err)
+		}
+
+		if !bytes.Equal(payload, expected) {
+			t.Fatalf("packet %d: payload mismatch: expected %x, found %x", p.Seq, expected, payload)
+		}
+
+		// Sealing with the same padding bytes gives the captured packet.
+		body, err := c.payloadCipher(p.Seq).xorKeyStream(packet[OPENSSH_LENGTH_SIZE:len(packet)-TAG_SIZE], 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		padding := body[len(body)-int(body[0]):]
+
+		sealed, err := c.seal(bytes.NewReader(padding), p.Seq, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(sealed, packet) {
+			t.Fatalf("packet %d: seal mismatch:\nexpected %x\nfound    %x", p.Seq, packet, sealed)
+		}
+
+		// The sequence number is part of the nonce.
+		if _, err := c.Open(p.Seq+1, packet); err == nil {
+			t.Fatalf("packet %d: opened with the wrong sequence number", p.Seq)
+		}
+	}
+}
+
+func TestOpenSSHCipher(t *testing.T) {
+	key := append(testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"),
+		testutil.DecodeHex(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")...)
+
+	c, err := NewOpenSSHCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1, 3, 4, 7, 8, 100, 4096} {
+		payload := bytes.Repeat([]byte{0x5a}, size)
+
+		packet, err := c.Seal(0xffffffff, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		length := len(packet) - OPENSSH_LENGTH_SIZE - TAG_SIZE
+		if length%opensshPaddingMultiple != 0 || length-1-size < opensshMinPadding {
+			t.Fatalf("payload of %d bytes: invalid padding, packet length %d", size, length)
+		}
+
+		opened, err := c.Open(0xffffffff, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(opened, payload) {
+			t.Fatalf("payload of %d bytes: open mismatch", size)
+		}
+	}
+
+	packet, err := c.Seal(7, aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modified lengths are caught by the size check or the tag.
+	for i := range packet {
+		modified := append([]byte(nil), packet...)
+		modified[i] ^= 0x01
+
+		if _, err := c.Open(7, modified); err != ErrAuthFailed && err != ErrPacketSize {
+			t.Fatalf("byte %d: expected ErrAuthFailed or ErrPacketSize, found %v", i, err)
+		}
+	}
+
+	if _, err := c.Open(7, packet[:len(packet)-1]); err != ErrPacketSize {
+		t.Fatalf("expected ErrPacketSize for truncated packet, found %v", err)
+	}
+
+	if _, err := c.PacketLength(7, packet[:OPENSSH_LENGTH_SIZE-1]); err != ErrPacketSize {
+		t.Fatalf("expected ErrPacketSize for short length, found %v", err)
+	}
+
+	if _, err := c.Seal(0, make([]byte, OPENSSH_MAX_PACKET_SIZE)); err != ErrPacketSize {
+		t.Fatalf("expected ErrPacketSize for large payload, found %v", err)
+	}
+
+	if _, err := NewOpenSSHCipher(key[:KEY_SIZE]); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
+
+func TestOpenSSHPadding(t *testing.T) {
+	key := make([]byte, OPENSSH_KEY_SIZE)
+
+	c, err := NewOpenSSHCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Authentic packets with a padding length below 4
+	// or longer than the packet are still rejected.
+	for _, padding := range []byte{3, 255} {
+		body := []byte{padding, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}
+
+		length, err := c.xorLength(0, []byte{0, 0, 0, byte(len(body))})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := c.payloadCipher(0)
+		encrypted, err := p.xorKeyStream(body, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sealed := append(length, encrypted...)
+		tag, err := poly.Sum(sealed, p.polyKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Open(0, append(sealed, tag[:]...)); err != ErrPacketPadding {
+			t.Fatalf("padding %d: expected ErrPacketPadding, found %v", padding, err)
+		}
+	}
+}
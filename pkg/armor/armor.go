@@ -0,0 +1,286 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package armor implements a PEM-style ASCII armor for binary data.
+//
+// An armored block looks like this:
+//
+//	-----BEGIN CHACHA20 MESSAGE-----
+//	Version: 1
+//
+//	<base64 data wrapped at 64 characters>
+//	=<base64 CRC-24 checksum>
+//	-----END CHACHA20 MESSAGE-----
+//
+// The checksum line is the OpenPGP CRC-24 of the decoded data
+// and is used to detect copy/paste damage. Unlike in OpenPGP,
+// it's required: a block without it is rejected, since a lost
+// checksum line is itself copy/paste damage.
+//
+// https://datatracker.ietf.org/doc/html/rfc4880#section-6
+package armor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// Length of the base64 lines.
+	LINE_LENGTH = 64
+
+	// Number of data bytes encoded in a single line.
+	lineBytes = LINE_LENGTH / 4 * 3
+
+	beginPrefix = "-----BEGIN "
+	endPrefix   = "-----END "
+	dashes      = "-----"
+)
+
+var (
+	// Error returned if no armored block is found in the input.
+	ErrNoBlock = errors.New("armor: no armored block found")
+
+	// Error returned if the block is malformed.
+	ErrFormat = errors.New("armor: malformed armored block")
+
+	// Error returned if the checksum doesn't match the data.
+	ErrChecksum = errors.New("armor: checksum mismatch")
+
+	// Error returned if the block has no checksum line.
+	ErrNoChecksum = errors.New("armor: missing checksum")
+)
+
+// Block is a single armored block.
+type Block struct {
+	Type    string
+	Headers map[string]string
+	Bytes   []byte
+}
+
+// Encode returns the armored form of the block.
+func Encode(b *Block) []byte {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf, b.Type, b.Headers)
+	w.Write(b.Bytes)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+// Decode finds the next armored block in data and returns it
+// along with the rest of the input after the block.
+// Leading and trailing whitespace on every line is ignored,
+// so blocks pasted with indentation or CRLF line endings decode fine.
+func Decode(data []byte) (*Block, []byte, error) {
+	lines := strings.Split(string(data), "\n")
+
+	start := -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, beginPrefix) && strings.HasSuffix(line, dashes) {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 {
+		return nil, data, ErrNoBlock
+	}
+
+	beginLine := strings.TrimSpace(lines[start])
+	b := Block{
+		Type:    beginLine[len(beginPrefix) : len(beginLine)-len(dashes)],
+		Headers: map[string]string{},
+	}
+
+	i := start + 1
+
+	// Headers are optional, they end with an empty line.
+	if i < len(lines) && strings.Contains(lines[i], ":") {
+		for ; i < len(lines); i++ {
+			line := strings.TrimSpace(lines[i])
+			if line == "" {
+				i++
+				break
+			}
+
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, data, ErrFormat
+			}
+
+			b.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	var body strings.Builder
+	var checksum string
+	endLine := endPrefix + b.Type + dashes
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+
+		switch {
+		case line == endLine:
+			decoded, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil {
+				return nil, data, ErrFormat
+			}
+
+			if checksum == "" {
+				return nil, data, ErrNoChecksum
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(checksum)
+			if err != nil || len(sum) != 3 {
+				return nil, data, ErrFormat
+			}
+
+			crc := crc24(crc24Init, decoded)
+			if sum[0] != byte(crc>>16) || sum[1] != byte(crc>>8) || sum[2] != byte(crc) {
+				return nil, data, ErrChecksum
+			}
+
+			b.Bytes = decoded
+			rest := []byte(strings.Join(lines[i+1:], "\n"))
+
+			return &b, rest, nil
+		case checksum != "":
+			// Nothing but the end line may follow the checksum.
+			return nil, data, ErrFormat
+		case strings.HasPrefix(line, "="):
+			checksum = line[1:]
+		default:
+			body.WriteString(line)
+		}
+	}
+
+	return nil, data, ErrFormat
+}
+
+// Writer encodes the data written to it as an armored block.
+// The block is finished by Close, which writes the
+// checksum and the end line.
+type Writer struct {
+	w         io.Writer
+	blockType string
+	headers   map[string]string
+	started   bool
+	buf       []byte
+	crc       uint32
+	err       error
+}
+
+// NewWriter returns a streaming armor encoder writing to w.
+// Headers are written in sorted order.
+func NewWriter(w io.Writer, blockType string, headers map[string]string) *Writer {
+	return &Writer{
+		w:         w,
+		blockType: blockType,
+		headers:   headers,
+		crc:       crc24Init,
+	}
+}
+
+// Write encodes p, emitting every full line right away.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.writeHeader()
+	w.crc = crc24(w.crc, p)
+	w.buf = append(w.buf, p...)
+
+	for len(w.buf) >= lineBytes && w.err == nil {
+		w.writeLine(w.buf[:lineBytes])
+		w.buf = w.buf[lineBytes:]
+	}
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the remaining data, the checksum and the end line.
+// It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.writeHeader()
+
+	if len(w.buf) > 0 {
+		w.writeLine(w.buf)
+		w.buf = nil
+	}
+
+	sum := []byte{byte(w.crc >> 16), byte(w.crc >> 8), byte(w.crc)}
+	w.writeString("=" + base64.StdEncoding.EncodeToString(sum) + "\n")
+	w.writeString(endPrefix + w.blockType + dashes + "\n")
+
+	return w.err
+}
+
+// WriteHeader writes the begin line and the headers once.
+func (w *Writer) writeHeader() {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	w.writeString(beginPrefix + w.blockType + dashes + "\n")
+
+	if len(w.headers) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(w.headers))
+	for key := range w.headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		w.writeString(key + ": " + w.headers[key] + "\n")
+	}
+	w.writeString("\n")
+}
+
+func (w *Writer) writeLine(data []byte) {
+	w.writeString(base64.StdEncoding.EncodeToString(data) + "\n")
+}
+
+func (w *Writer) writeString(s string) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = io.WriteString(w.w, s)
+}
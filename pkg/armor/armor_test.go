@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package armor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCrc24(t *testing.T) {
+	// CRC-24/OPENPGP check value.
+	if crc := crc24(crc24Init, []byte("123456789")); crc != 0x21cf02 {
+		t.Fatalf("crc24 mismatch: expected 21cf02, found %06x", crc)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	headers := map[string]string{"Version": "1", "Comment": "test"}
+
+	for _, size := range []int{0, 1, 47, 48, 49, 100, 1000} {
+		data := bytes.Repeat([]byte{0xa5, 0x01, 0x7f}, size)[:size]
+
+		armored := Encode(&Block{Type: "TEST", Headers: headers, Bytes: data})
+
+		for _, line := range strings.Split(string(armored), "\n") {
+			if len(line) > LINE_LENGTH {
+				t.Fatalf("size %d: line longer than %d characters: %q", size, LINE_LENGTH, line)
+			}
+		}
+
+		b, rest, err := Decode(armored)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if b.Type != "TEST" || b.Headers["Version"] != "1" || b.Headers["Comment"] != "test" {
+			t.Fatalf("size %d: unexpected block header: %+v", size, b)
+		}
+
+		if !bytes.Equal(b.Bytes, data) {
+			t.Fatalf("size %d: data mismatch", size)
+		}
+
+		if len(bytes.TrimSpace(rest)) != 0 {
+			t.Fatalf("size %d: unexpected rest %q", size, rest)
+		}
+	}
+}
+
+func TestWriterSplits(t *testing.T) {
+	data := bytes.Repeat([]byte("streaming armor "), 20)
+	expected := Encode(&Block{Type: "TEST", Bytes: data})
+
+	for _, split := range []int{1, 7, 48, 100} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, "TEST", nil)
+
+		for i := 0; i < len(data); i += split {
+			end := i + split
+			if end > len(data) {
+				end = len(data)
+			}
+
+			if _, err := w.Write(data[i:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Fatalf("split %d: streamed output differs from Encode", split)
+		}
+	}
+}
+
+func TestDecodePasted(t *testing.T) {
+	armored := Encode(&Block{Type: "TEST", Headers: map[string]string{"Version": "1"}, Bytes: []byte("pasted into a ticket")})
+
+	pasted := "Hi, here is the secret:\r\n\r\n"
+	for _, line := range strings.Split(string(armored), "\n") {
+		pasted += "    " + line + "\r\n"
+	}
+	pasted += "Thanks!\r\n"
+
+	b, rest, err := Decode([]byte(pasted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b.Bytes) != "pasted into a ticket" {
+		t.Fatalf("unexpected data %q", b.Bytes)
+	}
+
+	if !strings.Contains(string(rest), "Thanks!") {
+		t.Fatalf("rest should contain the text after the block, found %q", rest)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	armored := string(Encode(&Block{Type: "TEST", Bytes: []byte("some data that spans a few bytes")}))
+
+	lines := strings.Split(armored, "\n")
+	damaged := strings.Replace(armored, lines[1], strings.ToUpper(lines[1]), 1)
+
+	// The checksum is the second to last line.
+	noChecksum := strings.Join(append(lines[:len(lines)-3:len(lines)-3], lines[len(lines)-2:]...), "\n")
+
+	testVectors := []struct {
+		name     string
+		data     string
+		expected error
+	}{
+		{"no block", "just text", ErrNoBlock},
+		{"damaged body", damaged, ErrChecksum},
+		{"missing end", strings.Join(lines[:len(lines)-2], "\n"), ErrFormat},
+		{"bad base64", strings.Replace(armored, lines[1], "!!!!", 1), ErrFormat},
+		{"no checksum", noChecksum, ErrNoChecksum},
+	}
+
+	for _, tv := range testVectors {
+		if _, _, err := Decode([]byte(tv.data)); err != tv.expected {
+			t.Fatalf("%s: expected %v, found %v", tv.name, tv.expected, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package arx implements the add-rotate-xor quarter rounds
+// shared by the ChaCha cipher and the BLAKE2s hash function,
+// and the Salsa20 quarter round ChaCha was derived from.
+//
+// BLAKE2s's G function is the ChaCha quarter round with a message
+// word added in each half and right rotations by 16, 12, 8 and 7,
+// which are left rotations by 16, 20, 24 and 25.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.1
+// https://datatracker.ietf.org/doc/html/rfc7693#section-3.1
+// https://cr.yp.to/snuffle/spec.pdf
+package arx
+
+import (
+	"math/bits"
+)
+
+// Number of 32-bit words in the ChaCha, Salsa20 and BLAKE2s working state.
+const STATE_SIZE = 16
+
+// Rotations holds the left rotation amounts of the four quarter round steps.
+type Rotations [4]int
+
+var (
+	// Rotations used by ChaCha.
+	CHACHA = Rotations{16, 12, 8, 7}
+
+	// Rotations used by BLAKE2s.
+	BLAKE2S = Rotations{16, 20, 24, 25}
+)
+
+// QuarterRound mixes the words a, b, c and d of the state.
+// The words x and y are added to a in the first and the
+// second half respectively. ChaCha uses x = y = 0.
+func QuarterRound(s *[STATE_SIZE]uint32, a, b, c, d int, x, y uint32, r *Rotations) {
+	s[a] += s[b] + x
+	s[d] ^= s[a]
+	s[d] = bits.RotateLeft32(s[d], r[0])
+	s[c] += s[d]
+	s[b] ^= s[c]
+	s[b] = bits.RotateLeft32(s[b], r[1])
+	s[a] += s[b] + y
+	s[d] ^= s[a]
+	s[d] = bits.RotateLeft32(s[d], r[2])
+	s[c] += s[d]
+	s[b] ^= s[c]
+	s[b] = bits.RotateLeft32(s[b], r[3])
+}
+
+// SalsaQuarterRound mixes the words a, b, c and d of the state
+// with the Salsa20 quarter round. Unlike ChaCha, each step
+// updates a different word using the sum of the two previous ones.
+func SalsaQuarterRound(s *[STATE_SIZE]uint32, a, b, c, d int) {
+	s[b] ^= bits.RotateLeft32(s[a]+s[d], 7)
+	s[c] ^= bits.RotateLeft32(s[b]+s[a], 9)
+	s[d] ^= bits.RotateLeft32(s[c]+s[b], 13)
+	s[a] ^= bits.RotateLeft32(s[d]+s[c], 18)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package arx
+
+import (
+	"testing"
+)
+
+func TestQuarterRound(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#section-2.1.1
+	var s [STATE_SIZE]uint32
+	s[0], s[1], s[2], s[3] = 0x11111111, 0x01020304, 0x9b8d6f43, 0x01234567
+
+	QuarterRound(&s, 0, 1, 2, 3, 0, 0, &CHACHA)
+
+	expected := [4]uint32{0xea2a92f4, 0xcb1cf8ce, 0x4581472e, 0x5881c4bb}
+	for i, word := range expected {
+		if s[i] != word {
+			t.Fatalf("word %d: expected %08x, found %08x", i, word, s[i])
+		}
+	}
+}
+
+func TestSalsaQuarterRound(t *testing.T) {
+	// https://cr.yp.to/snuffle/spec.pdf (section 3, quarterround examples)
+	tests := []struct {
+		input    [4]uint32
+		expected [4]uint32
+	}{
+		{[4]uint32{0x00000000, 0x00000000, 0x00000000, 0x00000000}, [4]uint32{0x00000000, 0x00000000, 0x00000000, 0x00000000}},
+		{[4]uint32{0x00000001, 0x00000000, 0x00000000, 0x00000000}, [4]uint32{0x08008145, 0x00000080, 0x00010200, 0x20500000}},
+		{[4]uint32{0x00000000, 0x00000001, 0x00000000, 0x00000000}, [4]uint32{0x88000100, 0x00000001, 0x00000200, 0x00402000}},
+		{[4]uint32{0xe7e8c006, 0xc4f9417d, 0x6479b4b2, 0x68c67137}, [4]uint32{0xe876d72b, 0x9361dfd5, 0xf1460244, 0x948541a3}},
+		{[4]uint32{0xd3917c5b, 0x55f1c407, 0x52a58a7a, 0x8f887a3b}, [4]uint32{0x3e2f308c, 0xd90a8f36, 0x6ab2a923, 0x2883524c}},
+	}
+
+	for i, tc := range tests {
+		var s [STATE_SIZE]uint32
+		copy(s[:4], tc.input[:])
+
+		SalsaQuarterRound(&s, 0, 1, 2, 3)
+
+		for j, word := range tc.expected {
+			if s[j] != word {
+				t.Fatalf("case %d, word %d: expected %08x, found %08x", i, j, word, s[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package blake2b implements the BLAKE2b hash function,
+// including the keyed (MAC) mode and digest sizes
+// other than 64 bytes.
+//
+// BLAKE2b is the 64-bit sibling of BLAKE2s. Its mixing function
+// works on 64-bit words with different rotations, so unlike
+// BLAKE2s it doesn't share the quarter round of the arx package.
+//
+// It was coded referencing RFC 7693:
+//
+// https://datatracker.ietf.org/doc/html/rfc7693
+package blake2b
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+const (
+	// Size of the BLAKE2b-512 digest in bytes.
+	SIZE = 64
+
+	// Block size of BLAKE2b in bytes.
+	BLOCK_SIZE = 128
+
+	// Maximum size of the key in bytes.
+	MAX_KEY_SIZE = 64
+
+	// Number of BLAKE2b rounds.
+	NR = 12
+)
+
+var (
+	// Error returned if the key is longer than MAX_KEY_SIZE.
+	ErrKeySize = errors.New("blake2b: invalid key size")
+
+	// Error returned if the digest size is not between 1 and SIZE.
+	ErrDigestSize = errors.New("blake2b: invalid digest size")
+)
+
+// Initialization vector, the same as the SHA-512 one.
+var iv = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+// Message word permutations of the rounds. Rounds 10
+// and 11 reuse the permutations of rounds 0 and 1.
+var sigma = [NR][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+// Digest is the BLAKE2b state. It implements hash.Hash.
+type digest struct {
+	h      [8]uint64
+	t      uint64
+	buf    [BLOCK_SIZE]byte
+	n      int
+	size   int
+	key    [MAX_KEY_SIZE]byte
+	keyLen int
+}
+
+// New returns a BLAKE2b hash with the given digest size.
+// A non-empty key turns it into a MAC.
+func New(size int, key []byte) (hash.Hash, error) {
+	if size < 1 || size > SIZE {
+		return nil, ErrDigestSize
+	}
+
+	if len(key) > MAX_KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	d := digest{size: size, keyLen: len(key)}
+	copy(d.key[:], key)
+	d.Reset()
+
+	return &d, nil
+}
+
+// New512 returns a BLAKE2b-512 hash, keyed if the key is not empty.
+func New512(key []byte) (hash.Hash, error) {
+	return New(SIZE, key)
+}
+
+// Sum512 returns the unkeyed BLAKE2b-512 digest of the data.
+func Sum512(data []byte) [SIZE]byte {
+	var sum [SIZE]byte
+
+	h, _ := New512(nil)
+	h.Write(data)
+	h.Sum(sum[:0])
+
+	return sum
+}
+
+func (d *digest) Size() int      { return d.size }
+func (d *digest) BlockSize() int { return BLOCK_SIZE }
+
+// Reset initializes the state with the parameter block
+// and queues the padded key as the first block.
+func (d *digest) Reset() {
+	d.h = iv
+	d.h[0] ^= 0x01010000 ^ uint64(d.keyLen)<<8 ^ uint64(d.size)
+	d.t = 0
+	d.n = 0
+
+	if d.keyLen > 0 {
+		d.buf = [BLOCK_SIZE]byte{}
+		copy(d.buf[:], d.key[:d.keyLen])
+		d.n = BLOCK_SIZE
+	}
+}
+
+// Write absorbs the data. The last block is always kept
+// in the buffer, because it has to be compressed as final.
+func (d *digest) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 {
+		if d.n == BLOCK_SIZE {
+			d.t += BLOCK_SIZE
+			d.compress(false)
+			d.n = 0
+		}
+
+		n := copy(d.buf[d.n:], p)
+		d.n += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Sum appends the digest to b without changing the state.
+func (d *digest) Sum(b []byte) []byte {
+	final := *d
+
+	for i := final.n; i < BLOCK_SIZE; i++ {
+		final.buf[i] = 0x00
+	}
+
+	final.t += uint64(final.n)
+	final.compress(true)
+
+	var out [SIZE]byte
+	for i, word := range final.h {
+		binary.LittleEndian.PutUint64(out[i*8:], word)
+	}
+
+	return append(b, out[:d.size]...)
+}
+
+// Compress mixes the buffered block into the state. The byte
+// counter is 128 bits wide, its high half is always 0 here.
+//
+// https://datatracker.ietf.org/doc/html/rfc7693#section-3.2
+func (d *digest) compress(last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(d.buf[i*8:])
+	}
+
+	var v [16]uint64
+	copy(v[:8], d.h[:])
+	copy(v[8:], iv[:])
+
+	v[12] ^= d.t
+
+	if last {
+		v[14] = ^v[14]
+	}
+
+	for i := 0; i < NR; i++ {
+		s := &sigma[i]
+
+		// Column round
+		mix(&v, 0, 4, 8, 12, m[s[0]], m[s[1]])
+		mix(&v, 1, 5, 9, 13, m[s[2]], m[s[3]])
+		mix(&v, 2, 6, 10, 14, m[s[4]], m[s[5]])
+		mix(&v, 3, 7, 11, 15, m[s[6]], m[s[7]])
+
+		// Diagonal round
+		mix(&v, 0, 5, 10, 15, m[s[8]], m[s[9]])
+		mix(&v, 1, 6, 11, 12, m[s[10]], m[s[11]])
+		mix(&v, 2, 7, 8, 13, m[s[12]], m[s[13]])
+		mix(&v, 3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range d.h {
+		d.h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// Mix is the BLAKE2b G function with the
+// rotation constants 32, 24, 16 and 63.
+//
+// https://datatracker.ietf.org/doc/html/rfc7693#section-3.1
+func mix(v *[16]uint64, a, b, c, d int, x, y uint64) {
+	v[a] += v[b] + x
+	v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+	v[a] += v[b] + y
+	v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+}
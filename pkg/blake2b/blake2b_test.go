@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blake2b
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+func TestSum512(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc7693#appendix-A
+	testVectors := []struct {
+		msg         string
+		expectedSum string
+	}{
+		{"abc", "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{"", "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+	}
+
+	for _, tv := range testVectors {
+		sum := Sum512([]byte(tv.msg))
+		if actual := hex.EncodeToString(sum[:]); actual != tv.expectedSum {
+			t.Fatalf("%q: expected %s, found %s", tv.msg, tv.expectedSum, actual)
+		}
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	// blake2b-kat.txt from the BLAKE2 reference implementation:
+	// key = 00..3f, message = 00..(n-1).
+	testVectors := []struct {
+		size        int
+		expectedSum string
+	}{
+		{0, "10ebb67700b1868efb4417987acf4690ae9d972fb7a590c2f02871799aaa4786b5e996e8f0f4eb981fc214b005f42d2ff4233499391653df7aefcbc13fc51568"},
+		{1, "961f6dd1e4dd30f63901690c512e78e4b45e4742ed197c3c5e45c549fd25f2e4187b0bc9fe30492b16b0d0bc4ef9b0f34c7003fac09a5ef1532e69430234cebd"},
+		{127, "76d2d819c92bce55fa8e092ab1bf9b9eab237a25267986cacf2b8ee14d214d730dc9a5aa2d7b596e86a1fd8fa0804c77402d2fcd45083688b218b1cdfa0dcbcb"},
+		{128, "72065ee4dd91c2d8509fa1fc28a37c7fc9fa7d5b3f8ad3d0d7a25626b57b1b44788d4caf806290425f9890a3a2a35a905ab4b37acfd0da6e4517b2525c9651e4"},
+		{129, "64475dfe7600d7171bea0b394e27c9b00d8e74dd1e416a79473682ad3dfdbb706631558055cfc8a40e07bd015a4540dcdea15883cbbf31412df1de1cd4152b91"},
+		{255, "142709d62e28fcccd0af97fad0f8465b971e82201dc51070faa0372aa43e92484be1c1e73ba10906d5d1853db6a4106e0a7bf9800d373d6dee2d46d62ef2a461"},
+	}
+
+	for _, tv := range testVectors {
+		msg := testutil.Sequence(0, tv.size)
+
+		// Writing byte by byte must give the same result as a single write.
+		for _, split := range []int{1, len(msg) + 1} {
+			h, err := New512(testutil.Sequence(0, MAX_KEY_SIZE))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < len(msg); i += split {
+				end := i + split
+				if end > len(msg) {
+					end = len(msg)
+				}
+				h.Write(msg[i:end])
+			}
+
+			if actual := hex.EncodeToString(h.Sum(nil)); actual != tv.expectedSum {
+				t.Fatalf("%d bytes, split %d: expected %s, found %s", tv.size, split, tv.expectedSum, actual)
+			}
+		}
+	}
+}
+
+func TestDigestSize(t *testing.T) {
+	// BLAKE2b-192, as used for the sealed box nonces.
+	h, err := New(24, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Write([]byte("abc"))
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != "56a17e38cc371a46b12c32f18e0c61de2a84e9c2555b114e" {
+		t.Fatalf("unexpected BLAKE2b-192 digest %s", actual)
+	}
+
+	if _, err := New(SIZE+1, nil); err != ErrDigestSize {
+		t.Fatalf("expected ErrDigestSize, found %v", err)
+	}
+
+	if _, err := New512(make([]byte, MAX_KEY_SIZE+1)); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package blake2s implements the BLAKE2s hash function,
+// including the keyed (MAC) mode.
+//
+// The compression function uses the same ARX quarter round
+// as the ChaCha cipher (see the arx package).
+//
+// It was coded referencing RFC 7693:
+//
+// https://datatracker.ietf.org/doc/html/rfc7693
+package blake2s
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"github.com/wedkarz02/chacha20/pkg/arx"
+)
+
+const (
+	// Size of the BLAKE2s-256 digest in bytes.
+	SIZE = 32
+
+	// Block size of BLAKE2s in bytes.
+	BLOCK_SIZE = 64
+
+	// Maximum size of the key in bytes.
+	MAX_KEY_SIZE = 32
+
+	// Number of BLAKE2s rounds.
+	NR = 10
+)
+
+var (
+	// Error returned if the key is longer than MAX_KEY_SIZE.
+	ErrKeySize = errors.New("blake2s: invalid key size")
+
+	// Error returned if the digest size is not between 1 and SIZE.
+	ErrDigestSize = errors.New("blake2s: invalid digest size")
+)
+
+// Initialization vector, the same as the SHA-256 one.
+var iv = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// Message word permutations of the rounds.
+var sigma = [NR][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// Digest is the BLAKE2s state. It implements hash.Hash.
+type digest struct {
+	h      [8]uint32
+	t      uint64
+	buf    [BLOCK_SIZE]byte
+	n      int
+	size   int
+	key    [MAX_KEY_SIZE]byte
+	keyLen int
+}
+
+// New returns a BLAKE2s hash with the given digest size.
+// A non-empty key turns it into a MAC.
+func New(size int, key []byte) (hash.Hash, error) {
+	if size < 1 || size > SIZE {
+		return nil, ErrDigestSize
+	}
+
+	if len(key) > MAX_KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	d := digest{size: size, keyLen: len(key)}
+	copy(d.key[:], key)
+	d.Reset()
+
+	return &d, nil
+}
+
+// New256 returns a BLAKE2s-256 hash, keyed if the key is not empty.
+func New256(key []byte) (hash.Hash, error) {
+	return New(SIZE, key)
+}
+
+// Sum256 returns the unkeyed BLAKE2s-256 digest of the data.
+// Useful for content addressing.
+func Sum256(data []byte) [SIZE]byte {
+	var sum [SIZE]byte
+
+	h, _ := New256(nil)
+	h.Write(data)
+	h.Sum(sum[:0])
+
+	return sum
+}
+
+// DeriveKey derives a 32-byte key from the secret and the context
+// with keyed BLAKE2s-256. Secrets longer than MAX_KEY_SIZE are
+// hashed first, like HMAC does with long keys.
+func DeriveKey(secret, context []byte) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, ErrKeySize
+	}
+
+	if len(secret) > MAX_KEY_SIZE {
+		sum := Sum256(secret)
+		secret = sum[:]
+	}
+
+	h, err := New256(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Write(context)
+
+	return h.Sum(nil), nil
+}
+
+func (d *digest) Size() int      { return d.size }
+func (d *digest) BlockSize() int { return BLOCK_SIZE }
+
+// Reset initializes the state with the parameter block
+// and queues the padded key as the first block.
+func (d *digest) Reset() {
+	d.h = iv
+	d.h[0] ^= 0x01010000 ^ uint32(d.keyLen)<<8 ^ uint32(d.size)
+	d.t = 0
+	d.n = 0
+
+	if d.keyLen > 0 {
+		d.buf = [BLOCK_SIZE]byte{}
+		copy(d.buf[:], d.key[:d.keyLen])
+		d.n = BLOCK_SIZE
+	}
+}
+
+// Write absorbs the data. The last block is always kept
+// in the buffer, because it has to be compressed as final.
+func (d *digest) Write(p []byte) (int, error) {
+	written := len(p)
+
+	for len(p) > 0 {
+		if d.n == BLOCK_SIZE {
+			d.t += BLOCK_SIZE
+			d.compress(false)
+			d.n = 0
+		}
+
+		n := copy(d.buf[d.n:], p)
+		d.n += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Sum appends the digest to b without changing the state.
+func (d *digest) Sum(b []byte) []byte {
+	final := *d
+
+	for i := final.n; i < BLOCK_SIZE; i++ {
+		final.buf[i] = 0x00
+	}
+
+	final.t += uint64(final.n)
+	final.compress(true)
+
+	var out [SIZE]byte
+	for i, word := range final.h {
+		binary.LittleEndian.PutUint32(out[i*4:], word)
+	}
+
+	return append(b, out[:d.size]...)
+}
+
+// Compress mixes the buffered block into the state.
+//
+// https://datatracker.ietf.org/doc/html/rfc7693#section-3.2
+func (d *digest) compress(last bool) {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(d.buf[i*4:])
+	}
+
+	var v [arx.STATE_SIZE]uint32
+	copy(v[:8], d.h[:])
+	copy(v[8:], iv[:])
+
+	v[12] ^= uint32(d.t)
+	v[13] ^= uint32(d.t >> 32)
+
+	if last {
+		v[14] = ^v[14]
+	}
+
+	for i := 0; i < NR; i++ {
+		s := &sigma[i]
+
+		// Column round
+		arx.QuarterRound(&v, 0, 4, 8, 12, m[s[0]], m[s[1]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 1, 5, 9, 13, m[s[2]], m[s[3]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 2, 6, 10, 14, m[s[4]], m[s[5]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 3, 7, 11, 15, m[s[6]], m[s[7]], &arx.BLAKE2S)
+
+		// Diagonal round
+		arx.QuarterRound(&v, 0, 5, 10, 15, m[s[8]], m[s[9]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 1, 6, 11, 12, m[s[10]], m[s[11]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 2, 7, 8, 13, m[s[12]], m[s[13]], &arx.BLAKE2S)
+		arx.QuarterRound(&v, 3, 4, 9, 14, m[s[14]], m[s[15]], &arx.BLAKE2S)
+	}
+
+	for i := range d.h {
+		d.h[i] ^= v[i] ^ v[i+8]
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blake2s
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+func TestSum256(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc7693#appendix-B
+	testVectors := []struct {
+		msg         string
+		expectedSum string
+	}{
+		{"abc", "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		{"", "69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+	}
+
+	for _, tv := range testVectors {
+		sum := Sum256([]byte(tv.msg))
+		if actual := hex.EncodeToString(sum[:]); actual != tv.expectedSum {
+			t.Fatalf("%q: expected %s, found %s", tv.msg, tv.expectedSum, actual)
+		}
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	// blake2s-kat.txt from the BLAKE2 reference implementation:
+	// key = 00..1f, message = 00..(n-1).
+	testVectors := []struct {
+		size        int
+		expectedSum string
+	}{
+		{0, "48a8997da407876b3d79c0d92325ad3b89cbb754d86ab71aee047ad345fd2c49"},
+		{1, "40d15fee7c328830166ac3f918650f807e7e01e177258cdc0a39b11f598066f1"},
+		{63, "c65382513f07460da39833cb666c5ed82e61b9e998f4b0c4287cee56c3cc9bcd"},
+		{64, "8975b0577fd35566d750b362b0897a26c399136df07bababbde6203ff2954ed4"},
+		{65, "21fe0ceb0052be7fb0f004187cacd7de67fa6eb0938d927677f2398c132317a8"},
+		{255, "3fb735061abc519dfe979e54c1ee5bfad0a9d858b3315bad34bde999efd724dd"},
+	}
+
+	for _, tv := range testVectors {
+		msg := testutil.Sequence(0, tv.size)
+
+		// Writing byte by byte must give the same result as a single write.
+		for _, split := range []int{1, len(msg) + 1} {
+			h, err := New256(testutil.Sequence(0, MAX_KEY_SIZE))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < len(msg); i += split {
+				end := i + split
+				if end > len(msg) {
+					end = len(msg)
+				}
+				h.Write(msg[i:end])
+			}
+
+			if actual := hex.EncodeToString(h.Sum(nil)); actual != tv.expectedSum {
+				t.Fatalf("%d bytes, split %d: expected %s, found %s", tv.size, split, tv.expectedSum, actual)
+			}
+		}
+	}
+}
+
+func TestDigestSize(t *testing.T) {
+	h, err := New(16, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Write([]byte("abc"))
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != "aa4938119b1dc7b87cbad0ffd200d0ae" {
+		t.Fatalf("unexpected BLAKE2s-128 digest %s", actual)
+	}
+
+	if _, err := New(SIZE+1, nil); err != ErrDigestSize {
+		t.Fatalf("expected ErrDigestSize, found %v", err)
+	}
+
+	if _, err := New256(make([]byte, MAX_KEY_SIZE+1)); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	key, err := DeriveKey([]byte("secret"), []byte("context"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := hex.EncodeToString(key); actual != "6490810144aa10326d175db723d3b1c2cd0ae50465fdb1fdfe73b068e563b2f9" {
+		t.Fatalf("unexpected key %s", actual)
+	}
+
+	if _, err := DeriveKey(nil, []byte("context")); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize for an empty secret, found %v", err)
+	}
+}
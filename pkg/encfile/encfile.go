@@ -0,0 +1,465 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+// Package encfile implements an encrypted file container
+// for at-rest encryption of user uploads.
+//
+// A file starts with a header followed by the body split into
+// chunks, each sealed with ChaCha20-Poly1305:
+//
+//	magic       4 bytes   "CC2F"
+//	version     1 byte
+//	algorithm   1 byte    chacha20.ALG_CHACHA20_POLY1305
+//	kdf         1 byte    KDF_HKDF_SHA256 or KDF_PBKDF2_SHA256
+//	iterations  4 bytes   PBKDF2 iterations, 0 for HKDF
+//	saltLen     1 byte
+//	salt        saltLen bytes
+//	chunkSize   4 bytes
+//	nonce       7 bytes   nonce prefix
+//	size        8 bytes   plaintext size
+//	metaLen     4 bytes   sealed metadata size, 0 if absent
+//	metadata    metaLen bytes
+//	chunks      ceil(size / chunkSize) chunks, at least one
+//
+// Integers are little endian. The file key is derived from the key
+// and the salt with the KDF. Chunk i uses the nonce
+//
+//	nonce prefix | i (4 bytes, big endian) | last chunk flag
+//
+// and the header up to metaLen as associated data, so chunks can't be
+// reordered, dropped, truncated or moved to another file. The chunk
+// counter is derived from the plaintext offset, which makes the file
+// seekable without decrypting what precedes the offset.
+package encfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/pbkdf2"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+const (
+	// Magic bytes at the start of every encrypted file.
+	MAGIC = "CC2F"
+
+	// Current version of the file format.
+	VERSION = 1
+
+	// HKDF-SHA256 key derivation, for uniformly random keys.
+	KDF_HKDF_SHA256 = 1
+
+	// PBKDF2-HMAC-SHA256 key derivation, for passphrases.
+	KDF_PBKDF2_SHA256 = 2
+
+	// HKDF info string binding derived keys to this format.
+	HKDF_INFO = "chacha20 encfile v1"
+
+	// Upper limit of PBKDF2 iterations accepted in a header.
+	// The key is derived every time a file is opened (for example
+	// on each encfs Stat), so a crafted header must not be able
+	// to make that expensive.
+	MAX_ITERATIONS = 1000000
+
+	// Size of the randomly generated KDF salt in bytes.
+	SALT_SIZE = 16
+
+	// Size of the random chunk nonce prefix in bytes.
+	NONCE_PREFIX_SIZE = 7
+
+	// Default number of plaintext bytes per chunk.
+	DEFAULT_CHUNK_SIZE = 64 << 10
+
+	// Largest chunk size accepted when writing or reading a file.
+	MAX_CHUNK_SIZE = 16 << 20
+
+	// Largest number of chunks in a file, limited by
+	// the 32-bit chunk counter in the nonce.
+	MAX_CHUNKS = 1 << 32
+
+	// Largest metadata size in bytes, without the tag.
+	MAX_METADATA_SIZE = 1 << 20
+
+	// Size of the header without the salt and the metadata.
+	headerFixedSize = 4 + 1 + 1 + 1 + 4 + 1 + 4 + NONCE_PREFIX_SIZE + 8 + 4
+
+	// Last byte of the chunk nonce.
+	flagChunk     = 0x00
+	flagLastChunk = 0x01
+	flagMetadata  = 0x02
+)
+
+var (
+	// Error returned if the header is malformed or the metadata too large.
+	ErrFormat = errors.New("invalid encrypted file format")
+
+	// Error returned if the file format version is not supported.
+	ErrVersion = errors.New("unsupported encrypted file version")
+
+	// Error returned for an unknown KDF or invalid KDF parameters.
+	ErrKDF = errors.New("unsupported or invalid key derivation parameters")
+
+	// Error returned if the chunk size is 0 or above MAX_CHUNK_SIZE.
+	ErrChunkSize = errors.New("invalid chunk size")
+
+	// Error returned if the plaintext or file size doesn't match the header.
+	ErrSize = errors.New("plaintext size doesn't match the header")
+
+	// Error returned when seeking to a negative offset.
+	ErrOffset = errors.New("invalid offset")
+)
+
+// Options configures Encrypt and WriteFile.
+// A nil *Options selects the defaults.
+type Options struct {
+	// KDF used to derive the file key, KDF_HKDF_SHA256 by default.
+	// With KDF_PBKDF2_SHA256 the key is treated as a passphrase.
+	KDF byte
+
+	// PBKDF2 iterations, chacha20.KDF_ITERATIONS by default
+	// and at most MAX_ITERATIONS.
+	Iterations int
+
+	// Plaintext bytes per chunk, DEFAULT_CHUNK_SIZE by default.
+	ChunkSize int
+
+	// Optional metadata, such as the original file name,
+	// stored encrypted in the header.
+	Metadata []byte
+}
+
+// Header describes an encrypted file.
+type Header struct {
+	Version    byte
+	Algorithm  chacha20.Algorithm
+	KDF        byte
+	Iterations uint32
+	Salt       []byte
+	ChunkSize  uint32
+	Nonce      []byte
+	Size       int64
+
+	// Metadata sealed with the file key, including its tag.
+	SealedMetadata []byte
+}
+
+// Marshal serializes the header. The second
+// return value is the associated data of the chunks.
+func (h *Header) marshal() ([]byte, []byte, error) {
+	if len(h.Salt) > 0xff || len(h.Nonce) != NONCE_PREFIX_SIZE {
+		return nil, nil, ErrFormat
+	}
+
+	if len(h.SealedMetadata) > MAX_METADATA_SIZE+chacha20.TAG_SIZE {
+		return nil, nil, ErrFormat
+	}
+
+	b := make([]byte, 0, headerFixedSize+len(h.Salt)+len(h.SealedMetadata))
+	b = append(b, MAGIC...)
+	b = append(b, h.Version, byte(h.Algorithm), h.KDF)
+	b = binary.LittleEndian.AppendUint32(b, h.Iterations)
+	b = append(b, byte(len(h.Salt)))
+	b = append(b, h.Salt...)
+	b = binary.LittleEndian.AppendUint32(b, h.ChunkSize)
+	b = append(b, h.Nonce...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(h.Size))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(h.SealedMetadata)))
+
+	aad := b[:len(b):len(b)]
+
+	return append(b, h.SealedMetadata...), aad, nil
+}
+
+// ReadHeader reads and validates the header at the start of r.
+// The returned int is the size of the header in bytes.
+func ReadHeader(r io.Reader) (*Header, int, error) {
+	h, _, n, err := readHeader(r)
+	return h, n, err
+}
+
+func readHeader(r io.Reader) (*Header, []byte, int, error) {
+	fixed := make([]byte, 12)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, 0, ErrFormat
+	}
+
+	if string(fixed[:4]) != MAGIC {
+		return nil, nil, 0, ErrFormat
+	}
+
+	h := &Header{
+		Version:    fixed[4],
+		Algorithm:  chacha20.Algorithm(fixed[5]),
+		KDF:        fixed[6],
+		Iterations: binary.LittleEndian.Uint32(fixed[7:11]),
+	}
+
+	if h.Version != VERSION {
+		return nil, nil, 0, ErrVersion
+	}
+
+	if h.Algorithm != chacha20.ALG_CHACHA20_POLY1305 {
+		return nil, nil, 0, chacha20.ErrAlgorithm
+	}
+
+	rest := make([]byte, int(fixed[11])+headerFixedSize-len(fixed))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, 0, ErrFormat
+	}
+
+	h.Salt, rest = rest[:fixed[11]], rest[fixed[11]:]
+	h.ChunkSize = binary.LittleEndian.Uint32(rest[0:4])
+	h.Nonce = rest[4 : 4+NONCE_PREFIX_SIZE]
+	rest = rest[4+NONCE_PREFIX_SIZE:]
+	h.Size = int64(binary.LittleEndian.Uint64(rest[0:8]))
+	metaLen := binary.LittleEndian.Uint32(rest[8:12])
+
+	if h.ChunkSize == 0 || h.ChunkSize > MAX_CHUNK_SIZE {
+		return nil, nil, 0, ErrChunkSize
+	}
+
+	if !h.validSize() {
+		return nil, nil, 0, ErrSize
+	}
+
+	if metaLen > MAX_METADATA_SIZE+chacha20.TAG_SIZE || (metaLen > 0 && metaLen < chacha20.TAG_SIZE) {
+		return nil, nil, 0, ErrFormat
+	}
+
+	if metaLen > 0 {
+		h.SealedMetadata = make([]byte, metaLen)
+		if _, err := io.ReadFull(r, h.SealedMetadata); err != nil {
+			return nil, nil, 0, ErrFormat
+		}
+	}
+
+	data, aad, err := h.marshal()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return h, aad, len(data), nil
+}
+
+// ValidSize reports whether the size fits in MAX_CHUNKS chunks.
+// The size is compared directly, since rounding it up to whole
+// chunks overflows int64 near math.MaxInt64.
+func (h *Header) validSize() bool {
+	return h.Size >= 0 && h.Size <= MAX_CHUNKS*int64(h.ChunkSize)
+}
+
+// Chunks returns the number of chunks in the body.
+// An empty file still has one (empty) last chunk.
+func (h *Header) chunks() int64 {
+	if h.Size == 0 {
+		return 1
+	}
+
+	return (h.Size + int64(h.ChunkSize) - 1) / int64(h.ChunkSize)
+}
+
+// ChunkNonce builds the nonce of chunk i.
+func (h *Header) chunkNonce(i int64, flag byte) []byte {
+	nonce := make([]byte, 0, chacha20.NONCE_SIZE)
+	nonce = append(nonce, h.Nonce...)
+	nonce = binary.BigEndian.AppendUint32(nonce, uint32(i))
+	return append(nonce, flag)
+}
+
+// ChunkFlag returns the nonce flag of chunk i.
+func (h *Header) chunkFlag(i int64) byte {
+	if i == h.chunks()-1 {
+		return flagLastChunk
+	}
+
+	return flagChunk
+}
+
+// FileCipher derives the file key from the key and the header.
+func (h *Header) fileCipher(key []byte) (*chacha20.Cipher, error) {
+	switch h.KDF {
+	case KDF_HKDF_SHA256:
+		if h.Iterations != 0 {
+			return nil, ErrKDF
+		}
+
+		return chacha20.NewCipherFromHKDF(key, h.Salt, []byte(HKDF_INFO))
+
+	case KDF_PBKDF2_SHA256:
+		if h.Iterations == 0 || h.Iterations > MAX_ITERATIONS {
+			return nil, ErrKDF
+		}
+
+		fileKey, err := pbkdf2.Key(key, h.Salt, int(h.Iterations), chacha20.KEY_SIZE)
+		if err != nil {
+			return nil, ErrKDF
+		}
+
+		k := &chacha20.Key{Bytes: fileKey}
+		defer k.Clear()
+
+		return k.NewCipher()
+	}
+
+	return nil, ErrKDF
+}
+
+// Encrypt reads exactly size bytes of plaintext from src
+// and writes the encrypted file to dst.
+//
+// ErrSize error is returned if src holds more or less data.
+func Encrypt(dst io.Writer, src io.Reader, size int64, key []byte, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	h := &Header{
+		Version:   VERSION,
+		Algorithm: chacha20.ALG_CHACHA20_POLY1305,
+		KDF:       opts.KDF,
+		ChunkSize: uint32(opts.ChunkSize),
+		Size:      size,
+	}
+
+	if h.KDF == 0 {
+		h.KDF = KDF_HKDF_SHA256
+	}
+
+	if h.KDF == KDF_PBKDF2_SHA256 {
+		h.Iterations = chacha20.KDF_ITERATIONS
+		if opts.Iterations != 0 {
+			h.Iterations = uint32(opts.Iterations)
+		}
+
+		if opts.Iterations < 0 || h.Iterations > MAX_ITERATIONS {
+			return ErrKDF
+		}
+	}
+
+	if opts.ChunkSize == 0 {
+		h.ChunkSize = DEFAULT_CHUNK_SIZE
+	}
+
+	if opts.ChunkSize < 0 || opts.ChunkSize > MAX_CHUNK_SIZE {
+		return ErrChunkSize
+	}
+
+	if !h.validSize() {
+		return ErrSize
+	}
+
+	if len(opts.Metadata) > MAX_METADATA_SIZE {
+		return ErrFormat
+	}
+
+	var err error
+
+	if h.Salt, err = util.RandomBytes(SALT_SIZE); err != nil {
+		return err
+	}
+
+	if h.Nonce, err = util.RandomBytes(NONCE_PREFIX_SIZE); err != nil {
+		return err
+	}
+
+	c, err := h.fileCipher(key)
+	if err != nil {
+		return err
+	}
+	defer c.ClearKey()
+
+	// The metadata length is part of the associated data,
+	// so the header is marshaled with a placeholder first.
+	if len(opts.Metadata) > 0 {
+		h.SealedMetadata = make([]byte, len(opts.Metadata)+chacha20.TAG_SIZE)
+	}
+
+	header, aad, err := h.marshal()
+	if err != nil {
+		return err
+	}
+
+	if len(opts.Metadata) > 0 {
+		sealed, err := c.Seal(h.chunkNonce(0, flagMetadata), opts.Metadata, aad)
+		if err != nil {
+			return err
+		}
+
+		copy(header[len(aad):], sealed)
+	}
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, h.ChunkSize)
+	remaining := size
+
+	for i := int64(0); i < h.chunks(); i++ {
+		n := int64(h.ChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+
+		if _, err := io.ReadFull(src, chunk[:n]); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return ErrSize
+			}
+			return err
+		}
+		remaining -= n
+
+		sealed, err := c.Seal(h.chunkNonce(i, h.chunkFlag(i)), chunk[:n], aad)
+		if err != nil {
+			return err
+		}
+
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+	}
+
+	// Src must be exhausted.
+	if n, _ := src.Read(make([]byte, 1)); n > 0 {
+		return ErrSize
+	}
+
+	return nil
+}
+
+// WriteFile encrypts the data and writes it to the named file,
+// creating it with 0600 permissions if necessary.
+func WriteFile(path string, data []byte, key []byte, opts *Options) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := Encrypt(f, bytes.NewReader(data), int64(len(data)), key, opts); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
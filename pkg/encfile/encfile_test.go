@@ -0,0 +1,348 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package encfile
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/wedkarz02/chacha20"
+)
+
+var testKey = []byte("encfile test key")
+
+func encrypt(t *testing.T, data []byte, opts *Options) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, bytes.NewReader(data), int64(len(data)), testKey, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	const chunkSize = 64
+
+	rng := rand.New(rand.NewSource(1))
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 10*chunkSize + 7} {
+		data := make([]byte, size)
+		rng.Read(data)
+
+		encrypted := encrypt(t, data, &Options{ChunkSize: chunkSize})
+
+		r, err := NewReader(bytes.NewReader(encrypted), int64(len(encrypted)), testKey)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+
+		if r.Size() != int64(size) {
+			t.Fatalf("%d bytes: expected size %d, found %d", size, size, r.Size())
+		}
+
+		// Exercises Read, ReadAt and Seek at arbitrary offsets.
+		if err := iotest.TestReader(r, data); err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.enc")
+	data := bytes.Repeat([]byte("user upload "), 10000)
+
+	opts := &Options{Metadata: []byte("report.pdf")}
+	if err := WriteFile(path, data, testKey, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var _ io.ReadSeekCloser = f
+
+	if string(f.Metadata()) != "report.pdf" {
+		t.Fatalf("expected metadata %q, found %q", "report.pdf", f.Metadata())
+	}
+
+	if _, err := f.Seek(-12, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(tail) != "user upload " {
+		t.Fatalf("expected %q, found %q", "user upload ", tail)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(all, data) {
+		t.Fatalf("plaintext mismatch")
+	}
+
+	// The body itself is not readable in the file.
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("user upload")) || bytes.Contains(raw, []byte("report.pdf")) {
+		t.Fatalf("plaintext found in the encrypted file")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	data := []byte("passphrase protected")
+	encrypted := encrypt(t, data, &Options{KDF: KDF_PBKDF2_SHA256, Iterations: 1000})
+
+	h, _, err := ReadHeader(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.KDF != KDF_PBKDF2_SHA256 || h.Iterations != 1000 || len(h.Salt) != SALT_SIZE {
+		t.Fatalf("unexpected KDF parameters: %d, %d, %x", h.KDF, h.Iterations, h.Salt)
+	}
+
+	r, err := NewReader(bytes.NewReader(encrypted), int64(len(encrypted)), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, err := io.ReadAll(r); err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("expected %q, found %q (%v)", data, decrypted, err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	const chunkSize = 16
+
+	data := bytes.Repeat([]byte{0x42}, 4*chunkSize)
+	encrypted := encrypt(t, data, &Options{ChunkSize: chunkSize})
+
+	_, headerSize, err := ReadHeader(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealedChunk := chunkSize + chacha20.TAG_SIZE
+
+	readAll := func(encrypted []byte, key []byte) error {
+		r, err := NewReader(bytes.NewReader(encrypted), int64(len(encrypted)), key)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	// Modified header fields and body bytes.
+	for _, i := range []int{7, 12, headerSize - 5, headerSize, len(encrypted) - 1} {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[i] ^= 0x01
+
+		if err := readAll(tampered, testKey); err == nil {
+			t.Fatalf("byte %d: modification not detected", i)
+		}
+	}
+
+	// Swapped chunks.
+	swapped := append([]byte(nil), encrypted...)
+	copy(swapped[headerSize:], encrypted[headerSize+sealedChunk:headerSize+2*sealedChunk])
+	copy(swapped[headerSize+sealedChunk:], encrypted[headerSize:headerSize+sealedChunk])
+
+	if err := readAll(swapped, testKey); err != chacha20.ErrAuthFailed {
+		t.Fatalf("swapped chunks: expected ErrAuthFailed, found %v", err)
+	}
+
+	// Truncated and extended files.
+	if err := readAll(encrypted[:len(encrypted)-sealedChunk], testKey); err != ErrSize {
+		t.Fatalf("truncated file: expected ErrSize, found %v", err)
+	}
+
+	if err := readAll(append(encrypted, 0x00), testKey); err != ErrSize {
+		t.Fatalf("extended file: expected ErrSize, found %v", err)
+	}
+
+	// Dropping the last chunk and fixing up the size still fails,
+	// since the new last chunk isn't flagged as such.
+	h, _, _ := ReadHeader(bytes.NewReader(encrypted))
+	h.Size -= chunkSize
+	header, _, _ := h.marshal()
+
+	dropped := append(header, encrypted[headerSize:len(encrypted)-sealedChunk]...)
+	if err := readAll(dropped, testKey); err != chacha20.ErrAuthFailed {
+		t.Fatalf("dropped chunk: expected ErrAuthFailed, found %v", err)
+	}
+
+	if err := readAll(encrypted, []byte("wrong key")); err != chacha20.ErrAuthFailed {
+		t.Fatalf("wrong key: expected ErrAuthFailed, found %v", err)
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Encrypt(&buf, bytes.NewReader(make([]byte, 10)), 11, testKey, nil); err != ErrSize {
+		t.Fatalf("short source: expected ErrSize, found %v", err)
+	}
+
+	if err := Encrypt(&buf, bytes.NewReader(make([]byte, 10)), 9, testKey, nil); err != ErrSize {
+		t.Fatalf("long source: expected ErrSize, found %v", err)
+	}
+
+	if err := Encrypt(&buf, bytes.NewReader(nil), 0, testKey, &Options{ChunkSize: MAX_CHUNK_SIZE + 1}); err != ErrChunkSize {
+		t.Fatalf("expected ErrChunkSize, found %v", err)
+	}
+
+	if err := Encrypt(&buf, bytes.NewReader(nil), 0, testKey, &Options{ChunkSize: 1<<32 + 16}); err != ErrChunkSize {
+		t.Fatalf("truncated chunk size: expected ErrChunkSize, found %v", err)
+	}
+
+	// Rounding these sizes up to whole chunks overflows int64.
+	for _, size := range []int64{math.MaxInt64, math.MaxInt64 - DEFAULT_CHUNK_SIZE + 2} {
+		if err := Encrypt(&buf, bytes.NewReader(nil), size, testKey, nil); err != ErrSize {
+			t.Fatalf("size %d: expected ErrSize, found %v", size, err)
+		}
+	}
+
+	if err := Encrypt(&buf, bytes.NewReader(nil), 0, testKey, &Options{KDF: 9}); err != ErrKDF {
+		t.Fatalf("expected ErrKDF, found %v", err)
+	}
+
+	opts := &Options{KDF: KDF_PBKDF2_SHA256, Iterations: MAX_ITERATIONS + 1}
+	if err := Encrypt(&buf, bytes.NewReader(nil), 0, testKey, opts); err != ErrKDF {
+		t.Fatalf("too many iterations: expected ErrKDF, found %v", err)
+	}
+
+	// Headers requesting more iterations are rejected
+	// before any key derivation happens.
+	encrypted := encrypt(t, []byte("data"), &Options{KDF: KDF_PBKDF2_SHA256, Iterations: 1000})
+	h, n, err := ReadHeader(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Iterations = MAX_ITERATIONS + 1
+	header, _, _ := h.marshal()
+	encrypted = append(header, encrypted[n:]...)
+
+	if _, err := NewReader(bytes.NewReader(encrypted), int64(len(encrypted)), testKey); err != ErrKDF {
+		t.Fatalf("header with too many iterations: expected ErrKDF, found %v", err)
+	}
+}
+
+// EOFReaderAt returns io.EOF with every read reaching the end,
+// which io.ReaderAt allows even if the buffer was filled.
+type eofReaderAt struct {
+	*bytes.Reader
+}
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	if err == nil && off+int64(n) == r.Reader.Size() {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func TestReaderAtEOF(t *testing.T) {
+	data := make([]byte, 200)
+	encrypted := encrypt(t, data, &Options{ChunkSize: 64})
+
+	r, err := NewReader(eofReaderAt{bytes.NewReader(encrypted)}, int64(len(encrypted)), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := iotest.TestReader(r, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	encrypted := encrypt(t, []byte("data"), nil)
+
+	testVectors := []struct {
+		index    int
+		value    byte
+		expected error
+	}{
+		{index: 0, value: 'X', expected: ErrFormat},
+		{index: 4, value: VERSION + 1, expected: ErrVersion},
+		{index: 5, value: byte(chacha20.ALG_XCHACHA20), expected: chacha20.ErrAlgorithm},
+	}
+
+	for _, tv := range testVectors {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[tv.index] = tv.value
+
+		if _, _, err := ReadHeader(bytes.NewReader(tampered)); err != tv.expected {
+			t.Fatalf("byte %d: expected %v, found %v", tv.index, tv.expected, err)
+		}
+	}
+
+	h, n, err := ReadHeader(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maxSize := MAX_CHUNKS * int64(h.ChunkSize)
+	for size, expected := range map[int64]error{
+		maxSize:           nil,
+		maxSize + 1:       ErrSize,
+		math.MaxInt64:     ErrSize,
+		math.MaxInt64 - 1: ErrSize,
+	} {
+		h.Size = size
+		header, _, _ := h.marshal()
+
+		if _, _, err := ReadHeader(bytes.NewReader(append(header, encrypted[n:]...))); err != expected {
+			t.Fatalf("size %d: expected %v, found %v", size, expected, err)
+		}
+	}
+
+	for n := 0; n < 40; n++ {
+		if _, _, err := ReadHeader(bytes.NewReader(encrypted[:n])); err != ErrFormat {
+			t.Fatalf("%d bytes: expected ErrFormat, found %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package encfile
+
+import (
+	"io"
+	"os"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Reader decrypts an encrypted file on demand. Only the chunk
+// containing the current offset is read and authenticated.
+//
+// Reader implements io.ReadSeeker and io.ReaderAt.
+// It is not safe for concurrent use.
+type Reader struct {
+	Header *Header
+
+	r          io.ReaderAt
+	cipher     *chacha20.Cipher
+	aad        []byte
+	dataOffset int64
+	metadata   []byte
+
+	offset int64
+
+	// Decrypted chunk with index chunkIndex, -1 if none.
+	chunk      []byte
+	chunkIndex int64
+}
+
+// NewReader reads the header of the encrypted file of the given
+// size and derives the file key. Like zip.NewReader, the total
+// size is needed to detect truncated and extended files.
+//
+// Chunks are authenticated when read, so chacha20.ErrAuthFailed
+// is returned by Read and ReadAt for modified data.
+func NewReader(r io.ReaderAt, size int64, key []byte) (*Reader, error) {
+	h, aad, n, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := h.chunks()
+	expected := int64(n) + h.Size + chunks*chacha20.TAG_SIZE
+
+	if size != expected {
+		return nil, ErrSize
+	}
+
+	c, err := h.fileCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	er := &Reader{
+		Header:     h,
+		r:          r,
+		cipher:     c,
+		aad:        aad,
+		dataOffset: int64(n),
+		chunkIndex: -1,
+	}
+
+	if len(h.SealedMetadata) > 0 {
+		er.metadata, err = c.Open(h.chunkNonce(0, flagMetadata), h.SealedMetadata, aad)
+		if err != nil {
+			c.ClearKey()
+			return nil, err
+		}
+	}
+
+	return er, nil
+}
+
+// Size returns the plaintext size.
+func (er *Reader) Size() int64 {
+	return er.Header.Size
+}
+
+// Metadata returns the decrypted metadata, nil if the file has none.
+func (er *Reader) Metadata() []byte {
+	return er.metadata
+}
+
+// LoadChunk reads, authenticates and caches chunk i.
+func (er *Reader) loadChunk(i int64) error {
+	if i == er.chunkIndex {
+		return nil
+	}
+
+	chunkSize := int64(er.Header.ChunkSize)
+	n := er.Header.Size - i*chunkSize
+	if n > chunkSize {
+		n = chunkSize
+	}
+
+	sealed := make([]byte, n+chacha20.TAG_SIZE)
+	pos := er.dataOffset + i*(chunkSize+chacha20.TAG_SIZE)
+
+	// ReaderAt may return io.EOF along with a full buffer
+	// when the last chunk ends at the end of the file.
+	if m, err := er.r.ReadAt(sealed, pos); m != len(sealed) {
+		if err == io.EOF || err == nil {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	chunk, err := er.cipher.Open(er.Header.chunkNonce(i, er.Header.chunkFlag(i)), sealed, er.aad)
+	if err != nil {
+		er.chunkIndex = -1
+		return err
+	}
+
+	er.chunk = chunk
+	er.chunkIndex = i
+
+	return nil
+}
+
+// ReadAt decrypts len(p) bytes starting at plaintext offset off.
+func (er *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrOffset
+	}
+
+	chunkSize := int64(er.Header.ChunkSize)
+	n := 0
+
+	for n < len(p) {
+		if off >= er.Header.Size {
+			return n, io.EOF
+		}
+
+		i := off / chunkSize
+		if err := er.loadChunk(i); err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], er.chunk[off-i*chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+// Read decrypts the plaintext at the current offset.
+func (er *Reader) Read(p []byte) (int, error) {
+	if er.offset >= er.Header.Size {
+		return 0, io.EOF
+	}
+
+	if remaining := er.Header.Size - er.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := er.ReadAt(p, er.offset)
+	er.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Seek sets the plaintext offset for the next Read.
+// Seeking doesn't read or decrypt anything.
+func (er *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += er.offset
+	case io.SeekEnd:
+		offset += er.Header.Size
+	default:
+		return 0, ErrOffset
+	}
+
+	if offset < 0 {
+		return 0, ErrOffset
+	}
+
+	er.offset = offset
+	return offset, nil
+}
+
+// Close wipes the file key and the cached plaintext.
+func (er *Reader) Close() error {
+	er.cipher.ClearKey()
+
+	util.Wipe(er.chunk)
+	er.chunkIndex = -1
+
+	return nil
+}
+
+// File is an encrypted file opened by Open.
+type File struct {
+	*Reader
+	f *os.File
+}
+
+// Open opens the named encrypted file for reading. The returned
+// file is an io.ReadSeekCloser over the plaintext.
+func Open(path string, key []byte) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r, err := NewReader(f, info.Size(), key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &File{Reader: r, f: f}, nil
+}
+
+// Close wipes the key and closes the underlying file.
+func (f *File) Close() error {
+	f.Reader.Close()
+	return f.f.Close()
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+// Package encfs exposes a directory of files encrypted with
+// the encfile package as a plaintext fs.FS.
+//
+// File names and the directory structure are passed through
+// unchanged. Stat and directory listings report plaintext sizes
+// taken from the file headers, and reads decrypt lazily: only the
+// chunks covering the requested range are read and authenticated.
+package encfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/wedkarz02/chacha20/pkg/encfile"
+)
+
+// FS decrypts the files of the wrapped file system.
+// It implements fs.FS, fs.ReadFileFS and fs.StatFS.
+type FS struct {
+	fsys fs.FS
+	key  []byte
+}
+
+// New returns a file system decrypting the files of fsys
+// with the key passed to encfile.Encrypt or encfile.WriteFile.
+func New(fsys fs.FS, key []byte) *FS {
+	return &FS{
+		fsys: fsys,
+		key:  append([]byte(nil), key...),
+	}
+}
+
+// Open opens the named file and prepares it for decryption.
+// Directories are returned as is, with plaintext sizes
+// reported by their entries.
+func (efs *FS) Open(name string) (fs.File, error) {
+	f, err := efs.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dir{File: f, fsys: efs, name: name}, nil
+	}
+
+	r, err := efs.newReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{
+		Reader: r,
+		f:      f,
+		info:   &fileInfo{FileInfo: info, size: r.Size()},
+	}, nil
+}
+
+// NewReader decrypts f lazily if it supports random access,
+// otherwise its content is read into memory first.
+func (efs *FS) newReader(f fs.File, size int64) (*encfile.Reader, error) {
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	return encfile.NewReader(ra, size, efs.key)
+}
+
+// ReadFile reads and decrypts the named file.
+func (efs *FS) ReadFile(name string) ([]byte, error) {
+	f, err := efs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, ok := f.(*dir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return io.ReadAll(f)
+}
+
+// Stat returns the file info of the named file with the
+// plaintext size. Only the file header is read, so the key
+// isn't checked until the file is opened.
+func (efs *FS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(efs.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return info, nil
+	}
+
+	f, err := efs.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, _, err := encfile.ReadHeader(f)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return &fileInfo{FileInfo: info, size: h.Size}, nil
+}
+
+// File is an open encrypted file.
+type file struct {
+	*encfile.Reader
+	f    fs.File
+	info fs.FileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	f.Reader.Close()
+	return f.f.Close()
+}
+
+// FileInfo reports the plaintext size of an encrypted file.
+type fileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+// Dir is an open directory whose entries
+// report plaintext sizes.
+type dir struct {
+	fs.File
+	fsys *FS
+	name string
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rd, ok := d.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrInvalid}
+	}
+
+	entries, err := rd.ReadDir(n)
+
+	for i, entry := range entries {
+		entries[i] = &dirEntry{DirEntry: entry, fsys: d.fsys, name: path.Join(d.name, entry.Name())}
+	}
+
+	return entries, err
+}
+
+// DirEntry reads the file header when Info is called.
+type dirEntry struct {
+	fs.DirEntry
+	fsys *FS
+	name string
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	if e.IsDir() {
+		return e.DirEntry.Info()
+	}
+
+	return e.fsys.Stat(e.name)
+}
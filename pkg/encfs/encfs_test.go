@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package encfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/encfile"
+)
+
+var testKey = []byte("encfs test key")
+
+// Plaintext contents of the test file system.
+var testFiles = map[string][]byte{
+	"index.html":         []byte("<h1>assets</h1>"),
+	"empty.txt":          {},
+	"css/site.css":       bytes.Repeat([]byte("body { margin: 0 }\n"), 50),
+	"img/logo.svg":       bytes.Repeat([]byte{0x3c, 0x73, 0x76, 0x67}, 5000),
+	"img/icons/star.svg": []byte("<svg/>"),
+}
+
+func encryptedMapFS(t *testing.T) fstest.MapFS {
+	t.Helper()
+
+	fsys := make(fstest.MapFS)
+
+	for name, data := range testFiles {
+		var buf bytes.Buffer
+
+		// A small chunk size spreads the files over many chunks.
+		opts := &encfile.Options{ChunkSize: 64}
+		if err := encfile.Encrypt(&buf, bytes.NewReader(data), int64(len(data)), testKey, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		fsys[name] = &fstest.MapFile{Data: buf.Bytes(), Mode: 0644, ModTime: time.Unix(1700000000, 0)}
+	}
+
+	return fsys
+}
+
+func TestFS(t *testing.T) {
+	fsys := New(encryptedMapFS(t), testKey)
+
+	expected := make([]string, 0, len(testFiles))
+	for name := range testFiles {
+		expected = append(expected, name)
+	}
+
+	if err := fstest.TestFS(fsys, expected...); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range testFiles {
+		actual, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(actual, data) {
+			t.Fatalf("%s: plaintext mismatch", name)
+		}
+
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() != int64(len(data)) {
+			t.Fatalf("%s: expected size %d, found %d", name, len(data), info.Size())
+		}
+	}
+}
+
+func TestDirSizes(t *testing.T) {
+	fsys := New(encryptedMapFS(t), testKey)
+
+	entries, err := fs.ReadDir(fsys, "img")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := int64(len(testFiles["img/"+entry.Name()])); info.Size() != expected {
+			t.Fatalf("%s: expected size %d, found %d", entry.Name(), expected, info.Size())
+		}
+	}
+}
+
+func TestLazySeek(t *testing.T) {
+	fsys := New(encryptedMapFS(t), testKey)
+
+	f, err := fsys.Open("img/logo.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		t.Fatalf("file doesn't implement io.ReadSeeker")
+	}
+
+	if _, err := rs.Seek(19996, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := io.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(tail, []byte{0x3c, 0x73, 0x76, 0x67}) {
+		t.Fatalf("unexpected tail %x", tail)
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	fsys := New(encryptedMapFS(t), []byte("wrong key"))
+
+	if _, err := fs.ReadFile(fsys, "index.html"); !errors.Is(err, chacha20.ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	fsys := New(fstest.MapFS{"plain.txt": {Data: []byte("not encrypted")}}, testKey)
+
+	var pathErr *fs.PathError
+
+	if _, err := fsys.Open("plain.txt"); !errors.As(err, &pathErr) || pathErr.Err != encfile.ErrFormat {
+		t.Fatalf("expected a path error with ErrFormat, found %v", err)
+	}
+
+	if _, err := fsys.Stat("plain.txt"); !errors.Is(err, encfile.ErrFormat) {
+		t.Fatalf("expected ErrFormat, found %v", err)
+	}
+
+	if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, found %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package hkdf implements the HMAC-based Extract-and-Expand
+// Key Derivation Function with HMAC-SHA256.
+//
+// It was coded referencing RFC 5869:
+//
+// https://datatracker.ietf.org/doc/html/rfc5869
+package hkdf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+const (
+	// Size of the pseudorandom key (HMAC-SHA256 output).
+	PRK_SIZE = sha256.Size
+
+	// Maximum length of the output keying material.
+	MAX_LENGTH = 255 * PRK_SIZE
+)
+
+// Error returned if the requested output is longer than MAX_LENGTH.
+var ErrLength = errors.New("hkdf: requested length too large")
+
+// Extract derives a pseudorandom key from the input keying material.
+// An empty salt is replaced by PRK_SIZE zero bytes.
+//
+// https://datatracker.ietf.org/doc/html/rfc5869#section-2.2
+func Extract(secret, salt []byte) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, PRK_SIZE)
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+
+	return mac.Sum(nil)
+}
+
+// Expand derives length bytes of output keying material
+// from the pseudorandom key and the context info.
+//
+// https://datatracker.ietf.org/doc/html/rfc5869#section-2.3
+func Expand(prk, info []byte, length int) ([]byte, error) {
+	if length < 0 || length > MAX_LENGTH {
+		return nil, ErrLength
+	}
+
+	mac := hmac.New(sha256.New, prk)
+	okm := make([]byte, 0, length+PRK_SIZE)
+
+	var t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		// T(i) = HMAC-Hash(PRK, T(i-1) | info | i)
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+
+		okm = mac.Sum(okm)
+		t = okm[len(okm)-PRK_SIZE:]
+	}
+
+	return okm[:length], nil
+}
+
+// Key runs Extract followed by Expand.
+func Key(secret, salt, info []byte, length int) ([]byte, error) {
+	return Expand(Extract(secret, salt), info, length)
+}
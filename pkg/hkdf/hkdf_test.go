@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hkdf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+func TestKey(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc5869#appendix-A
+	testVectors := []struct {
+		name        string
+		secret      []byte
+		salt        []byte
+		info        []byte
+		length      int
+		expectedPRK string
+		expectedOKM string
+	}{
+		{
+			name:        "A.1 basic",
+			secret:      bytes.Repeat([]byte{0x0b}, 22),
+			salt:        testutil.Sequence(0x00, 0x0d),
+			info:        testutil.Sequence(0xf0, 0xfa),
+			length:      42,
+			expectedPRK: "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+			expectedOKM: "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			name:        "A.2 longer inputs and outputs",
+			secret:      testutil.Sequence(0x00, 0x50),
+			salt:        testutil.Sequence(0x60, 0xb0),
+			info:        testutil.Sequence(0xb0, 0x100),
+			length:      82,
+			expectedPRK: "06a6b88c5853361a06104c9ceb35b45cef760014904671014a193f40c15fc244",
+			expectedOKM: "b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71cc30c58179ec3e87c14c01d5c1f3434f1d87",
+		},
+		{
+			name:        "A.3 zero-length salt and info",
+			secret:      bytes.Repeat([]byte{0x0b}, 22),
+			salt:        nil,
+			info:        nil,
+			length:      42,
+			expectedPRK: "19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+			expectedOKM: "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+	}
+
+	for _, tv := range testVectors {
+		prk := Extract(tv.secret, tv.salt)
+		if actual := hex.EncodeToString(prk); actual != tv.expectedPRK {
+			t.Fatalf("%s: PRK mismatch: expected %s, found %s", tv.name, tv.expectedPRK, actual)
+		}
+
+		okm, err := Key(tv.secret, tv.salt, tv.info, tv.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := hex.EncodeToString(okm); actual != tv.expectedOKM {
+			t.Fatalf("%s: OKM mismatch: expected %s, found %s", tv.name, tv.expectedOKM, actual)
+		}
+	}
+}
+
+func TestExpandLength(t *testing.T) {
+	prk := Extract([]byte("secret"), nil)
+
+	okm, err := Expand(prk, nil, MAX_LENGTH)
+	if err != nil || len(okm) != MAX_LENGTH {
+		t.Fatalf("expected %d bytes, found %d, %v", MAX_LENGTH, len(okm), err)
+	}
+
+	if _, err := Expand(prk, nil, MAX_LENGTH+1); err != ErrLength {
+		t.Fatalf("expected ErrLength, found %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package noise
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+var (
+	ErrNonceExhausted = errors.New("noise cipher nonce exhausted")
+	ErrNoKey          = errors.New("noise cipher has no key")
+)
+
+// CipherState encrypts and decrypts messages with ChaCha20-Poly1305
+// and a 64-bit counter nonce, as in section 5.1 of the specification.
+//
+// A transport CipherState rekeys itself every RekeyInterval messages.
+// Both peers count the same messages, so they stay in sync as long as
+// every message is delivered in order.
+type CipherState struct {
+	cipher *chacha20.Cipher
+	n      uint64
+
+	// Messages between automatic rekeys, 0 disables rekeying.
+	RekeyInterval uint64
+}
+
+// InitializeKey sets a new key and resets the nonce.
+func (cs *CipherState) initializeKey(k []byte) error {
+	cs.clear()
+
+	key := &chacha20.Key{Bytes: append([]byte(nil), k...)}
+	defer key.Clear()
+
+	c, err := key.NewCipher()
+	if err != nil {
+		return err
+	}
+
+	cs.cipher = c
+	cs.n = 0
+
+	return nil
+}
+
+// HasKey reports whether a key has been set.
+func (cs *CipherState) hasKey() bool {
+	return cs.cipher != nil
+}
+
+// Nonce returns the 96-bit nonce: 32 zero bits
+// followed by the 64-bit counter in little endian.
+func nonce(n uint64) []byte {
+	nonce := make([]byte, chacha20.NONCE_SIZE)
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce
+}
+
+// Encrypt seals the plainText with the additionalData
+// and advances the nonce.
+//
+// ErrNonceExhausted error is returned once all
+// nonces (2^64 - 1) have been used.
+func (cs *CipherState) Encrypt(additionalData, plainText []byte) ([]byte, error) {
+	if !cs.hasKey() {
+		return nil, ErrNoKey
+	}
+
+	if cs.n == ^uint64(0) {
+		return nil, ErrNonceExhausted
+	}
+
+	cipherText, err := cs.cipher.Seal(nonce(cs.n), plainText, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cs.advance(); err != nil {
+		return nil, err
+	}
+
+	return cipherText, nil
+}
+
+// Decrypt opens the cipherText with the additionalData.
+// The nonce is only advanced if the message is authentic.
+func (cs *CipherState) Decrypt(additionalData, cipherText []byte) ([]byte, error) {
+	if !cs.hasKey() {
+		return nil, ErrNoKey
+	}
+
+	if cs.n == ^uint64(0) {
+		return nil, ErrNonceExhausted
+	}
+
+	plainText, err := cs.cipher.Open(nonce(cs.n), cipherText, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cs.advance(); err != nil {
+		return nil, err
+	}
+
+	return plainText, nil
+}
+
+// Advance increments the nonce and rekeys when due.
+func (cs *CipherState) advance() error {
+	cs.n++
+
+	if cs.RekeyInterval > 0 && cs.n%cs.RekeyInterval == 0 {
+		return cs.Rekey()
+	}
+
+	return nil
+}
+
+// Rekey replaces the key with the first 32 bytes of the encryption
+// of 32 zero bytes under the maximum nonce. The nonce is kept.
+//
+// https://noiseprotocol.org/noise.html#rekey
+func (cs *CipherState) Rekey() error {
+	if !cs.hasKey() {
+		return ErrNoKey
+	}
+
+	sealed, err := cs.cipher.Seal(nonce(^uint64(0)), make([]byte, chacha20.KEY_SIZE), nil)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(sealed)
+
+	n := cs.n
+	if err := cs.initializeKey(sealed[:chacha20.KEY_SIZE]); err != nil {
+		return err
+	}
+	cs.n = n
+
+	return nil
+}
+
+// Clear wipes the key.
+func (cs *CipherState) clear() {
+	if cs.cipher != nil {
+		cs.cipher.ClearKey()
+		cs.cipher = nil
+	}
+}
+
+// SymmetricState holds the chaining key and the handshake hash.
+//
+// https://noiseprotocol.org/noise.html#the-symmetricstate-object
+type symmetricState struct {
+	cs CipherState
+	ck [HASH_LEN]byte
+	h  [HASH_LEN]byte
+}
+
+func (ss *symmetricState) initialize(protocolName string) {
+	if len(protocolName) <= HASH_LEN {
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256([]byte(protocolName))
+	}
+
+	ss.ck = ss.h
+}
+
+// Noise HKDF is HKDF-SHA256 with the chaining key as the salt
+// and empty info, split into HASH_LEN sized outputs.
+func (ss *symmetricState) hkdf(ikm []byte) ([]byte, []byte, error) {
+	out, err := hkdf.Key(ikm, ss.ck[:], nil, 2*HASH_LEN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out[:HASH_LEN], out[HASH_LEN:], nil
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) error {
+	ck, tempK, err := ss.hkdf(ikm)
+	if err != nil {
+		return err
+	}
+	defer util.Wipe(ck)
+	defer util.Wipe(tempK)
+
+	copy(ss.ck[:], ck)
+
+	return ss.cs.initializeKey(tempK)
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(ss.h[:])
+	h.Write(data)
+	h.Sum(ss.h[:0])
+}
+
+func (ss *symmetricState) encryptAndHash(plainText []byte) ([]byte, error) {
+	cipherText := plainText
+
+	if ss.cs.hasKey() {
+		var err error
+		if cipherText, err = ss.cs.Encrypt(ss.h[:], plainText); err != nil {
+			return nil, err
+		}
+	}
+
+	ss.mixHash(cipherText)
+	return cipherText, nil
+}
+
+func (ss *symmetricState) decryptAndHash(cipherText []byte) ([]byte, error) {
+	plainText := cipherText
+
+	if ss.cs.hasKey() {
+		var err error
+		if plainText, err = ss.cs.Decrypt(ss.h[:], cipherText); err != nil {
+			return nil, err
+		}
+	}
+
+	ss.mixHash(cipherText)
+	return plainText, nil
+}
+
+// Split derives the two transport CipherStates:
+// initiator to responder and responder to initiator.
+func (ss *symmetricState) split(rekeyInterval uint64) (*CipherState, *CipherState, error) {
+	k1, k2, err := ss.hkdf(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer util.Wipe(k1)
+	defer util.Wipe(k2)
+
+	c1 := &CipherState{RekeyInterval: rekeyInterval}
+	c2 := &CipherState{RekeyInterval: rekeyInterval}
+
+	if err := c1.initializeKey(k1); err != nil {
+		return nil, nil, err
+	}
+
+	if err := c2.initializeKey(k2); err != nil {
+		return nil, nil, err
+	}
+
+	ss.cs.clear()
+	util.Wipe(ss.ck[:])
+
+	return c1, c2, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package noise
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// HKDF info of the chacha20.Conn keys derived from the transport keys.
+const connKeyInfo = "chacha20 noise conn v1"
+
+// Handshake runs the handshake over conn and returns a chacha20.Conn,
+// together with the finished HandshakeState (for PeerStatic and
+// HandshakeHash).
+//
+// Handshake messages are framed with a 2-byte big endian length as
+// recommended by the specification. The Conn uses its own record format
+// with keys derived from the transport keys by HKDF, so they never
+// encrypt under the same key and nonce as the CipherStates from
+// Transport. Both directions of the Conn rekey every RekeyInterval
+// records of the Config.
+func Handshake(conn net.Conn, cfg Config) (*chacha20.Conn, *HandshakeState, error) {
+	hs, err := NewHandshake(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for !hs.Complete() {
+		if hs.myTurn() {
+			msg, err := hs.WriteMessage(nil)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			frame := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+			if _, err := conn.Write(append(frame, msg...)); err != nil {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		var frame [2]byte
+		if _, err := io.ReadFull(conn, frame[:]); err != nil {
+			return nil, nil, err
+		}
+
+		msg := make([]byte, binary.BigEndian.Uint16(frame[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, nil, err
+		}
+
+		if _, err := hs.ReadMessage(msg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sendKey, err := connKey(hs, hs.send)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer util.Wipe(sendKey)
+
+	recvKey, err := connKey(hs, hs.recv)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer util.Wipe(recvKey)
+
+	c, err := chacha20.NewConn(conn, sendKey, recvKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.RekeyInterval = hs.rekeyInterval
+
+	return c, hs, nil
+}
+
+// ConnKey derives the Conn key of one direction from the key of its
+// transport CipherState, salted with the handshake hash.
+func connKey(hs *HandshakeState, cs *CipherState) ([]byte, error) {
+	return hkdf.Key(cs.cipher.Key, hs.ss.h[:], []byte(connKeyInfo), chacha20.KEY_SIZE)
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+// Package noise implements the NN and XX handshake patterns of the
+// Noise Protocol Framework with X25519, ChaCha20-Poly1305 from the
+// chacha20 package and SHA-256:
+//
+//	Noise_NN_25519_ChaChaPoly_SHA256
+//	Noise_XX_25519_ChaChaPoly_SHA256
+//
+// A completed handshake yields two transport CipherStates,
+// one for each direction, which rekey automatically.
+//
+// It was coded referencing revision 34 of the specification:
+//
+// https://noiseprotocol.org/noise.html
+package noise
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+const (
+	DH_LEN   = 32
+	HASH_LEN = 32
+	TAG_SIZE = 16
+
+	MAX_MESSAGE_SIZE = 65535
+
+	// Default number of transport messages between rekeys.
+	DEFAULT_REKEY_INTERVAL = 1 << 16
+
+	protocolSuffix = "_25519_ChaChaPoly_SHA256"
+)
+
+var (
+	ErrPattern        = errors.New("unsupported handshake pattern")
+	ErrStaticKey      = errors.New("static key required by the handshake pattern")
+	ErrHandshakeState = errors.New("handshake message out of turn")
+	ErrIncomplete     = errors.New("handshake not complete")
+	ErrMessageSize    = errors.New("invalid handshake message size")
+)
+
+// Message tokens.
+type token byte
+
+const (
+	tokenE token = iota
+	tokenS
+	tokenEE
+	tokenES
+	tokenSE
+	tokenSS
+)
+
+// Pattern is a handshake pattern without pre-messages.
+// Messages alternate, starting with the initiator.
+type Pattern struct {
+	Name     string
+	messages [][]token
+}
+
+var (
+	// -> e
+	// <- e, ee
+	NN = Pattern{
+		Name: "NN",
+		messages: [][]token{
+			{tokenE},
+			{tokenE, tokenEE},
+		},
+	}
+
+	// -> e
+	// <- e, ee, s, es
+	// -> s, se
+	XX = Pattern{
+		Name: "XX",
+		messages: [][]token{
+			{tokenE},
+			{tokenE, tokenEE, tokenS, tokenES},
+			{tokenS, tokenSE},
+		},
+	}
+)
+
+// Config configures a handshake.
+type Config struct {
+	Pattern   Pattern
+	Initiator bool
+
+	// Optional data both peers must agree on, such as
+	// the protocol version. It's bound to the handshake hash.
+	Prologue []byte
+
+	// Long-term key of this peer, required by XX.
+	StaticKey *ecdh.PrivateKey
+
+	// Source of the ephemeral keys, crypto/rand by default.
+	Random io.Reader
+
+	// Transport messages between rekeys, DEFAULT_REKEY_INTERVAL if 0.
+	// A negative value disables automatic rekeying.
+	RekeyInterval int64
+}
+
+// HandshakeState runs one side of a handshake.
+type HandshakeState struct {
+	ss symmetricState
+
+	s  *ecdh.PrivateKey
+	e  *ecdh.PrivateKey
+	rs *ecdh.PublicKey
+	re *ecdh.PublicKey
+
+	pattern       Pattern
+	initiator     bool
+	random        io.Reader
+	rekeyInterval uint64
+
+	msgIndex int
+	send     *CipherState
+	recv     *CipherState
+}
+
+// NewHandshake initializes the handshake
+// and mixes the prologue into its hash.
+func NewHandshake(cfg Config) (*HandshakeState, error) {
+	if len(cfg.Pattern.messages) == 0 {
+		return nil, ErrPattern
+	}
+
+	if cfg.Pattern.Name == XX.Name && cfg.StaticKey == nil {
+		return nil, ErrStaticKey
+	}
+
+	if cfg.StaticKey != nil && cfg.StaticKey.Curve() != ecdh.X25519() {
+		return nil, ErrStaticKey
+	}
+
+	hs := &HandshakeState{
+		s:             cfg.StaticKey,
+		pattern:       cfg.Pattern,
+		initiator:     cfg.Initiator,
+		random:        cfg.Random,
+		rekeyInterval: DEFAULT_REKEY_INTERVAL,
+	}
+
+	if hs.random == nil {
+		hs.random = rand.Reader
+	}
+
+	switch {
+	case cfg.RekeyInterval > 0:
+		hs.rekeyInterval = uint64(cfg.RekeyInterval)
+	case cfg.RekeyInterval < 0:
+		hs.rekeyInterval = 0
+	}
+
+	hs.ss.initialize("Noise_" + cfg.Pattern.Name + protocolSuffix)
+	hs.ss.mixHash(cfg.Prologue)
+
+	return hs, nil
+}
+
+// Complete reports whether all handshake messages were processed.
+func (hs *HandshakeState) Complete() bool {
+	return hs.msgIndex == len(hs.pattern.messages)
+}
+
+// Transport returns the CipherStates for sending and receiving
+// transport messages once the handshake is complete.
+func (hs *HandshakeState) Transport() (send *CipherState, recv *CipherState, err error) {
+	if !hs.Complete() {
+		return nil, nil, ErrIncomplete
+	}
+
+	return hs.send, hs.recv, nil
+}
+
+// HandshakeHash returns the final handshake hash, which both peers
+// share and which can be used for channel binding.
+func (hs *HandshakeState) HandshakeHash() []byte {
+	return append([]byte(nil), hs.ss.h[:]...)
+}
+
+// PeerStatic returns the peer's static public key
+// received during the handshake, nil if none.
+func (hs *HandshakeState) PeerStatic() []byte {
+	if hs.rs == nil {
+		return nil
+	}
+
+	return hs.rs.Bytes()
+}
+
+// MyTurn reports whether this peer writes the next message.
+func (hs *HandshakeState) myTurn() bool {
+	return (hs.msgIndex%2 == 0) == hs.initiator
+}
+
+// WriteMessage creates the next handshake message carrying the payload.
+// The payload of early messages is not encrypted; in XX only the last
+// two messages keep it confidential.
+func (hs *HandshakeState) WriteMessage(payload []byte) ([]byte, error) {
+	if hs.Complete() || !hs.myTurn() {
+		return nil, ErrHandshakeState
+	}
+
+	var msg []byte
+
+	for _, t := range hs.pattern.messages[hs.msgIndex] {
+		switch t {
+		case tokenE:
+			e, err := hs.generateEphemeral()
+			if err != nil {
+				return nil, err
+			}
+
+			hs.e = e
+			msg = append(msg, e.PublicKey().Bytes()...)
+			hs.ss.mixHash(e.PublicKey().Bytes())
+
+		case tokenS:
+			sealed, err := hs.ss.encryptAndHash(hs.s.PublicKey().Bytes())
+			if err != nil {
+				return nil, err
+			}
+
+			msg = append(msg, sealed...)
+
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sealed, err := hs.ss.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg = append(msg, sealed...)
+
+	if len(msg) > MAX_MESSAGE_SIZE {
+		return nil, ErrMessageSize
+	}
+
+	return msg, hs.next()
+}
+
+// ReadMessage processes the next handshake message from the peer
+// and returns its payload. After an error the handshake must be
+// abandoned, since its state is no longer consistent.
+func (hs *HandshakeState) ReadMessage(msg []byte) ([]byte, error) {
+	if hs.Complete() || hs.myTurn() {
+		return nil, ErrHandshakeState
+	}
+
+	if len(msg) > MAX_MESSAGE_SIZE {
+		return nil, ErrMessageSize
+	}
+
+	for _, t := range hs.pattern.messages[hs.msgIndex] {
+		switch t {
+		case tokenE:
+			if len(msg) < DH_LEN {
+				return nil, ErrMessageSize
+			}
+
+			re, err := ecdh.X25519().NewPublicKey(msg[:DH_LEN])
+			if err != nil {
+				return nil, err
+			}
+
+			hs.re = re
+			hs.ss.mixHash(msg[:DH_LEN])
+			msg = msg[DH_LEN:]
+
+		case tokenS:
+			n := DH_LEN
+			if hs.ss.cs.hasKey() {
+				n += TAG_SIZE
+			}
+
+			if len(msg) < n {
+				return nil, ErrMessageSize
+			}
+
+			s, err := hs.ss.decryptAndHash(msg[:n])
+			if err != nil {
+				return nil, err
+			}
+
+			rs, err := ecdh.X25519().NewPublicKey(s)
+			if err != nil {
+				return nil, err
+			}
+
+			hs.rs = rs
+			msg = msg[n:]
+
+		default:
+			if err := hs.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	payload, err := hs.ss.decryptAndHash(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, hs.next()
+}
+
+// GenerateEphemeral reads the private ephemeral key from the random
+// source as is, so the handshake is reproducible for test vectors.
+func (hs *HandshakeState) generateEphemeral() (*ecdh.PrivateKey, error) {
+	b := make([]byte, DH_LEN)
+	if _, err := io.ReadFull(hs.random, b); err != nil {
+		return nil, err
+	}
+
+	return ecdh.X25519().NewPrivateKey(b)
+}
+
+// MixDH performs the DH of a token and mixes the result into the key.
+// The initiator's and responder's views of es and se are mirrored.
+func (hs *HandshakeState) mixDH(t token) error {
+	var priv *ecdh.PrivateKey
+	var pub *ecdh.PublicKey
+
+	switch {
+	case t == tokenEE:
+		priv, pub = hs.e, hs.re
+	case t == tokenSS:
+		priv, pub = hs.s, hs.rs
+	case (t == tokenES) == hs.initiator:
+		priv, pub = hs.e, hs.rs
+	default:
+		priv, pub = hs.s, hs.re
+	}
+
+	if priv == nil || pub == nil {
+		return ErrStaticKey
+	}
+
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return err
+	}
+
+	return hs.ss.mixKey(shared)
+}
+
+// Next moves to the following message and splits
+// the transport keys after the last one.
+func (hs *HandshakeState) next() error {
+	hs.msgIndex++
+
+	if !hs.Complete() {
+		return nil
+	}
+
+	c1, c2, err := hs.ss.split(hs.rekeyInterval)
+	if err != nil {
+		return err
+	}
+
+	hs.send, hs.recv = c1, c2
+	if !hs.initiator {
+		hs.send, hs.recv = c2, c1
+	}
+
+	hs.e = nil
+
+	return nil
+}
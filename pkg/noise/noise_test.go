@@ -0,0 +1,471 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package noise
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wedkarz02/chacha20"
+)
+
+// Vector is a single handshake from testdata/noise/vectors.txt.
+type vector struct {
+	name        string
+	initStatic  []byte
+	respStatic  []byte
+	initEph     []byte
+	respEph     []byte
+	prologue    []byte
+	payloads    [][]byte
+	cipherTexts [][]byte
+	pattern     Pattern
+	lineNumber  int
+}
+
+func loadVectors(t *testing.T) []*vector {
+	t.Helper()
+
+	f, err := os.Open("../../testdata/noise/vectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var vectors []*vector
+	var v *vector
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			t.Fatalf("line %d: malformed vector", line)
+		}
+
+		if key == "handshake" {
+			v = &vector{name: value, lineNumber: line}
+			vectors = append(vectors, v)
+
+			switch {
+			case strings.HasPrefix(value, "Noise_NN_"):
+				v.pattern = NN
+			case strings.HasPrefix(value, "Noise_XX_"):
+				v.pattern = XX
+			default:
+				t.Fatalf("line %d: unexpected handshake %s", line, value)
+			}
+			continue
+		}
+
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			t.Fatalf("line %d: %v", line, err)
+		}
+
+		switch {
+		case key == "init_static":
+			v.initStatic = b
+		case key == "resp_static":
+			v.respStatic = b
+		case key == "gen_init_ephemeral":
+			v.initEph = b
+		case key == "gen_resp_ephemeral":
+			v.respEph = b
+		case key == "prologue":
+			v.prologue = b
+		case strings.HasSuffix(key, "_payload"):
+			v.payloads = append(v.payloads, b)
+		case strings.HasSuffix(key, "_ciphertext"):
+			v.cipherTexts = append(v.cipherTexts, b)
+		default:
+			t.Fatalf("line %d: unexpected key %s", line, key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return vectors
+}
+
+func staticKey(t *testing.T, b []byte) *ecdh.PrivateKey {
+	t.Helper()
+
+	if b == nil {
+		return nil
+	}
+
+	k, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestVectors(t *testing.T) {
+	vectors := loadVectors(t)
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+
+	for _, v := range vectors {
+		initiator, err := NewHandshake(Config{
+			Pattern:   v.pattern,
+			Initiator: true,
+			Prologue:  v.prologue,
+			StaticKey: staticKey(t, v.initStatic),
+			Random:    bytes.NewReader(v.initEph),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		responder, err := NewHandshake(Config{
+			Pattern:   v.pattern,
+			Prologue:  v.prologue,
+			StaticKey: staticKey(t, v.respStatic),
+			Random:    bytes.NewReader(v.respEph),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handshakeMessages := len(v.pattern.messages)
+
+		for i, payload := range v.payloads {
+			// Handshake messages alternate starting with the initiator,
+			// and so do the transport messages that follow.
+			turn := i
+			if i >= handshakeMessages {
+				turn = i - handshakeMessages
+			}
+
+			writer, reader := initiator, responder
+			if turn%2 != 0 {
+				writer, reader = responder, initiator
+			}
+
+			var msg, received []byte
+
+			if i < handshakeMessages {
+				if msg, err = writer.WriteMessage(payload); err != nil {
+					t.Fatalf("%s (line %d): message %d: %v", v.name, v.lineNumber, i, err)
+				}
+
+				if received, err = reader.ReadMessage(msg); err != nil {
+					t.Fatalf("%s (line %d): message %d: %v", v.name, v.lineNumber, i, err)
+				}
+			} else {
+				send, _, err := writer.Transport()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, recv, err := reader.Transport()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if msg, err = send.Encrypt(nil, payload); err != nil {
+					t.Fatal(err)
+				}
+
+				if received, err = recv.Decrypt(nil, msg); err != nil {
+					t.Fatalf("%s (line %d): message %d: %v", v.name, v.lineNumber, i, err)
+				}
+			}
+
+			if !bytes.Equal(msg, v.cipherTexts[i]) {
+				t.Fatalf("%s (line %d): message %d mismatch:\nexpected %x\nfound    %x", v.name, v.lineNumber, i, v.cipherTexts[i], msg)
+			}
+
+			if !bytes.Equal(received, payload) {
+				t.Fatalf("%s (line %d): payload %d mismatch", v.name, v.lineNumber, i)
+			}
+		}
+
+		if !bytes.Equal(initiator.HandshakeHash(), responder.HandshakeHash()) {
+			t.Fatalf("%s: handshake hashes differ", v.name)
+		}
+
+		if v.pattern.Name == XX.Name {
+			if !bytes.Equal(initiator.PeerStatic(), staticKey(t, v.respStatic).PublicKey().Bytes()) {
+				t.Fatalf("%s: initiator learned the wrong static key", v.name)
+			}
+
+			if !bytes.Equal(responder.PeerStatic(), staticKey(t, v.initStatic).PublicKey().Bytes()) {
+				t.Fatalf("%s: responder learned the wrong static key", v.name)
+			}
+		}
+	}
+}
+
+// Handshake runs a complete handshake between two fresh peers.
+func handshake(t *testing.T, pattern Pattern, rekeyInterval int64) (*HandshakeState, *HandshakeState) {
+	t.Helper()
+
+	var initStatic, respStatic *ecdh.PrivateKey
+	if pattern.Name == XX.Name {
+		initStatic = staticKey(t, bytes.Repeat([]byte{0x01}, DH_LEN))
+		respStatic = staticKey(t, bytes.Repeat([]byte{0x02}, DH_LEN))
+	}
+
+	initiator, err := NewHandshake(Config{Pattern: pattern, Initiator: true, StaticKey: initStatic, RekeyInterval: rekeyInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responder, err := NewHandshake(Config{Pattern: pattern, StaticKey: respStatic, RekeyInterval: rekeyInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, reader := initiator, responder
+	for !initiator.Complete() {
+		msg, err := writer.WriteMessage(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := reader.ReadMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		writer, reader = reader, writer
+	}
+
+	if !responder.Complete() {
+		t.Fatal("responder didn't complete the handshake")
+	}
+
+	return initiator, responder
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	if _, err := NewHandshake(Config{Pattern: XX}); err != ErrStaticKey {
+		t.Fatalf("expected ErrStaticKey, found %v", err)
+	}
+
+	if _, err := NewHandshake(Config{}); err != ErrPattern {
+		t.Fatalf("expected ErrPattern, found %v", err)
+	}
+
+	initiator, _ := NewHandshake(Config{Pattern: NN, Initiator: true})
+	responder, _ := NewHandshake(Config{Pattern: NN})
+
+	if _, err := responder.WriteMessage(nil); err != ErrHandshakeState {
+		t.Fatalf("expected ErrHandshakeState for the responder writing first, found %v", err)
+	}
+
+	if _, _, err := initiator.Transport(); err != ErrIncomplete {
+		t.Fatalf("expected ErrIncomplete, found %v", err)
+	}
+
+	msg, _ := initiator.WriteMessage(nil)
+	if _, err := responder.ReadMessage(msg[:DH_LEN-1]); err != ErrMessageSize {
+		t.Fatalf("expected ErrMessageSize, found %v", err)
+	}
+
+	completed, _ := handshake(t, XX, 0)
+	if _, err := completed.WriteMessage(nil); err != ErrHandshakeState {
+		t.Fatalf("expected ErrHandshakeState after completion, found %v", err)
+	}
+
+	initStatic := staticKey(t, bytes.Repeat([]byte{0x01}, DH_LEN))
+	respStatic := staticKey(t, bytes.Repeat([]byte{0x02}, DH_LEN))
+
+	initiator, _ = NewHandshake(Config{Pattern: XX, Initiator: true, StaticKey: initStatic})
+	responder, _ = NewHandshake(Config{Pattern: XX, StaticKey: respStatic})
+
+	// A modified XX message fails authentication.
+	msg, _ = initiator.WriteMessage(nil)
+	responder.ReadMessage(msg)
+
+	msg, _ = responder.WriteMessage([]byte("payload"))
+	msg[len(msg)-1] ^= 0x01
+
+	if _, err := initiator.ReadMessage(msg); err != chacha20.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	initiator, responder := handshake(t, NN, 3)
+	plain, _ := handshake(t, NN, -1)
+
+	send, _, _ := initiator.Transport()
+	_, recv, _ := responder.Transport()
+	plainSend, _, _ := plain.Transport()
+
+	if send.RekeyInterval != 3 || plainSend.RekeyInterval != 0 {
+		t.Fatalf("unexpected rekey intervals %d and %d", send.RekeyInterval, plainSend.RekeyInterval)
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := []byte("message " + strconv.Itoa(i))
+
+		sealed, err := send.Encrypt(nil, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opened, err := recv.Decrypt(nil, sealed)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if !bytes.Equal(opened, msg) {
+			t.Fatalf("message %d: mismatch", i)
+		}
+	}
+
+	if send.n != 10 || recv.n != 10 {
+		t.Fatalf("the nonce must survive rekeying, found %d and %d", send.n, recv.n)
+	}
+}
+
+func TestRekeyChangesKey(t *testing.T) {
+	var a, b CipherState
+	key := bytes.Repeat([]byte{0x42}, chacha20.KEY_SIZE)
+
+	if err := a.initializeKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.initializeKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+
+	sealedA, _ := a.Encrypt(nil, []byte("msg"))
+	sealedB, _ := b.Encrypt(nil, []byte("msg"))
+
+	if bytes.Equal(sealedA, sealedB) {
+		t.Fatal("rekeying didn't change the key")
+	}
+
+	// The old key can't open messages sealed after a rekey.
+	var c CipherState
+	c.initializeKey(key)
+
+	if _, err := c.Decrypt(nil, sealedB); err != chacha20.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+
+	// Decryption failures don't advance the nonce.
+	if c.n != 0 {
+		t.Fatalf("nonce advanced on failure")
+	}
+
+	var empty CipherState
+	if _, err := empty.Encrypt(nil, nil); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, found %v", err)
+	}
+}
+
+func TestHandshakeConn(t *testing.T) {
+	p1, p2 := net.Pipe()
+
+	initStatic := staticKey(t, bytes.Repeat([]byte{0x01}, DH_LEN))
+	respStatic := staticKey(t, bytes.Repeat([]byte{0x02}, DH_LEN))
+
+	type result struct {
+		conn *chacha20.Conn
+		hs   *HandshakeState
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		c, hs, err := Handshake(p2, Config{Pattern: XX, StaticKey: respStatic, RekeyInterval: 2})
+		done <- result{c, hs, err}
+	}()
+
+	client, clientHS, err := Handshake(p1, Config{Pattern: XX, Initiator: true, StaticKey: initStatic, RekeyInterval: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	if !bytes.Equal(clientHS.PeerStatic(), respStatic.PublicKey().Bytes()) {
+		t.Fatal("client learned the wrong server key")
+	}
+
+	// The Conn keys must differ from the transport keys, so using
+	// both the Conn and Transport never reuses a key and nonce.
+	send, _, err := clientHS.Transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendKey, err := connKey(clientHS, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(sendKey, send.cipher.Key) {
+		t.Fatal("Conn key equals the transport key")
+	}
+
+	if client.RekeyInterval != 2 || res.conn.RekeyInterval != 2 {
+		t.Fatal("rekey interval not passed to the Conn")
+	}
+
+	// Several records, so both sides rekey a few times.
+	go func() {
+		for _, word := range []string{"hello ", "over ", "noise ", "with ", "rekeying"} {
+			client.Write([]byte(word))
+		}
+		client.Close()
+	}()
+
+	received, err := io.ReadAll(res.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(received) != "hello over noise with rekeying" {
+		t.Fatalf("expected %q, found %q", "hello over noise with rekeying", received)
+	}
+
+	res.conn.Close()
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package pbkdf2 implements the PBKDF2 password-based
+// key derivation function with HMAC-SHA256.
+//
+// It was coded referencing RFC 8018:
+//
+// https://datatracker.ietf.org/doc/html/rfc8018#section-5.2
+package pbkdf2
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Error returned if the iteration count or the key length is not positive.
+var ErrParams = errors.New("invalid pbkdf2 parameters")
+
+// Key derives a keyLen bytes long key from the password
+// and the salt using iter iterations of HMAC-SHA256.
+func Key(password, salt []byte, iter, keyLen int) ([]byte, error) {
+	if iter < 1 || keyLen < 1 {
+		return nil, ErrParams
+	}
+
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+
+	for block := 1; block <= numBlocks; block++ {
+		// U_1 = PRF(P, S || INT(i))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		// T_i = U_1 ^ U_2 ^ ... ^ U_c
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+
+	return dk[:keyLen], nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package pbkdf2
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc7914#section-11
+	// and the commonly used PBKDF2-HMAC-SHA256 vectors.
+	testVectors := []struct {
+		password    string
+		salt        string
+		iter        int
+		keyLen      int
+		expectedKey string
+	}{
+		{
+			password:    "passwd",
+			salt:        "salt",
+			iter:        1,
+			keyLen:      64,
+			expectedKey: "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			password:    "Password",
+			salt:        "NaCl",
+			iter:        80000,
+			keyLen:      64,
+			expectedKey: "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+		{
+			password:    "password",
+			salt:        "salt",
+			iter:        4096,
+			keyLen:      32,
+			expectedKey: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+		},
+		{
+			password:    "passwordPASSWORDpassword",
+			salt:        "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			iter:        4096,
+			keyLen:      40,
+			expectedKey: "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9",
+		},
+	}
+
+	for _, tv := range testVectors {
+		key, err := Key([]byte(tv.password), []byte(tv.salt), tv.iter, tv.keyLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := hex.EncodeToString(key); actual != tv.expectedKey {
+			t.Fatalf("%q/%q: expected %s, found %s", tv.password, tv.salt, tv.expectedKey, actual)
+		}
+	}
+}
+
+func TestKeyParams(t *testing.T) {
+	if _, err := Key([]byte("p"), []byte("s"), 0, 32); err != ErrParams {
+		t.Fatalf("expected ErrParams for zero iterations, found %v", err)
+	}
+
+	if _, err := Key([]byte("p"), []byte("s"), 1, 0); err != ErrParams {
+		t.Fatalf("expected ErrParams for zero key length, found %v", err)
+	}
+}
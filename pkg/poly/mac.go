@@ -0,0 +1,114 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package poly
+
+import "crypto/subtle"
+
+// MAC computes a Poly1305 tag over data written in pieces.
+// Partial 16-byte blocks are buffered across Write calls,
+// so any split of the message produces the same tag as Sum.
+//
+// A MAC must not be reused with the same key for a different
+// message; call Reset with a fresh one-time key instead.
+type MAC struct {
+	st     macState
+	buf    [BLOCK_SIZE]byte
+	offset int
+}
+
+// New returns a MAC keyed with a 32-byte one-time key.
+//
+// ErrPolyKeySize error is returned if the key has an invalid size.
+func New(key []byte) (*MAC, error) {
+	m := new(MAC)
+	if err := m.Reset(key); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Reset discards the written data and rekeys the MAC.
+//
+// ErrPolyKeySize error is returned if the key has an invalid size.
+func (m *MAC) Reset(key []byte) error {
+	if len(key) != KEY_SIZE {
+		return ErrPolyKeySize
+	}
+
+	m.st.init(key)
+	m.buf = [BLOCK_SIZE]byte{}
+	m.offset = 0
+
+	return nil
+}
+
+// Size returns the length of the tag in bytes.
+func (m *MAC) Size() int { return TAG_SIZE }
+
+// BlockSize returns the size of a Poly1305 message block.
+func (m *MAC) BlockSize() int { return BLOCK_SIZE }
+
+// Write absorbs more of the message. It never returns an error.
+func (m *MAC) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if m.offset > 0 {
+		copied := copy(m.buf[m.offset:], p)
+		m.offset += copied
+		p = p[copied:]
+
+		if m.offset < BLOCK_SIZE {
+			return n, nil
+		}
+
+		m.st.blocks(m.buf[:])
+		m.offset = 0
+	}
+
+	// Full blocks go straight to the accumulator,
+	// only the trailing partial block is buffered.
+	full := len(p) - len(p)%BLOCK_SIZE
+	m.st.blocks(p[:full])
+	m.offset = copy(m.buf[:], p[full:])
+
+	return n, nil
+}
+
+// Sum appends the tag of the data written so far to b.
+// It does not change the state, so more data may be
+// written afterwards.
+func (m *MAC) Sum(b []byte) []byte {
+	st := m.st
+	st.blocks(m.buf[:m.offset])
+
+	var tag [TAG_SIZE]byte
+	st.finalize(&tag)
+
+	return append(b, tag[:]...)
+}
+
+// Verify reports whether tag is the valid tag of the
+// data written so far. The tags are compared in constant time.
+func (m *MAC) Verify(tag []byte) bool {
+	var expected [TAG_SIZE]byte
+	return subtle.ConstantTimeCompare(tag, m.Sum(expected[:0])) == 1
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package poly
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestMAC(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	key := make([]byte, KEY_SIZE)
+	msg := make([]byte, 1000)
+	rng.Read(key)
+	rng.Read(msg)
+
+	expected, err := Sum(msg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := m.Reset(key); err != nil {
+			t.Fatal(err)
+		}
+
+		// Random split points, including empty writes
+		// and pieces crossing several blocks.
+		for rest := msg; len(rest) > 0; {
+			n := rng.Intn(3 * BLOCK_SIZE)
+			if n > len(rest) {
+				n = len(rest)
+			}
+
+			if _, err := m.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+
+		if tag := m.Sum(nil); !bytes.Equal(tag, expected[:]) {
+			t.Fatalf("tag mismatch: expected %x, found %x", expected, tag)
+		}
+
+		if !m.Verify(expected[:]) {
+			t.Fatalf("valid tag rejected")
+		}
+	}
+}
+
+func TestMACPrefixes(t *testing.T) {
+	key := bytes.Repeat([]byte{0xff}, KEY_SIZE)
+	msg := bytes.Repeat([]byte{0xa5}, 4*BLOCK_SIZE+1)
+
+	m, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sum must not disturb the state, so the tag of every
+	// prefix can be taken while writing one byte at a time.
+	for i := 0; i <= len(msg); i++ {
+		expected, _ := Sum(msg[:i], key)
+
+		if tag := m.Sum(nil); !bytes.Equal(tag, expected[:]) {
+			t.Fatalf("prefix %d: expected %x, found %x", i, expected, tag)
+		}
+
+		if i < len(msg) {
+			m.Write(msg[i : i+1])
+		}
+	}
+}
+
+func TestMACWriter(t *testing.T) {
+	key := make([]byte, KEY_SIZE)
+	key[0] = 0x01
+
+	msg := bytes.Repeat([]byte("Cryptographic Forum Research Group"), 100)
+	expected, _ := Sum(msg, key)
+
+	m, _ := New(key)
+
+	// Copy through a reader that hands out odd-sized pieces.
+	if _, err := io.CopyBuffer(m, bytes.NewReader(msg), make([]byte, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	if tag := m.Sum(nil); !bytes.Equal(tag, expected[:]) {
+		t.Fatalf("tag mismatch: expected %x, found %x", expected, tag)
+	}
+
+	if m.Size() != TAG_SIZE {
+		t.Fatalf("expected size %d, found %d", TAG_SIZE, m.Size())
+	}
+}
+
+func TestMACKeySize(t *testing.T) {
+	if _, err := New(make([]byte, KEY_SIZE+1)); err != ErrPolyKeySize {
+		t.Fatalf("expected ErrPolyKeySize, found %v", err)
+	}
+
+	m, _ := New(make([]byte, KEY_SIZE))
+	if err := m.Reset(nil); err != ErrPolyKeySize {
+		t.Fatalf("expected ErrPolyKeySize, found %v", err)
+	}
+}
+
+func BenchmarkMAC(b *testing.B) {
+	key := make([]byte, KEY_SIZE)
+	chunk := make([]byte, 1000)
+
+	m, _ := New(key)
+
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		m.Write(chunk)
+	}
+
+	m.Sum(nil)
+}
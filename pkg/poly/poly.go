@@ -17,34 +17,198 @@
 // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
-
 // Package poly implements the Poly1305 Message Authentication
 // Code algorithm.
 //
 // It was coded referencing RFC	8439:
 //
 // https://datatracker.ietf.org/doc/html/rfc8439#section-2.5
+//
+// The arithmetic uses fixed-width 64-bit limbs and runs in
+// time that depends only on the length of the message.
 package poly
 
 import (
+	"crypto/subtle"
+	"encoding/binary"
 	"errors"
+	"math/bits"
 )
 
 const (
 	TAG_SIZE = 16
 	R_SIZE   = 16
 	S_SIZE   = 16
-	PRIME_P  = 0x3fffffffffffffffffffffffffffffffb
+
+	// Prime 2^130 - 5. The constant does not fit in any integer
+	// type and only documents the modulus; the implementation
+	// works on the limbs p0, p1 and p2 below.
+	PRIME_P = 0x3fffffffffffffffffffffffffffffffb
+
+	// Size of the one-time key: r followed by s.
+	KEY_SIZE = R_SIZE + S_SIZE
+
+	// Size of a single message block.
+	BLOCK_SIZE = 16
+)
+
+// PRIME_P split into 64-bit little endian limbs.
+const (
+	p0 = uint64(PRIME_P & 0xffffffffffffffff)
+	p1 = uint64(PRIME_P >> 64 & 0xffffffffffffffff)
+	p2 = uint64(PRIME_P >> 128)
+)
+
+// Clamping masks for the two 64-bit halves of r.
+const (
+	rMask0 = 0x0ffffffc0fffffff
+	rMask1 = 0x0ffffffc0ffffffc
 )
 
 var ErrPolyKeySize = errors.New("invalid poly1305 key size")
 
-func clamp(r []byte) {
-	r[3] &= 15
-	r[7] &= 15
-	r[11] &= 15
-	r[15] &= 15
-	r[4] &= 252
-	r[8] &= 252
-	r[12] &= 252
+// MacState holds the accumulator h and the key halves r and s.
+//
+// The accumulator is kept partially reduced: h[2] only ever
+// holds a few bits above 2^130 between blocks.
+type macState struct {
+	h [3]uint64
+	r [2]uint64
+	s [2]uint64
+}
+
+// Sum computes the Poly1305 tag of the message
+// using a 32-byte one-time key.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#section-2.5.1
+func Sum(msg []byte, key []byte) ([TAG_SIZE]byte, error) {
+	var tag [TAG_SIZE]byte
+
+	if len(key) != KEY_SIZE {
+		return tag, ErrPolyKeySize
+	}
+
+	var st macState
+	st.init(key)
+	st.blocks(msg)
+	st.finalize(&tag)
+
+	return tag, nil
+}
+
+// Verify reports whether tag is the valid Poly1305 tag
+// of the message. The tags are compared in constant time.
+func Verify(tag []byte, msg []byte, key []byte) bool {
+	expected, err := Sum(msg, key)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(tag, expected[:]) == 1
+}
+
+// Init loads the clamped r and s from the one-time key
+// and clears the accumulator.
+func (st *macState) init(key []byte) {
+	st.h = [3]uint64{}
+	st.r[0] = binary.LittleEndian.Uint64(key[0:8]) & rMask0
+	st.r[1] = binary.LittleEndian.Uint64(key[8:16]) & rMask1
+	st.s[0] = binary.LittleEndian.Uint64(key[16:24])
+	st.s[1] = binary.LittleEndian.Uint64(key[24:32])
+}
+
+// Blocks absorbs the message into the accumulator. Every block gets
+// an extra 0x01 byte appended: bit 128 for full blocks, or the byte
+// right after the data for a trailing partial block.
+func (st *macState) blocks(msg []byte) {
+	h0, h1, h2 := st.h[0], st.h[1], st.h[2]
+	r0, r1 := st.r[0], st.r[1]
+
+	for len(msg) > 0 {
+		var c uint64
+
+		if len(msg) >= BLOCK_SIZE {
+			h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(msg[0:8]), 0)
+			h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(msg[8:16]), c)
+			h2 += c + 1
+			msg = msg[BLOCK_SIZE:]
+		} else {
+			var block [BLOCK_SIZE]byte
+			copy(block[:], msg)
+			block[len(msg)] = 0x01
+
+			h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(block[0:8]), 0)
+			h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(block[8:16]), c)
+			h2 += c
+			msg = nil
+		}
+
+		h0, h1, h2 = mulReduce(h0, h1, h2, r0, r1)
+	}
+
+	st.h[0], st.h[1], st.h[2] = h0, h1, h2
+}
+
+// MulReduce computes h * r and partially reduces the product
+// modulo 2^130 - 5.
+//
+// Clamping keeps the top four bits of r0 and r1 clear and h2 is
+// small, so no partial product of h2 overflows 64 bits.
+func mulReduce(h0, h1, h2, r0, r1 uint64) (uint64, uint64, uint64) {
+	h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+	h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+	h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+	h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+	h2r0 := h2 * r0
+	h2r1 := h2 * r1
+
+	// The 256-bit product t3:t2:t1:t0.
+	m1lo, c := bits.Add64(h1r0lo, h0r1lo, 0)
+	m1hi, _ := bits.Add64(h1r0hi, h0r1hi, c)
+	m2lo, c := bits.Add64(h2r0, h1r1lo, 0)
+	m2hi, _ := bits.Add64(0, h1r1hi, c)
+
+	t0 := h0r0lo
+	t1, c := bits.Add64(m1lo, h0r0hi, 0)
+	t2, c := bits.Add64(m2lo, m1hi, c)
+	t3, _ := bits.Add64(h2r1, m2hi, c)
+
+	// Since 2^130 = 5 (mod p), the bits above 2^130 are folded back
+	// as 5 * (t >> 130) = 4 * (t >> 130) + (t >> 130).
+	h0, h1, h2 = t0, t1, t2&3
+
+	cc0, cc1 := t2&^3, t3
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+
+	cc0, cc1 = cc0>>2|cc1<<62, cc1>>2
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+
+	return h0, h1, h2
+}
+
+// Finalize fully reduces the accumulator, adds s and writes the
+// 128 least significant bits to tag. The reduction subtracts p
+// and selects the result with a mask instead of a branch.
+func (st *macState) finalize(tag *[TAG_SIZE]byte) {
+	h0, h1, h2 := st.h[0], st.h[1], st.h[2]
+
+	t0, b := bits.Sub64(h0, p0, 0)
+	t1, b := bits.Sub64(h1, p1, b)
+	_, b = bits.Sub64(h2, p2, b)
+
+	// A borrow means h < p and h is kept, otherwise h - p is used.
+	mask := b - 1
+	h0 = h0&^mask | t0&mask
+	h1 = h1&^mask | t1&mask
+
+	var c uint64
+	h0, c = bits.Add64(h0, st.s[0], 0)
+	h1, _ = bits.Add64(h1, st.s[1], c)
+
+	binary.LittleEndian.PutUint64(tag[0:8], h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h1)
 }
@@ -0,0 +1,387 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package poly
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSum(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#section-2.5.2
+	testVectors := []struct {
+		key         string
+		msg         []byte
+		expectedTag string
+	}{
+		{
+			key:         "85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b",
+			msg:         []byte("Cryptographic Forum Research Group"),
+			expectedTag: "a8061dc1305136c6c22b8baf0c0127a9",
+		},
+	}
+
+	for _, tv := range testVectors {
+		key, _ := hex.DecodeString(tv.key)
+
+		tag, err := Sum(tv.msg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := hex.EncodeToString(tag[:]); actual != tv.expectedTag {
+			t.Fatalf("tag mismatch: expected %s, found %s", tv.expectedTag, actual)
+		}
+
+		if !Verify(tag[:], tv.msg, key) {
+			t.Fatalf("valid tag rejected")
+		}
+
+		tag[0] ^= 0x01
+		if Verify(tag[:], tv.msg, key) {
+			t.Fatalf("modified tag accepted")
+		}
+	}
+}
+
+func TestSumVectors(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A.3
+	testVectors := []struct {
+		key         string
+		msg         string
+		expectedTag string
+	}{
+		{
+			key: "0000000000000000000000000000000000000000000000000000000000000000",
+			msg: "" +
+				"0000000000000000000000000000000000000000000000000000000000000000" +
+				"0000000000000000000000000000000000000000000000000000000000000000",
+			expectedTag: "00000000000000000000000000000000",
+		},
+		{
+			key: "0000000000000000000000000000000036e5f6b5c5e06070f0efca96227a863e",
+			msg: "" +
+				"416e79207375626d697373696f6e20746f20746865204945544620696e74656e" +
+				"6465642062792074686520436f6e7472696275746f7220666f72207075626c69" +
+				"636174696f6e20617320616c6c206f722070617274206f6620616e2049455446" +
+				"20496e7465726e65742d4472616674206f722052464320616e6420616e792073" +
+				"746174656d656e74206d6164652077697468696e2074686520636f6e74657874" +
+				"206f6620616e204945544620616374697669747920697320636f6e7369646572" +
+				"656420616e20224945544620436f6e747269627574696f6e222e205375636820" +
+				"73746174656d656e747320696e636c756465206f72616c2073746174656d656e" +
+				"747320696e20494554462073657373696f6e732c2061732077656c6c20617320" +
+				"7772697474656e20616e6420656c656374726f6e696320636f6d6d756e696361" +
+				"74696f6e73206d61646520617420616e792074696d65206f7220706c6163652c" +
+				"207768696368206172652061646472657373656420746f",
+			expectedTag: "36e5f6b5c5e06070f0efca96227a863e",
+		},
+		{
+			key: "36e5f6b5c5e06070f0efca96227a863e00000000000000000000000000000000",
+			msg: "" +
+				"416e79207375626d697373696f6e20746f20746865204945544620696e74656e" +
+				"6465642062792074686520436f6e7472696275746f7220666f72207075626c69" +
+				"636174696f6e20617320616c6c206f722070617274206f6620616e2049455446" +
+				"20496e7465726e65742d4472616674206f722052464320616e6420616e792073" +
+				"746174656d656e74206d6164652077697468696e2074686520636f6e74657874" +
+				"206f6620616e204945544620616374697669747920697320636f6e7369646572" +
+				"656420616e20224945544620436f6e747269627574696f6e222e205375636820" +
+				"73746174656d656e747320696e636c756465206f72616c2073746174656d656e" +
+				"747320696e20494554462073657373696f6e732c2061732077656c6c20617320" +
+				"7772697474656e20616e6420656c656374726f6e696320636f6d6d756e696361" +
+				"74696f6e73206d61646520617420616e792074696d65206f7220706c6163652c" +
+				"207768696368206172652061646472657373656420746f",
+			expectedTag: "f3477e7cd95417af89a6b8794c310cf0",
+		},
+		{
+			key: "1c9240a5eb55d38af333888604f6b5f0473917c1402b80099dca5cbc207075c0",
+			msg: "" +
+				"2754776173206272696c6c69672c20616e642074686520736c6974687920746f" +
+				"7665730a446964206779726520616e642067696d626c6520696e207468652077" +
+				"6162653a0a416c6c206d696d737920776572652074686520626f726f676f7665" +
+				"732c0a416e6420746865206d6f6d65207261746873206f757467726162652e",
+			expectedTag: "4541669a7eaaee61e708dc7cbcc5eb62",
+		},
+		{
+			key:         "0200000000000000000000000000000000000000000000000000000000000000",
+			msg:         "ffffffffffffffffffffffffffffffff",
+			expectedTag: "03000000000000000000000000000000",
+		},
+		{
+			key:         "02000000000000000000000000000000ffffffffffffffffffffffffffffffff",
+			msg:         "02000000000000000000000000000000",
+			expectedTag: "03000000000000000000000000000000",
+		},
+		{
+			key: "0100000000000000000000000000000000000000000000000000000000000000",
+			msg: "" +
+				"fffffffffffffffffffffffffffffffff0ffffffffffffffffffffffffffffff" +
+				"11000000000000000000000000000000",
+			expectedTag: "05000000000000000000000000000000",
+		},
+		{
+			key: "0100000000000000000000000000000000000000000000000000000000000000",
+			msg: "" +
+				"fffffffffffffffffffffffffffffffffbfefefefefefefefefefefefefefefe" +
+				"01010101010101010101010101010101",
+			expectedTag: "00000000000000000000000000000000",
+		},
+		{
+			key:         "0200000000000000000000000000000000000000000000000000000000000000",
+			msg:         "fdffffffffffffffffffffffffffffff",
+			expectedTag: "faffffffffffffffffffffffffffffff",
+		},
+		{
+			key: "0100000000000000040000000000000000000000000000000000000000000000",
+			msg: "" +
+				"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+				"0000000000000000000000000000000001000000000000000000000000000000",
+			expectedTag: "14000000000000005500000000000000",
+		},
+		{
+			key: "0100000000000000040000000000000000000000000000000000000000000000",
+			msg: "" +
+				"e33594d7505e43b900000000000000003394d7505e4379cd0100000000000000" +
+				"00000000000000000000000000000000",
+			expectedTag: "13000000000000000000000000000000",
+		},
+	}
+
+	for i, tv := range testVectors {
+		key, _ := hex.DecodeString(tv.key)
+		msg, _ := hex.DecodeString(tv.msg)
+
+		tag, err := Sum(msg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := hex.EncodeToString(tag[:]); actual != tv.expectedTag {
+			t.Fatalf("test vector #%d: expected %s, found %s", i+1, tv.expectedTag, actual)
+		}
+	}
+}
+
+func TestSumKeySize(t *testing.T) {
+	if _, err := Sum([]byte("msg"), make([]byte, KEY_SIZE-1)); err != ErrPolyKeySize {
+		t.Fatalf("expected ErrPolyKeySize, found %v", err)
+	}
+}
+
+func TestSumNearPrime(t *testing.T) {
+	// With r = 1 and two full blocks the accumulator is
+	// m1 + m2 + 2^129 before the final reduction. Taking
+	// m1 = 2^128 - 1 places it right around p = 2^130 - 5.
+	m1 := strings.Repeat("ff", 16)
+
+	testVectors := []struct {
+		name        string
+		m2          string
+		s           string
+		expectedTag string
+	}{
+		{
+			name:        "p-1",
+			m2:          "fb" + strings.Repeat("ff", 15),
+			s:           strings.Repeat("00", 16),
+			expectedTag: "fa" + strings.Repeat("ff", 15),
+		},
+		{
+			name:        "p",
+			m2:          "fc" + strings.Repeat("ff", 15),
+			s:           strings.Repeat("00", 16),
+			expectedTag: strings.Repeat("00", 16),
+		},
+		{
+			name:        "p+1",
+			m2:          "fd" + strings.Repeat("ff", 15),
+			s:           strings.Repeat("00", 16),
+			expectedTag: "01" + strings.Repeat("00", 15),
+		},
+		{
+			name:        "p+3",
+			m2:          strings.Repeat("ff", 16),
+			s:           strings.Repeat("00", 16),
+			expectedTag: "03" + strings.Repeat("00", 15),
+		},
+		{
+			name:        "p-1 with carry from s",
+			m2:          "fb" + strings.Repeat("ff", 15),
+			s:           "07" + strings.Repeat("00", 15),
+			expectedTag: "01" + strings.Repeat("00", 15),
+		},
+		{
+			name:        "p with s = 2^128-1",
+			m2:          "fc" + strings.Repeat("ff", 15),
+			s:           strings.Repeat("ff", 16),
+			expectedTag: strings.Repeat("ff", 16),
+		},
+	}
+
+	for _, tv := range testVectors {
+		key, _ := hex.DecodeString("01" + strings.Repeat("00", 15) + tv.s)
+		msg, _ := hex.DecodeString(m1 + tv.m2)
+
+		tag, err := Sum(msg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := hex.EncodeToString(tag[:]); actual != tv.expectedTag {
+			t.Fatalf("%s: tag mismatch: expected %s, found %s", tv.name, tv.expectedTag, actual)
+		}
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	// The final reduction on its own, for accumulator values
+	// on both sides of p and above 2^130.
+	testVectors := []struct {
+		h           [3]uint64
+		expectedTag [2]uint64
+	}{
+		{h: [3]uint64{0, 0, 0}, expectedTag: [2]uint64{0, 0}},
+		{h: [3]uint64{p0 - 1, p1, p2}, expectedTag: [2]uint64{p0 - 1, p1}},
+		{h: [3]uint64{p0, p1, p2}, expectedTag: [2]uint64{0, 0}},
+		{h: [3]uint64{p0 + 1, p1, p2}, expectedTag: [2]uint64{1, 0}},
+		{h: [3]uint64{^uint64(0), ^uint64(0), 3}, expectedTag: [2]uint64{4, 0}},
+		{h: [3]uint64{^uint64(0), ^uint64(0), 4}, expectedTag: [2]uint64{4, 0}},
+		{h: [3]uint64{0, 0, 4}, expectedTag: [2]uint64{5, 0}},
+	}
+
+	for _, tv := range testVectors {
+		st := macState{h: tv.h}
+
+		var tag [TAG_SIZE]byte
+		st.finalize(&tag)
+
+		actual := [2]uint64{
+			binary.LittleEndian.Uint64(tag[0:8]),
+			binary.LittleEndian.Uint64(tag[8:16]),
+		}
+
+		if actual != tv.expectedTag {
+			t.Fatalf("h = %x: expected %x, found %x", tv.h, tv.expectedTag, actual)
+		}
+	}
+}
+
+// ReferenceSum is the RFC 8439 pseudo-code on math/big,
+// used to cross-check the limb arithmetic.
+func referenceSum(msg, key []byte) [TAG_SIZE]byte {
+	leNum := func(b []byte) *big.Int {
+		be := make([]byte, len(b))
+		for i := range b {
+			be[len(b)-1-i] = b[i]
+		}
+		return new(big.Int).SetBytes(be)
+	}
+
+	var rBytes [R_SIZE]byte
+	copy(rBytes[:], key[:R_SIZE])
+	for _, i := range []int{3, 7, 11, 15} {
+		rBytes[i] &= 15
+	}
+	for _, i := range []int{4, 8, 12} {
+		rBytes[i] &= 252
+	}
+
+	p, _ := new(big.Int).SetString("3fffffffffffffffffffffffffffffffb", 16)
+	r := leNum(rBytes[:])
+	acc := new(big.Int)
+
+	for i := 0; i < len(msg); i += BLOCK_SIZE {
+		end := i + BLOCK_SIZE
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		block := append(append([]byte{}, msg[i:end]...), 0x01)
+		acc.Add(acc, leNum(block))
+		acc.Mul(acc, r)
+		acc.Mod(acc, p)
+	}
+
+	acc.Add(acc, leNum(key[R_SIZE:]))
+
+	var tag [TAG_SIZE]byte
+	accBytes := acc.Bytes()
+	for i := 0; i < TAG_SIZE && i < len(accBytes); i++ {
+		tag[i] = accBytes[len(accBytes)-1-i]
+	}
+
+	return tag
+}
+
+func TestSumReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Keys and messages made of 0xff bytes keep the
+	// accumulator close to its maximum on every block.
+	ones := bytes.Repeat([]byte{0xff}, 1024)
+
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, KEY_SIZE)
+		msg := make([]byte, rng.Intn(len(ones)))
+
+		if i%2 == 0 {
+			rng.Read(key)
+			rng.Read(msg)
+		} else {
+			copy(key, ones)
+			copy(msg, ones)
+		}
+
+		tag, err := Sum(msg, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := referenceSum(msg, key); tag != expected {
+			t.Fatalf("tag mismatch for %d-byte message: expected %x, found %x", len(msg), expected, tag)
+		}
+	}
+}
+
+func BenchmarkSum(b *testing.B) {
+	key := make([]byte, KEY_SIZE)
+	key[0] = 0x01
+
+	for _, size := range []int{64, 1 << 10, 64 << 10, 1 << 20} {
+		msg := make([]byte, size)
+
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := Sum(msg, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
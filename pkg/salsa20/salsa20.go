@@ -0,0 +1,272 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package salsa20 implements the Salsa20 stream cipher with 20, 12
+// and 8 rounds, and XSalsa20 with its HSalsa20 key derivation.
+//
+// Salsa20 is the predecessor of ChaCha and shares its ARX design.
+// It's provided to decrypt legacy data (NaCl secret boxes, eSTREAM
+// era formats), new data should be encrypted with ChaCha20.
+//
+// https://cr.yp.to/snuffle/spec.pdf
+// https://cr.yp.to/snuffle/xsalsa-20081128.pdf
+package salsa20
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/wedkarz02/chacha20/pkg/arx"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+const (
+	// Size of the key in bytes.
+	KEY_SIZE = 32
+
+	// Size of the nonce in bytes.
+	NONCE_SIZE = 8
+
+	// Size of the extended XSalsa20 nonce in bytes.
+	XNONCE_SIZE = 24
+
+	// Size of the HSalsa20 nonce input in bytes.
+	HNONCE_SIZE = 16
+
+	// Size of one key stream block in bytes.
+	BLOCK_SIZE = 64
+
+	// Number of rounds of Salsa20/20.
+	ROUNDS_20 = 20
+
+	// Number of rounds of Salsa20/12.
+	ROUNDS_12 = 12
+
+	// Number of rounds of Salsa20/8.
+	ROUNDS_8 = 8
+)
+
+const (
+	// Salsa20 constant: "expa" in little endian.
+	CONSTANT_0 = uint32(0x61707865)
+
+	// Salsa20 constant: "nd 3" in little endian.
+	CONSTANT_1 = uint32(0x3320646e)
+
+	// Salsa20 constant: "2-by" in little endian.
+	CONSTANT_2 = uint32(0x79622d32)
+
+	// Salsa20 constant: "te k" in little endian.
+	CONSTANT_3 = uint32(0x6b206574)
+)
+
+var (
+	// Error returned if the key is not KEY_SIZE bytes long.
+	ErrKeySize = errors.New("invalid salsa20 key size")
+
+	// Error returned if the nonce has the wrong size.
+	ErrNonceSize = errors.New("invalid salsa20 nonce size")
+
+	// Error returned for a number of rounds other than 20, 12 or 8.
+	ErrRounds = errors.New("unsupported number of salsa20 rounds")
+)
+
+// Cipher is a Salsa20 key stream. It implements cipher.Stream,
+// so data can be processed in pieces of any size.
+type Cipher struct {
+	key    [KEY_SIZE]byte
+	nonce  [NONCE_SIZE]byte
+	ctr    uint64
+	rounds int
+
+	// Unused part of the current key stream block.
+	keyStream [BLOCK_SIZE]byte
+	offset    int
+}
+
+// New returns a Salsa20/20 cipher with the 32-byte key
+// and the 8-byte nonce. The counter starts at 0.
+func New(key, nonce []byte) (*Cipher, error) {
+	return NewRounds(key, nonce, ROUNDS_20)
+}
+
+// NewRounds returns a Salsa20 cipher with the given number of rounds,
+// which has to be ROUNDS_20, ROUNDS_12 or ROUNDS_8.
+func NewRounds(key, nonce []byte, rounds int) (*Cipher, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	if len(nonce) != NONCE_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	if rounds != ROUNDS_20 && rounds != ROUNDS_12 && rounds != ROUNDS_8 {
+		return nil, ErrRounds
+	}
+
+	c := Cipher{rounds: rounds, offset: BLOCK_SIZE}
+	copy(c.key[:], key)
+	copy(c.nonce[:], nonce)
+
+	return &c, nil
+}
+
+// NewX returns an XSalsa20 cipher with the 32-byte key and
+// the 24-byte nonce. The HSalsa20 subkey is derived from the
+// first 16 bytes of the nonce and the rest is used as the
+// Salsa20/20 nonce.
+func NewX(key, nonce []byte) (*Cipher, error) {
+	if len(nonce) != XNONCE_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	subKey, err := HSalsa20(key, nonce[:HNONCE_SIZE])
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(subKey)
+
+	return New(subKey, nonce[HNONCE_SIZE:])
+}
+
+// HSalsa20 derives a 256-bit subkey from the key and the 16-byte nonce.
+// The diagonal and the nonce words are returned without
+// adding the initial state.
+func HSalsa20(key, nonce []byte) ([]byte, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	if len(nonce) != HNONCE_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	s := initState(key, nonce)
+	rounds(&s, ROUNDS_20)
+
+	subKey := make([]byte, KEY_SIZE)
+	for i, word := range [8]uint32{s[0], s[5], s[10], s[15], s[6], s[7], s[8], s[9]} {
+		binary.LittleEndian.PutUint32(subKey[i*4:(i+1)*4], word)
+	}
+
+	return subKey, nil
+}
+
+// SetCounter sets the block counter of the next key stream block
+// and discards the rest of the current one.
+func (c *Cipher) SetCounter(ctr uint64) {
+	c.ctr = ctr
+	c.offset = BLOCK_SIZE
+	util.Wipe(c.keyStream[:])
+}
+
+// XORKeyStream XORs each byte of src with the key stream and
+// writes the result to dst, which may overlap src entirely.
+// The key stream continues where the previous call ended.
+//
+// It panics if dst is shorter than src, like cipher.Stream.
+func (c *Cipher) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("salsa20: output smaller than input")
+	}
+
+	for i := range src {
+		if c.offset == BLOCK_SIZE {
+			c.block()
+		}
+
+		dst[i] = src[i] ^ c.keyStream[c.offset]
+		c.offset++
+	}
+}
+
+// Clear sets the key, the nonce and the buffered key stream to 0x00.
+func (c *Cipher) Clear() {
+	util.Wipe(c.key[:])
+	util.Wipe(c.nonce[:])
+	util.Wipe(c.keyStream[:])
+	c.ctr = 0
+	c.offset = BLOCK_SIZE
+}
+
+// Block computes the next key stream block and increments the counter.
+func (c *Cipher) block() {
+	var input [HNONCE_SIZE]byte
+	copy(input[:NONCE_SIZE], c.nonce[:])
+	binary.LittleEndian.PutUint64(input[NONCE_SIZE:], c.ctr)
+
+	s := initState(c.key[:], input[:])
+	initialState := s
+
+	rounds(&s, c.rounds)
+
+	// Adding the initial state using mod 2^32 addition.
+	for i, word := range initialState {
+		binary.LittleEndian.PutUint32(c.keyStream[i*4:(i+1)*4], s[i]+word)
+	}
+
+	c.ctr++
+	c.offset = 0
+}
+
+// InitState sets up the Salsa20 state. The constants are the same
+// as in ChaCha, but spread over the diagonal, with the key split around
+// them and the 128-bit input (nonce and counter, or the HSalsa20 nonce)
+// in the middle:
+//
+// c K K K
+// K c I I
+// I I c K
+// K K K c
+func initState(key, input []byte) [arx.STATE_SIZE]uint32 {
+	var s [arx.STATE_SIZE]uint32
+
+	s[0] = CONSTANT_0
+	s[5] = CONSTANT_1
+	s[10] = CONSTANT_2
+	s[15] = CONSTANT_3
+
+	for i := 0; i < 4; i++ {
+		s[i+1] = binary.LittleEndian.Uint32(key[i*4 : (i+1)*4])
+		s[i+11] = binary.LittleEndian.Uint32(key[(i+4)*4 : (i+5)*4])
+		s[i+6] = binary.LittleEndian.Uint32(input[i*4 : (i+1)*4])
+	}
+
+	return s
+}
+
+// Rounds performs the given number of alternating
+// column rounds and row rounds on the state.
+func rounds(s *[arx.STATE_SIZE]uint32, n int) {
+	for i := 0; i < n/2; i++ {
+		// Column round
+		arx.SalsaQuarterRound(s, 0, 4, 8, 12)
+		arx.SalsaQuarterRound(s, 5, 9, 13, 1)
+		arx.SalsaQuarterRound(s, 10, 14, 2, 6)
+		arx.SalsaQuarterRound(s, 15, 3, 7, 11)
+
+		// Row round
+		arx.SalsaQuarterRound(s, 0, 1, 2, 3)
+		arx.SalsaQuarterRound(s, 5, 6, 7, 4)
+		arx.SalsaQuarterRound(s, 10, 11, 8, 9)
+		arx.SalsaQuarterRound(s, 15, 12, 13, 14)
+	}
+}
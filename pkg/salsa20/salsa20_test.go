@@ -0,0 +1,228 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package salsa20
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"math/rand"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+	xsalsa20 "golang.org/x/crypto/salsa20"
+)
+
+var _ cipher.Stream = (*Cipher)(nil)
+
+// Set 6 of the eSTREAM Salsa20 test vectors with 256-bit keys.
+// The digest is the XOR of all 64-byte blocks of the first
+// 131072 bytes of the key stream.
+//
+// https://www.ecrypt.eu.org/stream/svn/viewcvs.cgi/ecrypt/trunk/submissions/salsa20/full/verified.test-vectors
+var estreamSet6 = []struct {
+	key string
+	iv  string
+}{
+	{"0053A6F94C9FF24598EB3E91E4378ADD3083D6297CCF2275C81B6EC11467BA0D", "0D74DB42A91077DE"},
+	{"0558ABFE51A4F74A9DF04396E93C8FE23588DB2E81D4277ACD2073C6196CBF12", "167DE44BB21980E7"},
+	{"0A5DB00356A9FC4FA2F5489BEE4194E73A8DE03386D92C7FD22578CB1E71C417", "1F86ED54BB2289F0"},
+	{"0F62B5085BAE0154A7FA4DA0F34699EC3F92E5388BDE3184D72A7DD02376C91C", "288FF65DC42B92F9"},
+}
+
+// Digests of the set 6 key streams for each number of rounds.
+// The Salsa20/20 digests come from the eSTREAM vectors, the reduced
+// round ones were computed for the same keys and nonces with libsodium
+// 1.0.18 (crypto_stream_salsa2012 and crypto_stream_salsa208).
+var estreamDigests = map[int][]string{
+	ROUNDS_20: {
+		"C349B6A51A3EC9B712EAED3F90D8BCEE69B7628645F251A996F55260C62EF31FD6C6B0AEA94E136C9D984AD2DF3578F78E457527B03A0450580DD874F63B1AB9",
+		"C3EAAF32836BACE32D04E1124231EF47E101367D6305413A0EEB07C60698A2876E4D031870A739D6FFDDD208597AFF0A47AC17EDB0167DD67EBA84F1883D4DFD",
+		"3CD23C3DC90201ACC0CF49B440B6C417F0DC8D8410A716D5314C059E14B1A8D9A9FB8EA3D9C8DAE12B21402F674AA95C67B1FC514E994C9D3F3A6E41DFF5BBA6",
+		"E00EBCCD70D69152725F9987982178A2E2E139C7BCBE04CA8A0E99E318D9AB76F988C8549F75ADD790BA4F81C176DA653C1A043F11A958E169B6D2319F4EEC1A",
+	},
+	ROUNDS_12: {
+		"7E288D6B1C8C521CB4A535CC446E092DB2EFFA99321A5C6E0C52DD15F383DB4C4EB394C3BBA4ABA7892E96DF4A02B324ABBB78AD72963BBFBF395A941D6E96FD",
+		"986A4F031423FB9FB0F03FF3EB0C992F6A97B8E5545C6E441C35CAA01D5CBE1C5854B8C2D327ACACC1B70603D7917B5DA7F824B0CFE21CA857B438B940BD67EF",
+		"1E26089DB2177310078B99B0AD543FD480F3704035BF62F70E6BAE530FAA011A18FA40A6BE989938EBEC6DD26BA501E405F09A9D77E98D292A299EABF7A5684B",
+		"08900BE1E024034E5884974485A84E21FC771F4E2F283292AF5CA780CEBE474518F9DCC5B45C10F3446B5AC5D55CE1D9D75AA2BAA93144FC634F200C6023EE29",
+	},
+	ROUNDS_8: {
+		"70174FB4365E24E02D83F99287BA8E5DDF1E4B9463880C702C084E5624EFA8E317D384DF0EA64E5A0D038237E0D5857BA140D0FD20946D0DBEA04DD83F12BE2D",
+		"B7FB27DD93BAD8298AB78590E1285047F457923FF11FBB9CBD3AE57CDD5FA3B8F0A3CD8D9F443EB12AC2FC75789B4ECDC1C5D5F46136401DA5922E35B98B2CA2",
+		"7E014E5D31459EF92EC398F92AD75FE528AEE41CDB206DF39D453A5A37D43604BF1F54121FBF222C21579A00B7E0F776DB481F31A445592F918CE5665C662A5D",
+		"3024FF4FF97C1C1FC9BEA85B46BB2441B13EB173BBC874698DC796C12A6AB65400E233A5F9629E42E22AD8D830B8CD7A3B537D103872C0F2280551B9486070E0",
+	},
+}
+
+func TestESTREAM(t *testing.T) {
+	const streamSize = 131072
+
+	for _, rounds := range []int{ROUNDS_20, ROUNDS_12, ROUNDS_8} {
+		for i, v := range estreamSet6 {
+			c, err := NewRounds(testutil.DecodeHex(t, v.key), testutil.DecodeHex(t, v.iv), rounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyStream := make([]byte, streamSize)
+			c.XORKeyStream(keyStream, keyStream)
+
+			var digest [BLOCK_SIZE]byte
+			for j, b := range keyStream {
+				digest[j%BLOCK_SIZE] ^= b
+			}
+
+			expected := testutil.DecodeHex(t, estreamDigests[rounds][i])
+			if !bytes.Equal(digest[:], expected) {
+				t.Fatalf("Salsa20/%d, vector %d: expected %X, found %X", rounds, i, expected, digest)
+			}
+		}
+	}
+}
+
+func TestHSalsa20(t *testing.T) {
+	// https://cr.yp.to/highspeed/naclcrypto-20090310.pdf (section 8)
+	key := testutil.DecodeHex(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+	expected := testutil.DecodeHex(t, "1b27556473e985d462cd51197a9a46c76009549eac6474f206c4ee0844f68389")
+
+	subKey, err := HSalsa20(key, make([]byte, HNONCE_SIZE))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(subKey, expected) {
+		t.Fatalf("subkey mismatch: expected %x, found %x", expected, subKey)
+	}
+}
+
+func TestXSalsa20(t *testing.T) {
+	// https://cr.yp.to/highspeed/naclcrypto-20090310.pdf (section 9)
+	key := testutil.DecodeHex(t, "1b27556473e985d462cd51197a9a46c76009549eac6474f206c4ee0844f68389")
+	nonce := testutil.DecodeHex(t, "69696ee955b62b73cd62bda875fc73d68219e0036b7a0b37")
+	expected := testutil.DecodeHex(t, "eea6a7251c1e72916d11c2cb214d3c252539121d8e234e652d651fa4c8cff880")
+
+	c, err := NewX(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyStream := make([]byte, len(expected))
+	c.XORKeyStream(keyStream, keyStream)
+
+	if !bytes.Equal(keyStream, expected) {
+		t.Fatalf("key stream mismatch: expected %x, found %x", expected, keyStream)
+	}
+}
+
+func TestReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 100; i++ {
+		var key [KEY_SIZE]byte
+		rng.Read(key[:])
+
+		// The reference picks XSalsa20 for 24-byte nonces.
+		nonce := make([]byte, NONCE_SIZE)
+		newCipher := New
+		if i%2 == 1 {
+			nonce = make([]byte, XNONCE_SIZE)
+			newCipher = NewX
+		}
+		rng.Read(nonce)
+
+		msg := make([]byte, rng.Intn(4*BLOCK_SIZE))
+		rng.Read(msg)
+
+		expected := make([]byte, len(msg))
+		xsalsa20.XORKeyStream(expected, msg, nonce, &key)
+
+		c, err := newCipher(key[:], nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Random pieces continue the same key stream.
+		actual := make([]byte, len(msg))
+		for off := 0; off < len(msg); {
+			n := rng.Intn(len(msg)-off) + 1
+			c.XORKeyStream(actual[off:off+n], msg[off:off+n])
+			off += n
+		}
+
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("case %d: expected %x, found %x", i, expected, actual)
+		}
+	}
+}
+
+func TestSetCounter(t *testing.T) {
+	key := testutil.DecodeHex(t, estreamSet6[0].key)
+	nonce := testutil.DecodeHex(t, estreamSet6[0].iv)
+
+	c, err := New(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyStream := make([]byte, 3*BLOCK_SIZE)
+	c.XORKeyStream(keyStream, keyStream)
+
+	// Drop the rest of a partially used block.
+	c.XORKeyStream(make([]byte, 5), make([]byte, 5))
+	c.SetCounter(1)
+
+	tail := make([]byte, 2*BLOCK_SIZE)
+	c.XORKeyStream(tail, tail)
+
+	if !bytes.Equal(tail, keyStream[BLOCK_SIZE:]) {
+		t.Fatal("key stream mismatch after SetCounter")
+	}
+
+	c.Clear()
+
+	if c.key != [KEY_SIZE]byte{} || c.keyStream != [BLOCK_SIZE]byte{} {
+		t.Fatal("key material left after Clear")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	key := make([]byte, KEY_SIZE)
+
+	if _, err := New(key[1:], make([]byte, NONCE_SIZE)); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+
+	if _, err := New(key, make([]byte, XNONCE_SIZE)); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+
+	if _, err := NewX(key, make([]byte, NONCE_SIZE)); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+
+	if _, err := NewRounds(key, make([]byte, NONCE_SIZE), 10); err != ErrRounds {
+		t.Fatalf("expected ErrRounds, found %v", err)
+	}
+
+	if _, err := HSalsa20(key, make([]byte, NONCE_SIZE)); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package tls13 implements TLS 1.3 record protection for the
+// TLS_CHACHA20_POLY1305_SHA256 cipher suite with ChaCha20-Poly1305
+// from the chacha20 package and HKDF-SHA256.
+//
+// Only the record layer is covered: the traffic secrets come from
+// a TLS 1.3 key schedule (or a key log) and each direction of the
+// connection uses its own RecordCipher.
+//
+// It was coded referencing RFC 8446:
+//
+// https://datatracker.ietf.org/doc/html/rfc8446#section-5
+package tls13
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/pkg/hkdf"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+const (
+	// Size of the traffic key in bytes.
+	KEY_SIZE = chacha20.KEY_SIZE
+
+	// Size of the per-record nonce IV in bytes.
+	IV_SIZE = chacha20.NONCE_SIZE
+
+	// Size of the record header: type (1), legacy version (2), length (2).
+	RECORD_HEADER_SIZE = 5
+
+	// Largest content of a single record.
+	MAX_PLAINTEXT_SIZE = 1 << 14
+
+	// Largest protected record body, including the tag.
+	MAX_CIPHERTEXT_SIZE = MAX_PLAINTEXT_SIZE + 256
+
+	// Content types (RFC 8446 section 5.1).
+	CONTENT_CHANGE_CIPHER_SPEC = byte(20)
+	CONTENT_ALERT              = byte(21)
+	CONTENT_HANDSHAKE          = byte(22)
+	CONTENT_APPLICATION_DATA   = byte(23)
+
+	// Protected records always claim to be TLS 1.2 application data.
+	legacyRecordVersion = 0x0303
+
+	labelPrefix = "tls13 "
+)
+
+var (
+	// Error returned if a record or its content is too long or truncated.
+	ErrRecordSize = errors.New("invalid tls record size")
+
+	// Error returned if the outer content type isn't application data.
+	ErrRecordType = errors.New("unexpected tls record type")
+
+	// Error returned if the inner plaintext has no content type.
+	ErrContentType = errors.New("missing tls inner content type")
+
+	// Error returned if the padding size is invalid.
+	ErrPadding = errors.New("invalid tls record padding")
+
+	// Error returned if the label or the context can't be encoded.
+	ErrLabel = errors.New("invalid hkdf label")
+
+	// Error returned once all 2^64 sequence numbers are used.
+	ErrSequenceExhausted = errors.New("tls record sequence number exhausted")
+)
+
+// HKDFExpandLabel derives length bytes from the secret with the label
+// prefixed by "tls13 " and the context:
+//
+//	struct {
+//	    uint16 length = Length;
+//	    opaque label<7..255> = "tls13 " + Label;
+//	    opaque context<0..255> = Context;
+//	} HkdfLabel;
+//
+// https://datatracker.ietf.org/doc/html/rfc8446#section-7.1
+func HKDFExpandLabel(secret []byte, label string, context []byte, length int) ([]byte, error) {
+	if len(labelPrefix)+len(label) > 255 || len(context) > 255 || length < 0 || length > math.MaxUint16 {
+		return nil, ErrLabel
+	}
+
+	info := make([]byte, 0, 2+1+len(labelPrefix)+len(label)+1+len(context))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(labelPrefix)+len(label)))
+	info = append(info, labelPrefix...)
+	info = append(info, label...)
+	info = append(info, byte(len(context)))
+	info = append(info, context...)
+
+	return hkdf.Expand(secret, info, length)
+}
+
+// TrafficKeys derives the write key and IV from a traffic secret.
+//
+// https://datatracker.ietf.org/doc/html/rfc8446#section-7.3
+func TrafficKeys(secret []byte) ([]byte, []byte, error) {
+	key, err := HKDFExpandLabel(secret, "key", nil, KEY_SIZE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iv, err := HKDFExpandLabel(secret, "iv", nil, IV_SIZE)
+	if err != nil {
+		util.Wipe(key)
+		return nil, nil, err
+	}
+
+	return key, iv, nil
+}
+
+// RecordCipher protects the records sent in one direction
+// with the keys of one traffic secret. The sequence number
+// starts at 0 and is incremented with every record.
+// It is not safe for concurrent use.
+type RecordCipher struct {
+	cipher *chacha20.Cipher
+	iv     [IV_SIZE]byte
+	seq    uint64
+	done   bool
+}
+
+// NewRecordCipher derives the key and the IV from the
+// traffic secret (e.g. client_application_traffic_secret_0).
+func NewRecordCipher(trafficSecret []byte) (*RecordCipher, error) {
+	key, iv, err := TrafficKeys(trafficSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &chacha20.Key{Bytes: key}
+	defer k.Clear()
+
+	c, err := k.NewCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := RecordCipher{cipher: c}
+	copy(rc.iv[:], iv)
+
+	return &rc, nil
+}
+
+// Sequence returns the sequence number of the next record.
+func (rc *RecordCipher) Sequence() uint64 {
+	return rc.seq
+}
+
+// Seal protects the content as a record of the given content type
+// followed by padding zero bytes, which hide the content length.
+// The returned record starts with its header.
+func (rc *RecordCipher) Seal(contentType byte, content []byte, padding int) ([]byte, error) {
+	if padding < 0 {
+		return nil, ErrPadding
+	}
+
+	if len(content)+padding > MAX_PLAINTEXT_SIZE {
+		return nil, ErrRecordSize
+	}
+
+	if rc.done {
+		return nil, ErrSequenceExhausted
+	}
+
+	// TLSInnerPlaintext: content | type | zeros.
+	inner := make([]byte, len(content)+1+padding)
+	copy(inner, content)
+	inner[len(content)] = contentType
+
+	header := recordHeader(len(inner) + chacha20.TAG_SIZE)
+
+	sealed, err := rc.cipher.Seal(rc.nonce(), inner, header)
+	util.Wipe(inner)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.next()
+
+	return append(header, sealed...), nil
+}
+
+// Open verifies and decrypts the record and returns its inner
+// content type and content with the padding removed.
+//
+// ErrAuthFailed error from the chacha20 package is returned if the
+// record was modified or isn't the next one. The sequence number
+// only advances for successfully opened records.
+func (rc *RecordCipher) Open(record []byte) (byte, []byte, error) {
+	if len(record) < RECORD_HEADER_SIZE {
+		return 0, nil, ErrRecordSize
+	}
+
+	// The legacy version must be ignored.
+	if record[0] != CONTENT_APPLICATION_DATA {
+		return 0, nil, ErrRecordType
+	}
+
+	length := int(binary.BigEndian.Uint16(record[3:RECORD_HEADER_SIZE]))
+	if length != len(record)-RECORD_HEADER_SIZE || length > MAX_CIPHERTEXT_SIZE {
+		return 0, nil, ErrRecordSize
+	}
+
+	if rc.done {
+		return 0, nil, ErrSequenceExhausted
+	}
+
+	inner, err := rc.cipher.Open(rc.nonce(), record[RECORD_HEADER_SIZE:], record[:RECORD_HEADER_SIZE])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rc.next()
+
+	if len(inner) > MAX_PLAINTEXT_SIZE+1 {
+		return 0, nil, ErrRecordSize
+	}
+
+	// The content type is the last non-zero byte.
+	i := len(inner) - 1
+	for i >= 0 && inner[i] == 0 {
+		i--
+	}
+
+	if i < 0 {
+		return 0, nil, ErrContentType
+	}
+
+	return inner[i], inner[:i], nil
+}
+
+// Clear wipes the key and the IV.
+func (rc *RecordCipher) Clear() {
+	rc.cipher.ClearKey()
+	util.Wipe(rc.iv[:])
+}
+
+// Nonce is the IV XORed with the sequence number
+// left-padded to IV_SIZE bytes.
+//
+// https://datatracker.ietf.org/doc/html/rfc8446#section-5.3
+func (rc *RecordCipher) nonce() []byte {
+	nonce := make([]byte, IV_SIZE)
+	binary.BigEndian.PutUint64(nonce[IV_SIZE-8:], rc.seq)
+
+	for i := range nonce {
+		nonce[i] ^= rc.iv[i]
+	}
+
+	return nonce
+}
+
+// Next advances the sequence number, which must not wrap.
+func (rc *RecordCipher) next() {
+	if rc.seq == math.MaxUint64 {
+		rc.done = true
+		return
+	}
+
+	rc.seq++
+}
+
+// RecordHeader returns the header of a protected record
+// with the given body length, which is also the associated data.
+func recordHeader(length int) []byte {
+	header := make([]byte, RECORD_HEADER_SIZE)
+	header[0] = CONTENT_APPLICATION_DATA
+	binary.BigEndian.PutUint16(header[1:3], legacyRecordVersion)
+	binary.BigEndian.PutUint16(header[3:5], uint16(length))
+
+	return header
+}
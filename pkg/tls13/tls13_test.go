@@ -0,0 +1,327 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package tls13
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20"
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+func TestHKDFExpandLabel(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8448#section-3
+	// The trace uses TLS_AES_128_GCM_SHA256, so the keys are 16 bytes.
+	tests := []struct {
+		name   string
+		secret string
+		key    string
+		iv     string
+	}{
+		{
+			"server handshake",
+			"b67b7d690cc16c4e75e54213cb2d37b4e9c912bcded9105d42befd59d391ad38",
+			"3fce516009c21727d0f2e4e86ee403bc",
+			"5d313eb2671276ee13000b30",
+		},
+		{
+			"client handshake",
+			"b3eddb126e067f35a780b3abf45e2d8f3b1a950738f52e9600746a0e27a55a21",
+			"dbfaa693d1762c5b666af5d950258d01",
+			"5bd3c71b836e0b76bb73265f",
+		},
+		{
+			"server application",
+			"a11af9f05531f856ad47116b45a950328204b4f44bfb6b3a4b4f1f3fcb631643",
+			"9f02283b6c9c07efc26bb9f2ac92e356",
+			"cf782b88dd83549aadf1e984",
+		},
+	}
+
+	for _, tc := range tests {
+		secret := testutil.DecodeHex(t, tc.secret)
+		expectedKey := testutil.DecodeHex(t, tc.key)
+		expectedIV := testutil.DecodeHex(t, tc.iv)
+
+		key, err := HKDFExpandLabel(secret, "key", nil, len(expectedKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iv, err := HKDFExpandLabel(secret, "iv", nil, len(expectedIV))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(key, expectedKey) || !bytes.Equal(iv, expectedIV) {
+			t.Fatalf("%s: expected key %x and iv %x, found %x and %x", tc.name, expectedKey, expectedIV, key, iv)
+		}
+	}
+
+	if _, err := HKDFExpandLabel(nil, string(make([]byte, 250)), nil, KEY_SIZE); err != ErrLabel {
+		t.Fatalf("expected ErrLabel for long label, found %v", err)
+	}
+
+	if _, err := HKDFExpandLabel(nil, "key", make([]byte, 256), KEY_SIZE); err != ErrLabel {
+		t.Fatalf("expected ErrLabel for long context, found %v", err)
+	}
+}
+
+// Protected records of a TLS_CHACHA20_POLY1305_SHA256 session
+// with the traffic secrets from the key log.
+type sessionFile struct {
+	Source         string `json:"source"`
+	TrafficSecrets []struct {
+		Name    string `json:"name"`
+		Secret  string `json:"secret"`
+		Key     string `json:"key"`
+		IV      string `json:"iv"`
+		Records []struct {
+			Seq         uint64 `json:"seq"`
+			Record      string `json:"record"`
+			ContentType byte   `json:"contentType"`
+			Content     string `json:"content"`
+			PaddingSize int    `json:"paddingSize"`
+		} `json:"records"`
+	} `json:"trafficSecrets"`
+}
+
+func TestSession(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "tls13", "chacha20_poly1305_session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f sessionFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.TrafficSecrets) == 0 {
+		t.Fatal("no traffic secrets found")
+	}
+
+	for _, ts := range f.TrafficSecrets {
+		secret := testutil.DecodeHex(t, ts.Secret)
+
+		key, iv, err := TrafficKeys(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(key, testutil.DecodeHex(t, ts.Key)) || !bytes.Equal(iv, testutil.DecodeHex(t, ts.IV)) {
+			t.Fatalf("%s: traffic keys mismatch", ts.Name)
+		}
+
+		reader, err := NewRecordCipher(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writer, err := NewRecordCipher(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range ts.Records {
+			record := testutil.DecodeHex(t, r.Record)
+			expected := testutil.DecodeHex(t, r.Content)
+
+			if reader.Sequence() != r.Seq {
+				t.Fatalf("%s: expected sequence number %d, found %d", ts.Name, r.Seq, reader.Sequence())
+			}
+
+			contentType, content, err := reader.Open(record)
+			if err != nil {
+				t.Fatalf("%s, record %d: %v", ts.Name, r.Seq, err)
+			}
+
+			if contentType != r.ContentType || !bytes.Equal(content, expected) {
+				t.Fatalf("%s, record %d: expected type %d and %x, found type %d and %x", ts.Name, r.Seq, r.ContentType, expected, contentType, content)
+			}
+
+			sealed, err := writer.Seal(r.ContentType, expected, r.PaddingSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(sealed, record) {
+				t.Fatalf("%s, record %d: seal mismatch:\nexpected %x\nfound    %x", ts.Name, r.Seq, record, sealed)
+			}
+		}
+	}
+}
+
+func newRecordCiphers(t *testing.T) (*RecordCipher, *RecordCipher) {
+	t.Helper()
+
+	secret := bytes.Repeat([]byte{0x42}, 32)
+
+	writer, err := NewRecordCipher(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordCipher(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writer, reader
+}
+
+func TestRecordCipher(t *testing.T) {
+	writer, reader := newRecordCiphers(t)
+
+	content := []byte("Ladies and Gentlemen of the class of '99")
+
+	// Padding hides the content length and is removed by Open.
+	for _, padding := range []int{0, 1, 100, MAX_PLAINTEXT_SIZE - len(content)} {
+		record, err := writer.Seal(CONTENT_APPLICATION_DATA, content, padding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(record) != RECORD_HEADER_SIZE+len(content)+1+padding+chacha20.TAG_SIZE {
+			t.Fatalf("padding %d: unexpected record size %d", padding, len(record))
+		}
+
+		contentType, opened, err := reader.Open(record)
+		if err != nil {
+			t.Fatalf("padding %d: %v", padding, err)
+		}
+
+		if contentType != CONTENT_APPLICATION_DATA || !bytes.Equal(opened, content) {
+			t.Fatalf("padding %d: open mismatch", padding)
+		}
+	}
+
+	// Empty content is valid for alerts and application data.
+	record, err := writer.Seal(CONTENT_ALERT, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType, opened, err := reader.Open(record); err != nil || contentType != CONTENT_ALERT || len(opened) != 0 {
+		t.Fatalf("empty record: expected alert, found type %d, %x, %v", contentType, opened, err)
+	}
+
+	if _, err := writer.Seal(CONTENT_APPLICATION_DATA, content, -1); err != ErrPadding {
+		t.Fatalf("expected ErrPadding, found %v", err)
+	}
+
+	if _, err := writer.Seal(CONTENT_APPLICATION_DATA, make([]byte, MAX_PLAINTEXT_SIZE), 1); err != ErrRecordSize {
+		t.Fatalf("expected ErrRecordSize, found %v", err)
+	}
+}
+
+func TestRecordCipherErrors(t *testing.T) {
+	writer, reader := newRecordCiphers(t)
+
+	first, err := writer.Seal(CONTENT_APPLICATION_DATA, []byte("first"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := writer.Seal(CONTENT_APPLICATION_DATA, []byte("second"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Modified body or header, with the header length left intact.
+	for i := range first {
+		if i == 0 || i == 3 || i == 4 {
+			continue
+		}
+
+		modified := append([]byte(nil), first...)
+		modified[i] ^= 0x01
+
+		if _, _, err := reader.Open(modified); err != chacha20.ErrAuthFailed {
+			t.Fatalf("byte %d: expected ErrAuthFailed, found %v", i, err)
+		}
+	}
+
+	// Reordered records use the wrong sequence number.
+	if _, _, err := reader.Open(second); err != chacha20.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for reordered record, found %v", err)
+	}
+
+	if _, _, err := reader.Open(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := reader.Open(first); err != chacha20.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for replayed record, found %v", err)
+	}
+
+	wrongType := append([]byte{CONTENT_HANDSHAKE}, second[1:]...)
+	if _, _, err := reader.Open(wrongType); err != ErrRecordType {
+		t.Fatalf("expected ErrRecordType, found %v", err)
+	}
+
+	if _, _, err := reader.Open(second[:len(second)-1]); err != ErrRecordSize {
+		t.Fatalf("expected ErrRecordSize for truncated record, found %v", err)
+	}
+
+	if _, _, err := reader.Open(second[:RECORD_HEADER_SIZE-1]); err != ErrRecordSize {
+		t.Fatalf("expected ErrRecordSize for short record, found %v", err)
+	}
+
+	if _, _, err := reader.Open(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// An inner plaintext of only zeros has no content type.
+	inner := make([]byte, 8)
+	header := recordHeader(len(inner) + chacha20.TAG_SIZE)
+
+	sealed, err := writer.cipher.Seal(writer.nonce(), inner, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := reader.Open(append(header, sealed...)); err != ErrContentType {
+		t.Fatalf("expected ErrContentType, found %v", err)
+	}
+}
+
+func TestRecordCipherSequence(t *testing.T) {
+	writer, _ := newRecordCiphers(t)
+
+	writer.seq = math.MaxUint64 - 1
+
+	for i := 0; i < 2; i++ {
+		if _, err := writer.Seal(CONTENT_APPLICATION_DATA, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The sequence number must not wrap.
+	if _, err := writer.Seal(CONTENT_APPLICATION_DATA, nil, 0); err != ErrSequenceExhausted {
+		t.Fatalf("expected ErrSequenceExhausted, found %v", err)
+	}
+}
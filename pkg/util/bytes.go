@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package util
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// RandomBytes returns n bytes read from rand.Reader.
+// Used for nonces longer than NONCE_SIZE, keys and salts.
+func RandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, ErrSeed
+	}
+
+	return b, nil
+}
+
+// Wipe sets all bytes of b to 0x00 to make
+// sure that they can't be retrieved from memory.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0x00
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wireguard
+
+const (
+	// Number of bits in the replay filter bitmap.
+	COUNTER_BITS_TOTAL = 8192
+
+	// Number of counters below the highest one accepted by the filter.
+	// One word of the bitmap is kept free, so moving the window
+	// forward never clears bits which are still inside it.
+	COUNTER_WINDOW_SIZE = COUNTER_BITS_TOTAL - wordBits
+
+	wordBits   = 64
+	wordShift  = 6
+	bitmapSize = COUNTER_BITS_TOTAL / wordBits
+)
+
+// ReplayFilter is the sliding window replay filter from the WireGuard
+// paper (based on RFC 6479). It accepts every counter once, in any
+// order, as long as it's within COUNTER_WINDOW_SIZE of the highest
+// counter seen so far. The zero value is ready to use.
+//
+// https://datatracker.ietf.org/doc/html/rfc6479
+type ReplayFilter struct {
+	// One more than the highest counter seen, 0 before the first one.
+	next   uint64
+	bitmap [bitmapSize]uint64
+}
+
+// Accept reports whether the counter wasn't seen before and is
+// inside the window, and marks it as seen. Only counters of
+// authenticated messages should be passed to it.
+func (f *ReplayFilter) Accept(counter uint64) bool {
+	if counter >= REJECT_AFTER_MESSAGES {
+		return false
+	}
+
+	// Shifted by one so that next == 0 means no counter was seen.
+	counter++
+
+	if counter+COUNTER_WINDOW_SIZE < f.next {
+		return false
+	}
+
+	index := counter >> wordShift
+
+	if counter > f.next {
+		// Clear the words the window moves over.
+		current := f.next >> wordShift
+		top := index - current
+		if top > bitmapSize {
+			top = bitmapSize
+		}
+
+		for i := uint64(1); i <= top; i++ {
+			f.bitmap[(current+i)&(bitmapSize-1)] = 0
+		}
+
+		f.next = counter
+	}
+
+	index &= bitmapSize - 1
+	bit := uint64(1) << (counter & (wordBits - 1))
+
+	if f.bitmap[index]&bit != 0 {
+		return false
+	}
+
+	f.bitmap[index] |= bit
+
+	return true
+}
+
+// Reset forgets all counters, e.g. after a new handshake.
+func (f *ReplayFilter) Reset() {
+	*f = ReplayFilter{}
+}
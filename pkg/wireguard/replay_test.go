@@ -0,0 +1,130 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wireguard
+
+import (
+	"testing"
+)
+
+func TestReplayFilter(t *testing.T) {
+	// The sequence of the WireGuard kernel module self-test
+	// (drivers/net/wireguard/selftest/counter.c).
+	const lim = COUNTER_WINDOW_SIZE + 1
+
+	tests := []struct {
+		counter  uint64
+		expected bool
+	}{
+		{0, true},
+		{1, true},
+		{1, false},
+		{9, true},
+		{8, true},
+		{7, true},
+		{7, false},
+		{lim, true},
+		{lim - 1, true},
+		{lim - 1, false},
+		{lim - 2, true},
+		{2, true},
+		{2, false},
+		{lim + 16, true},
+		{3, false},
+		{lim + 16, false},
+		{lim * 4, true},
+		{lim*4 - (lim - 1), true},
+		{10, false},
+		{lim*4 - lim, false},
+		{lim*4 - (lim + 1), false},
+		{lim*4 - (lim - 2), true},
+		{lim*4 + 1 - lim, false},
+		{0, false},
+		{REJECT_AFTER_MESSAGES, false},
+		{REJECT_AFTER_MESSAGES - 1, true},
+		{REJECT_AFTER_MESSAGES, false},
+		{REJECT_AFTER_MESSAGES - 1, false},
+		{REJECT_AFTER_MESSAGES - 2, true},
+		{REJECT_AFTER_MESSAGES + 1, false},
+		{REJECT_AFTER_MESSAGES + 2, false},
+		{REJECT_AFTER_MESSAGES - 2, false},
+		{REJECT_AFTER_MESSAGES - 3, true},
+		{0, false},
+	}
+
+	var f ReplayFilter
+	for i, tc := range tests {
+		if actual := f.Accept(tc.counter); actual != tc.expected {
+			t.Fatalf("step %d: counter %d: expected %v, found %v", i+1, tc.counter, tc.expected, actual)
+		}
+	}
+}
+
+func TestReplayFilterWindow(t *testing.T) {
+	check := func(f *ReplayFilter, counter uint64, expected bool) {
+		t.Helper()
+
+		if actual := f.Accept(counter); actual != expected {
+			t.Fatalf("counter %d: expected %v, found %v", counter, expected, actual)
+		}
+	}
+
+	// The whole window fits behind the highest counter.
+	var f ReplayFilter
+	for i := uint64(1); i <= COUNTER_WINDOW_SIZE; i++ {
+		check(&f, i, true)
+	}
+	check(&f, 0, true)
+	check(&f, 0, false)
+
+	// One more and the oldest counter falls out.
+	f.Reset()
+	for i := uint64(2); i <= COUNTER_WINDOW_SIZE+1; i++ {
+		check(&f, i, true)
+	}
+	check(&f, 1, true)
+	check(&f, 0, false)
+
+	// Counters arriving in reverse order.
+	f.Reset()
+	for i := uint64(COUNTER_WINDOW_SIZE + 1); i > 0; i-- {
+		check(&f, i-1, true)
+	}
+
+	f.Reset()
+	for i := uint64(COUNTER_WINDOW_SIZE + 2); i > 1; i-- {
+		check(&f, i-1, true)
+	}
+	check(&f, 0, false)
+
+	f.Reset()
+	for i := uint64(COUNTER_WINDOW_SIZE + 1); i > 1; i-- {
+		check(&f, i-1, true)
+	}
+	check(&f, COUNTER_WINDOW_SIZE+1, true)
+	check(&f, 0, false)
+
+	f.Reset()
+	for i := uint64(COUNTER_WINDOW_SIZE + 1); i > 1; i-- {
+		check(&f, i-1, true)
+	}
+	check(&f, 0, true)
+	check(&f, COUNTER_WINDOW_SIZE+1, true)
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package wireguard implements WireGuard transport data messages
+// with ChaCha20-Poly1305 from the chacha20 package:
+//
+//	type (1) = 4 | reserved (3) | receiver index (4) | counter (8) | encrypted packet | tag (16)
+//
+// The receiver index and the counter are little endian and the nonce
+// is 4 zero bytes followed by the little endian counter. The keys come
+// from the WireGuard handshake, which isn't part of this package.
+//
+// It was coded referencing the WireGuard whitepaper:
+//
+// https://www.wireguard.com/papers/wireguard.pdf
+package wireguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/wedkarz02/chacha20"
+)
+
+const (
+	// Type of transport data messages.
+	MESSAGE_TRANSPORT_TYPE = 4
+
+	// Size of the transport message header in bytes.
+	TRANSPORT_HEADER_SIZE = 16
+
+	// Size of the smallest transport message, a keepalive.
+	MIN_TRANSPORT_SIZE = TRANSPORT_HEADER_SIZE + chacha20.TAG_SIZE
+
+	// Packets are padded with zeros to a multiple of 16 bytes.
+	PADDING_MULTIPLE = 16
+
+	// Counters from this value on are rejected and the session has
+	// to be replaced by a new handshake (Reject-After-Messages).
+	REJECT_AFTER_MESSAGES = math.MaxUint64 - (1 << 13)
+)
+
+var (
+	// Error returned if the message isn't a transport data message.
+	ErrMessageType = errors.New("not a wireguard transport message")
+
+	// Error returned if the message is shorter than MIN_TRANSPORT_SIZE.
+	ErrMessageSize = errors.New("invalid wireguard message size")
+
+	// Error returned if the message is addressed to another receiver index.
+	ErrReceiver = errors.New("wireguard message for another receiver")
+
+	// Error returned for replayed counters or counters outside the window.
+	ErrReplay = errors.New("wireguard message replayed or too old")
+
+	// Error returned once REJECT_AFTER_MESSAGES messages were sent.
+	ErrCounterExhausted = errors.New("wireguard message counter exhausted")
+)
+
+// TransportHeader is the unencrypted part of a transport data message.
+type TransportHeader struct {
+	Receiver uint32
+	Counter  uint64
+}
+
+// ParseTransportHeader parses the header of a transport data message.
+// The receiver index selects the session whose key opens the message.
+func ParseTransportHeader(msg []byte) (TransportHeader, error) {
+	if len(msg) < MIN_TRANSPORT_SIZE {
+		return TransportHeader{}, ErrMessageSize
+	}
+
+	if msg[0] != MESSAGE_TRANSPORT_TYPE || msg[1] != 0 || msg[2] != 0 || msg[3] != 0 {
+		return TransportHeader{}, ErrMessageType
+	}
+
+	return TransportHeader{
+		Receiver: binary.LittleEndian.Uint32(msg[4:8]),
+		Counter:  binary.LittleEndian.Uint64(msg[8:16]),
+	}, nil
+}
+
+// Transport holds the transport keys of one WireGuard session.
+// It is not safe for concurrent use.
+type Transport struct {
+	// Index chosen by this side, expected in received messages.
+	LocalIndex uint32
+
+	// Index chosen by the peer, sent in every message.
+	RemoteIndex uint32
+
+	send        *chacha20.Cipher
+	recv        *chacha20.Cipher
+	sendCounter uint64
+	filter      ReplayFilter
+}
+
+// NewTransport returns a session using the sending and receiving
+// keys from the handshake and both receiver indices.
+func NewTransport(sendKey, recvKey []byte, localIndex, remoteIndex uint32) (*Transport, error) {
+	send, err := (&chacha20.Key{Bytes: sendKey}).NewCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	recv, err := (&chacha20.Key{Bytes: recvKey}).NewCipher()
+	if err != nil {
+		send.ClearKey()
+		return nil, err
+	}
+
+	return &Transport{
+		LocalIndex:  localIndex,
+		RemoteIndex: remoteIndex,
+		send:        send,
+		recv:        recv,
+	}, nil
+}
+
+// Seal pads the packet to a multiple of 16 bytes, encrypts it with
+// the next counter and returns the transport data message.
+// An empty packet is a keepalive.
+func (t *Transport) Seal(packet []byte) ([]byte, error) {
+	if t.sendCounter >= REJECT_AFTER_MESSAGES {
+		return nil, ErrCounterExhausted
+	}
+
+	padded := make([]byte, (len(packet)+PADDING_MULTIPLE-1)/PADDING_MULTIPLE*PADDING_MULTIPLE)
+	copy(padded, packet)
+
+	msg := make([]byte, TRANSPORT_HEADER_SIZE, TRANSPORT_HEADER_SIZE+len(padded)+chacha20.TAG_SIZE)
+	msg[0] = MESSAGE_TRANSPORT_TYPE
+	binary.LittleEndian.PutUint32(msg[4:8], t.RemoteIndex)
+	binary.LittleEndian.PutUint64(msg[8:16], t.sendCounter)
+
+	sealed, err := t.send.Seal(transportNonce(t.sendCounter), padded, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	t.sendCounter++
+
+	return append(msg, sealed...), nil
+}
+
+// Open verifies and decrypts a transport data message and returns
+// the padded packet (the packet's own header carries its length).
+//
+// ErrAuthFailed error from the chacha20 package is returned if the
+// message was modified. The counter is only checked against the
+// replay filter after the message is authenticated, so forged
+// messages can't move the window.
+func (t *Transport) Open(msg []byte) ([]byte, error) {
+	header, err := ParseTransportHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Receiver != t.LocalIndex {
+		return nil, ErrReceiver
+	}
+
+	if header.Counter >= REJECT_AFTER_MESSAGES {
+		return nil, ErrReplay
+	}
+
+	packet, err := t.recv.Open(transportNonce(header.Counter), msg[TRANSPORT_HEADER_SIZE:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !t.filter.Accept(header.Counter) {
+		return nil, ErrReplay
+	}
+
+	return packet, nil
+}
+
+// Clear wipes both keys.
+func (t *Transport) Clear() {
+	t.send.ClearKey()
+	t.recv.ClearKey()
+}
+
+// TransportNonce returns 4 zero bytes followed
+// by the little endian 64-bit counter.
+func transportNonce(counter uint64) []byte {
+	nonce := make([]byte, chacha20.NONCE_SIZE)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+
+	return nonce
+}
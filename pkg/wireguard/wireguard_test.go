@@ -0,0 +1,190 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wireguard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/wedkarz02/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var (
+	keyA = bytes.Repeat([]byte{0xa1}, chacha20.KEY_SIZE)
+	keyB = bytes.Repeat([]byte{0xb2}, chacha20.KEY_SIZE)
+)
+
+// ReferenceMessage builds a transport data message
+// with golang.org/x/crypto/chacha20poly1305.
+func referenceMessage(t *testing.T, key []byte, receiver uint32, counter uint64, packet []byte) []byte {
+	t.Helper()
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := make([]byte, TRANSPORT_HEADER_SIZE)
+	msg[0] = MESSAGE_TRANSPORT_TYPE
+	binary.LittleEndian.PutUint32(msg[4:8], receiver)
+	binary.LittleEndian.PutUint64(msg[8:16], counter)
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+
+	return aead.Seal(msg, nonce, packet, nil)
+}
+
+func newTransportPair(t *testing.T) (*Transport, *Transport) {
+	t.Helper()
+
+	a, err := NewTransport(keyA, keyB, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewTransport(keyB, keyA, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, b
+}
+
+func TestTransportReference(t *testing.T) {
+	a, b := newTransportPair(t)
+
+	packets := [][]byte{nil, []byte("ping"), bytes.Repeat([]byte{0x45}, 16), bytes.Repeat([]byte{0x60}, 1420)}
+
+	for counter, packet := range packets {
+		padded := make([]byte, (len(packet)+15)/16*16)
+		copy(padded, packet)
+
+		expected := referenceMessage(t, keyA, 2, uint64(counter), padded)
+
+		msg, err := a.Seal(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(msg, expected) {
+			t.Fatalf("counter %d: seal mismatch:\nexpected %x\nfound    %x", counter, expected, msg)
+		}
+
+		header, err := ParseTransportHeader(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header.Receiver != 2 || header.Counter != uint64(counter) {
+			t.Fatalf("counter %d: unexpected header %+v", counter, header)
+		}
+
+		opened, err := b.Open(expected)
+		if err != nil {
+			t.Fatalf("counter %d: %v", counter, err)
+		}
+
+		if !bytes.Equal(opened, padded) {
+			t.Fatalf("counter %d: open mismatch", counter)
+		}
+	}
+}
+
+func TestTransportReplay(t *testing.T) {
+	_, b := newTransportPair(t)
+
+	// Messages arriving out of order are accepted once.
+	for _, counter := range []uint64{5, 3, 4, 0} {
+		if _, err := b.Open(referenceMessage(t, keyA, 2, counter, nil)); err != nil {
+			t.Fatalf("counter %d: %v", counter, err)
+		}
+	}
+
+	if _, err := b.Open(referenceMessage(t, keyA, 2, 3, nil)); err != ErrReplay {
+		t.Fatalf("expected ErrReplay, found %v", err)
+	}
+
+	// A forged message with a high counter must not move the window.
+	forged := referenceMessage(t, keyA, 2, 1<<40, nil)
+	forged[len(forged)-1] ^= 0x01
+
+	if _, err := b.Open(forged); err != chacha20.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, found %v", err)
+	}
+
+	if _, err := b.Open(referenceMessage(t, keyA, 2, 1, nil)); err != nil {
+		t.Fatalf("counter 1 rejected after forged message: %v", err)
+	}
+
+	if _, err := b.Open(referenceMessage(t, keyA, 2, REJECT_AFTER_MESSAGES, nil)); err != ErrReplay {
+		t.Fatalf("expected ErrReplay for REJECT_AFTER_MESSAGES, found %v", err)
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	a, b := newTransportPair(t)
+
+	msg, err := a.Seal([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range msg {
+		modified := append([]byte(nil), msg...)
+		modified[i] ^= 0x01
+
+		switch _, err := b.Open(modified); {
+		case i < 4:
+			if err != ErrMessageType {
+				t.Fatalf("byte %d: expected ErrMessageType, found %v", i, err)
+			}
+		case i < 8:
+			if err != ErrReceiver {
+				t.Fatalf("byte %d: expected ErrReceiver, found %v", i, err)
+			}
+		default:
+			if err != chacha20.ErrAuthFailed {
+				t.Fatalf("byte %d: expected ErrAuthFailed, found %v", i, err)
+			}
+		}
+	}
+
+	if _, err := b.Open(msg[:MIN_TRANSPORT_SIZE-1]); err != ErrMessageSize {
+		t.Fatalf("expected ErrMessageSize, found %v", err)
+	}
+
+	// Messages are only accepted in the sending direction.
+	if _, err := a.Open(msg); err != ErrReceiver {
+		t.Fatalf("expected ErrReceiver for reflected message, found %v", err)
+	}
+
+	a.sendCounter = REJECT_AFTER_MESSAGES
+	if _, err := a.Seal(nil); err != ErrCounterExhausted {
+		t.Fatalf("expected ErrCounterExhausted, found %v", err)
+	}
+
+	if _, err := NewTransport(keyA[1:], keyB, 1, 2); err != chacha20.ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+// Test vectors from RFC 8439 appendix A.
+// The Poly1305 vectors (A.3) are in the poly package.
+//
+// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A
+
+func TestBlockFunctionVectors(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A.1
+	testVectors := []struct {
+		key               string
+		nonce             string
+		ctr               uint32
+		expectedKeyStream string
+	}{
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce: "000000000000000000000000",
+			ctr:   0,
+			expectedKeyStream: "" +
+				"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+				"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586",
+		},
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce: "000000000000000000000000",
+			ctr:   1,
+			expectedKeyStream: "" +
+				"9f07e7be5551387a98ba977c732d080dcb0f29a048e3656912c6533e32ee7aed" +
+				"29b721769ce64e43d57133b074d839d531ed1f28510afb45ace10a1f4b794d6f",
+		},
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000001",
+			nonce: "000000000000000000000000",
+			ctr:   1,
+			expectedKeyStream: "" +
+				"3aeb5224ecf849929b9d828db1ced4dd832025e8018b8160b82284f3c949aa5a" +
+				"8eca00bbb4a73bdad192b5c42f73f2fd4e273644c8b36125a64addeb006c13a0",
+		},
+		{
+			key:   "00ff000000000000000000000000000000000000000000000000000000000000",
+			nonce: "000000000000000000000000",
+			ctr:   2,
+			expectedKeyStream: "" +
+				"72d54dfbf12ec44b362692df94137f328fea8da73990265ec1bbbea1ae9af0ca" +
+				"13b25aa26cb4a648cb9b9d1be65b2c0924a66c54d545ec1b7374f4872e99f096",
+		},
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce: "000000000000000000000002",
+			ctr:   0,
+			expectedKeyStream: "" +
+				"c2c64d378cd536374ae204b9ef933fcd1a8b2288b3dfa49672ab765b54ee27c7" +
+				"8a970e0e955c14f3a88e741b97c286f75f8fc299e8148362fa198a39531bed6d",
+		},
+	}
+
+	for i, tv := range testVectors {
+		c, err := newRawCipher(testutil.DecodeHex(t, tv.key), testutil.DecodeHex(t, tv.nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.ctr = tv.ctr
+		c.resetState()
+		c.block()
+
+		keyStream := c.serialize()
+		if expected := testutil.DecodeHex(t, tv.expectedKeyStream); !bytes.Equal(keyStream[:], expected) {
+			t.Fatalf("test vector #%d: expected %x, found %x", i+1, expected, keyStream)
+		}
+	}
+}
+
+func TestEncryptionVectors(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A.2
+	testVectors := []struct {
+		key                string
+		nonce              string
+		ctr                uint32
+		plainText          string
+		expectedCipherText string
+	}{
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce: "000000000000000000000000",
+			ctr:   0,
+			plainText: "" +
+				"0000000000000000000000000000000000000000000000000000000000000000" +
+				"0000000000000000000000000000000000000000000000000000000000000000",
+			expectedCipherText: "" +
+				"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+				"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586",
+		},
+		{
+			key:   "0000000000000000000000000000000000000000000000000000000000000001",
+			nonce: "000000000000000000000002",
+			ctr:   1,
+			plainText: "" +
+				"416e79207375626d697373696f6e20746f20746865204945544620696e74656e" +
+				"6465642062792074686520436f6e7472696275746f7220666f72207075626c69" +
+				"636174696f6e20617320616c6c206f722070617274206f6620616e2049455446" +
+				"20496e7465726e65742d4472616674206f722052464320616e6420616e792073" +
+				"746174656d656e74206d6164652077697468696e2074686520636f6e74657874" +
+				"206f6620616e204945544620616374697669747920697320636f6e7369646572" +
+				"656420616e20224945544620436f6e747269627574696f6e222e205375636820" +
+				"73746174656d656e747320696e636c756465206f72616c2073746174656d656e" +
+				"747320696e20494554462073657373696f6e732c2061732077656c6c20617320" +
+				"7772697474656e20616e6420656c656374726f6e696320636f6d6d756e696361" +
+				"74696f6e73206d61646520617420616e792074696d65206f7220706c6163652c" +
+				"207768696368206172652061646472657373656420746f",
+			expectedCipherText: "" +
+				"a3fbf07df3fa2fde4f376ca23e82737041605d9f4f4f57bd8cff2c1d4b7955ec" +
+				"2a97948bd3722915c8f3d337f7d370050e9e96d647b7c39f56e031ca5eb6250d" +
+				"4042e02785ececfa4b4bb5e8ead0440e20b6e8db09d881a7c6132f420e527950" +
+				"42bdfa7773d8a9051447b3291ce1411c680465552aa6c405b7764d5e87bea85a" +
+				"d00f8449ed8f72d0d662ab052691ca66424bc86d2df80ea41f43abf937d3259d" +
+				"c4b2d0dfb48a6c9139ddd7f76966e928e635553ba76c5c879d7b35d49eb2e62b" +
+				"0871cdac638939e25e8a1e0ef9d5280fa8ca328b351c3c765989cbcf3daa8b6c" +
+				"cc3aaf9f3979c92b3720fc88dc95ed84a1be059c6499b9fda236e7e818b04b0b" +
+				"c39c1e876b193bfe5569753f88128cc08aaa9b63d1a16f80ef2554d7189c411f" +
+				"5869ca52c5b83fa36ff216b9c1d30062bebcfd2dc5bce0911934fda79a86f6e6" +
+				"98ced759c3ff9b6477338f3da4f9cd8514ea9982ccafb341b2384dd902f3d1ab" +
+				"7ac61dd29c6f21ba5b862f3730e37cfdc4fd806c22f221",
+		},
+		{
+			key:   "1c9240a5eb55d38af333888604f6b5f0473917c1402b80099dca5cbc207075c0",
+			nonce: "000000000000000000000002",
+			ctr:   42,
+			plainText: "" +
+				"2754776173206272696c6c69672c20616e642074686520736c6974687920746f" +
+				"7665730a446964206779726520616e642067696d626c6520696e207468652077" +
+				"6162653a0a416c6c206d696d737920776572652074686520626f726f676f7665" +
+				"732c0a416e6420746865206d6f6d65207261746873206f757467726162652e",
+			expectedCipherText: "" +
+				"62e6347f95ed87a45ffae7426f27a1df5fb69110044c0d73118effa95b01e5cf" +
+				"166d3df2d721caf9b21e5fb14c616871fd84c54f9d65b283196c7fe4f60553eb" +
+				"f39c6402c42234e32a356b3e764312a61a5532055716ead6962568f87d3f3f77" +
+				"04c6a8d1bcd1bf4d50d6154b6da731b187b58dfd728afa36757a797ac188d1",
+		},
+	}
+
+	for i, tv := range testVectors {
+		c, err := newRawCipher(testutil.DecodeHex(t, tv.key), testutil.DecodeHex(t, tv.nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cipherText, err := c.xorKeyStream(testutil.DecodeHex(t, tv.plainText), tv.ctr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := testutil.DecodeHex(t, tv.expectedCipherText); !bytes.Equal(cipherText, expected) {
+			t.Fatalf("test vector #%d: expected %x, found %x", i+1, expected, cipherText)
+		}
+
+		plainText, err := c.xorKeyStream(cipherText, tv.ctr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := testutil.DecodeHex(t, tv.plainText); !bytes.Equal(plainText, expected) {
+			t.Fatalf("test vector #%d: decryption doesn't restore the plaintext", i+1)
+		}
+	}
+}
+
+func TestPolyKeyVectors(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A.4
+	testVectors := []struct {
+		key         string
+		nonce       string
+		expectedKey string
+	}{
+		{
+			key:         "0000000000000000000000000000000000000000000000000000000000000000",
+			nonce:       "000000000000000000000000",
+			expectedKey: "76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7",
+		},
+		{
+			key:         "0000000000000000000000000000000000000000000000000000000000000001",
+			nonce:       "000000000000000000000002",
+			expectedKey: "ecfa254f845f647473d3cb140da9e87606cb33066c447b87bc2666dde3fbb739",
+		},
+		{
+			key:         "1c9240a5eb55d38af333888604f6b5f0473917c1402b80099dca5cbc207075c0",
+			nonce:       "000000000000000000000002",
+			expectedKey: "965e3bc6f9ec7ed9560808f4d229f94b137ff275ca9b3fcbdd59deaad23310ae",
+		},
+	}
+
+	for i, tv := range testVectors {
+		c, err := newRawCipher(testutil.DecodeHex(t, tv.key), testutil.DecodeHex(t, tv.nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := testutil.DecodeHex(t, tv.expectedKey), c.polyKey(); !bytes.Equal(actual, expected) {
+			t.Fatalf("test vector #%d: expected %x, found %x", i+1, expected, actual)
+		}
+	}
+}
+
+func TestAEADDecryptionVector(t *testing.T) {
+	// https://datatracker.ietf.org/doc/html/rfc8439#appendix-A.5
+	key := testutil.DecodeHex(t, "1c9240a5eb55d38af333888604f6b5f0473917c1402b80099dca5cbc207075c0")
+	nonce := testutil.DecodeHex(t, "000000000102030405060708")
+	aad := testutil.DecodeHex(t, "f33388860000000000004e91")
+	cipherText := testutil.DecodeHex(t, ""+
+		"64a0861575861af460f062c79be643bd5e805cfd345cf389f108670ac76c8cb2"+
+		"4c6cfc18755d43eea09ee94e382d26b0bdb7b73c321b0100d4f03b7f355894cf"+
+		"332f830e710b97ce98c8a84abd0b948114ad176e008d33bd60f982b1ff37c855"+
+		"9797a06ef4f0ef61c186324e2b3506383606907b6a7c02b0f9f6157b53c867e4"+
+		"b9166c767b804d46a59b5216cde7a4e99040c5a40433225ee282a1b0a06c523e"+
+		"af4534d7f83fa1155b0047718cbc546a0d072b04b3564eea1b422273f548271a"+
+		"0bb2316053fa76991955ebd63159434ecebb4e466dae5a1073a6727627097a10"+
+		"49e617d91d361094fa68f0ff77987130305beaba2eda04df997b714d6c6f2c29"+
+		"a6ad5cb4022b02709beead9d67890cbb22392336fea1851f38")
+	expected := testutil.DecodeHex(t, ""+
+		"496e7465726e65742d4472616674732061726520647261667420646f63756d65"+
+		"6e74732076616c696420666f722061206d6178696d756d206f6620736978206d"+
+		"6f6e74687320616e64206d617920626520757064617465642c207265706c6163"+
+		"65642c206f72206f62736f6c65746564206279206f7468657220646f63756d65"+
+		"6e747320617420616e792074696d652e20497420697320696e617070726f7072"+
+		"6961746520746f2075736520496e7465726e65742d4472616674732061732072"+
+		"65666572656e6365206d6174657269616c206f7220746f206369746520746865"+
+		"6d206f74686572207468616e206173202fe2809c776f726b20696e2070726f67"+
+		"726573732e2fe2809d")
+
+	plainText, err := openChaCha20Poly1305(key, nonce, cipherText, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(plainText, expected) {
+		t.Fatalf("expected %q, found %q", expected, plainText)
+	}
+
+	if _, err := openChaCha20Poly1305(key, nonce, cipherText, aad[:len(aad)-1]); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for modified associated data, found %v", err)
+	}
+}
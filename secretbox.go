@@ -0,0 +1,166 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"crypto/subtle"
+
+	"github.com/wedkarz02/chacha20/pkg/poly"
+	"github.com/wedkarz02/chacha20/pkg/salsa20"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Secret boxes follow libsodium's crypto_secretbox_easy:
+//
+//	tag (16) | cipherText
+//
+// The stream cipher is run over 32 zero bytes followed by the message.
+// The encrypted zero bytes are the Poly1305 key and the tag covers the
+// cipherText only. SealSecretBox uses XSalsa20 like libsodium's default
+// crypto_secretbox, sealed boxes use XChaCha20 as in
+// crypto_secretbox_xchacha20poly1305.
+const secretBoxZeroSize = 32
+
+// SecretBoxStream encrypts/decrypts the data with a stream cipher
+// using a 192-bit nonce and the block counter starting at 0.
+type secretBoxStream func(key, nonce, data []byte) ([]byte, error)
+
+// SealSecretBox encrypts and authenticates the message with
+// XSalsa20-Poly1305 and the 24-byte nonce. The output can be
+// opened with libsodium's crypto_secretbox_open_easy.
+//
+// The caller is responsible for never reusing a nonce with the same key.
+func SealSecretBox(key, nonce, msg []byte) ([]byte, error) {
+	return sealSecretBox(xorXSalsa20, key, nonce, msg)
+}
+
+// OpenSecretBox verifies and decrypts a box created by SealSecretBox
+// or libsodium's crypto_secretbox_easy.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No plainText is released in that case.
+func OpenSecretBox(key, nonce, box []byte) ([]byte, error) {
+	return openSecretBox(xorXSalsa20, key, nonce, box)
+}
+
+// XorXSalsa20 encrypts/decrypts the data with XSalsa20
+// starting at counter 0.
+func xorXSalsa20(key, nonce, data []byte) ([]byte, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	c, err := salsa20.NewX(key, nonce)
+	if err != nil {
+		return nil, ErrNonceSize
+	}
+	defer c.Clear()
+
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+
+	return out, nil
+}
+
+// XorXChaCha20SecretBox encrypts/decrypts the data with XChaCha20
+// starting at counter 0. The ChaCha20 nonce is 4 zero bytes followed
+// by the last 64 bits of the extended nonce, which gives the same key
+// stream as the original 64-bit nonce variant used by libsodium.
+func xorXChaCha20SecretBox(key, nonce, data []byte) ([]byte, error) {
+	subKey, subNonce, err := xChaCha20Params(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newRawCipher(subKey, subNonce)
+	util.Wipe(subKey)
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	return c.xorKeyStream(data, 0)
+}
+
+// SecretBox runs the stream over 32 zero bytes followed by the data
+// and returns the Poly1305 key separately from the processed data.
+func secretBox(stream secretBoxStream, key, nonce, data []byte) ([]byte, []byte, error) {
+	block := make([]byte, secretBoxZeroSize+len(data))
+	copy(block[secretBoxZeroSize:], data)
+
+	out, err := stream(key, nonce, block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out[:secretBoxZeroSize], out[secretBoxZeroSize:], nil
+}
+
+// SealSecretBox encrypts and authenticates the plainText.
+// The returned slice is the tag followed by the cipherText.
+func sealSecretBox(stream secretBoxStream, key, nonce, plainText []byte) ([]byte, error) {
+	otk, cipherText, err := secretBox(stream, key, nonce, plainText)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(otk)
+
+	tag, err := poly.Sum(cipherText, otk)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(tag[:], cipherText...), nil
+}
+
+// OpenSecretBox verifies and decrypts the box created by sealSecretBox.
+//
+// ErrAuthFailed error is returned if the tag doesn't match.
+// No plainText is released in that case.
+func openSecretBox(stream secretBoxStream, key, nonce, box []byte) ([]byte, error) {
+	if len(box) < TAG_SIZE {
+		return nil, ErrAuthFailed
+	}
+
+	tag := box[:TAG_SIZE]
+	cipherText := box[TAG_SIZE:]
+
+	// The Poly1305 key only depends on the first block,
+	// so verify it before decrypting the whole box.
+	otk, _, err := secretBox(stream, key, nonce, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(otk)
+
+	expected, err := poly.Sum(cipherText, otk)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(tag, expected[:]) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	_, plainText, err := secretBox(stream, key, nonce, cipherText)
+
+	return plainText, err
+}
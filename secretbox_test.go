@@ -0,0 +1,119 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+// Secret boxes generated with libsodium 1.0.18 crypto_secretbox_easy.
+type libsodiumSecretBoxFile struct {
+	Source  string `json:"source"`
+	Vectors []struct {
+		Key     string `json:"key"`
+		Nonce   string `json:"nonce"`
+		Message string `json:"message"`
+		Box     string `json:"box"`
+	} `json:"vectors"`
+}
+
+func TestSecretBoxLibsodium(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "libsodium", "secretbox_xsalsa20poly1305.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f libsodiumSecretBoxFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Vectors) == 0 {
+		t.Fatal("no test vectors found")
+	}
+
+	for i, v := range f.Vectors {
+		key := testutil.DecodeHex(t, v.Key)
+		nonce := testutil.DecodeHex(t, v.Nonce)
+		msg := testutil.DecodeHex(t, v.Message)
+		expected := testutil.DecodeHex(t, v.Box)
+
+		box, err := SealSecretBox(key, nonce, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(box, expected) {
+			t.Fatalf("vector %d: seal mismatch:\nexpected %x\nfound    %x", i, expected, box)
+		}
+
+		opened, err := OpenSecretBox(key, nonce, box)
+		if err != nil {
+			t.Fatalf("vector %d: %v", i, err)
+		}
+
+		if !bytes.Equal(opened, msg) {
+			t.Fatalf("vector %d: open mismatch: expected %x, found %x", i, msg, opened)
+		}
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := testutil.DecodeHex(t, "404142434445464748494a4b4c4d4e4f5051525354555657")
+
+	box, err := SealSecretBox(key, nonce, aeadPlainText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range box {
+		modified := append([]byte(nil), box...)
+		modified[i] ^= 0x01
+
+		if _, err := OpenSecretBox(key, nonce, modified); err != ErrAuthFailed {
+			t.Fatalf("byte %d: expected ErrAuthFailed for modified box, found %v", i, err)
+		}
+	}
+
+	if _, err := OpenSecretBox(key, nonce, box[:TAG_SIZE-1]); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for short box, found %v", err)
+	}
+
+	if _, err := SealSecretBox(key, nonce[:NONCE_SIZE], aeadPlainText); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+
+	if _, err := SealSecretBox(key[1:], nonce, aeadPlainText); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+
+	// The XChaCha20 variant must not open XSalsa20 boxes.
+	if _, err := openSecretBox(xorXChaCha20SecretBox, key, nonce, box); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for the XChaCha20 variant, found %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/wedkarz02/chacha20/pkg/poly"
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+// Secret streams follow libsodium's crypto_secretstream_xchacha20poly1305.
+// The stream starts with a random 24-byte header, which derives the first
+// key with HChaCha20 and the 64-bit inner nonce. Every message is
+//
+//	encrypted tag (1) | cipherText | Poly1305 tag (16)
+//
+// encrypted with ChaCha20 under the 96-bit nonce counter (4) | inner nonce (8).
+// After each message the inner nonce is XORed with the Poly1305 tag and
+// the counter is incremented, so messages can't be dropped, reordered or
+// replayed without the next one failing to authenticate.
+const (
+	// Size of the secret stream header in bytes.
+	SECRETSTREAM_HEADER_SIZE = XNONCE_SIZE
+
+	// Size added to every pushed message.
+	SECRETSTREAM_OVERHEAD = 1 + TAG_SIZE
+
+	// Tag of a regular message.
+	SECRETSTREAM_TAG_MESSAGE = byte(0x00)
+
+	// Tag marking the end of a set of messages, the stream continues.
+	SECRETSTREAM_TAG_PUSH = byte(0x01)
+
+	// Tag which rekeys the stream after the message.
+	SECRETSTREAM_TAG_REKEY = byte(0x02)
+
+	// Tag of the last message of the stream.
+	SECRETSTREAM_TAG_FINAL = SECRETSTREAM_TAG_PUSH | SECRETSTREAM_TAG_REKEY
+
+	// Size of the message counter at the start of the nonce.
+	secretStreamCounterSize = 4
+)
+
+// SecretStream is the state of a secret stream. The sender creates it
+// with NewSecretStreamPush and the receiver with NewSecretStreamPull.
+// It is not safe for concurrent use.
+type SecretStream struct {
+	key   [KEY_SIZE]byte
+	nonce [NONCE_SIZE]byte
+}
+
+// NewSecretStreamPush starts a new secret stream with the 32-byte key.
+// The returned header has to be sent to the receiver before any message.
+func NewSecretStreamPush(key []byte) (*SecretStream, []byte, error) {
+	header, err := util.RandomBytes(SECRETSTREAM_HEADER_SIZE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := NewSecretStreamPull(key, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, header, nil
+}
+
+// NewSecretStreamPull sets up the receiving side of
+// a secret stream from the key and the stream header.
+func NewSecretStreamPull(key, header []byte) (*SecretStream, error) {
+	if len(header) != SECRETSTREAM_HEADER_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	subKey, err := hChaCha20(key, header[:HNONCE_SIZE])
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(subKey)
+
+	var s SecretStream
+	copy(s.key[:], subKey)
+	s.resetCounter()
+	copy(s.nonce[secretStreamCounterSize:], header[HNONCE_SIZE:])
+
+	return &s, nil
+}
+
+// Push encrypts and authenticates the message with the tag.
+// The additional data is authenticated but not encrypted.
+func (s *SecretStream) Push(msg, additionalData []byte, tag byte) ([]byte, error) {
+	c := s.cipher()
+	defer c.ClearKey()
+
+	block := make([]byte, STATE_BYTE_SIZE)
+	block[0] = tag
+
+	block, err := c.xorKeyStream(block, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherText, err := c.xorKeyStream(msg, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := secretStreamTag(c, additionalData, block, cipherText)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(msg)+SECRETSTREAM_OVERHEAD)
+	out = append(out, block[0])
+	out = append(out, cipherText...)
+	out = append(out, mac...)
+
+	s.advance(mac, tag)
+
+	return out, nil
+}
+
+// Pull verifies and decrypts a message created by Push
+// and returns it with its tag.
+//
+// ErrAuthFailed error is returned if the message is too short,
+// it was modified or it isn't the next message of the stream.
+// The state doesn't change in that case.
+func (s *SecretStream) Pull(in, additionalData []byte) ([]byte, byte, error) {
+	if len(in) < SECRETSTREAM_OVERHEAD {
+		return nil, 0, ErrAuthFailed
+	}
+
+	c := s.cipher()
+	defer c.ClearKey()
+
+	block, err := c.xorKeyStream(make([]byte, STATE_BYTE_SIZE), 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tag := in[0] ^ block[0]
+	block[0] = in[0]
+
+	cipherText := in[1 : len(in)-TAG_SIZE]
+	mac := in[len(in)-TAG_SIZE:]
+
+	expected, err := secretStreamTag(c, additionalData, block, cipherText)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if subtle.ConstantTimeCompare(mac, expected) != 1 {
+		return nil, 0, ErrAuthFailed
+	}
+
+	msg, err := c.xorKeyStream(cipherText, 2)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.advance(mac, tag)
+
+	return msg, tag, nil
+}
+
+// Rekey replaces the key and the inner nonce with the first 40 bytes
+// of the current key stream and resets the counter. Both sides have
+// to rekey at the same point of the stream.
+func (s *SecretStream) Rekey() {
+	c := s.cipher()
+	defer c.ClearKey()
+
+	var buf [KEY_SIZE + NONCE_SIZE - secretStreamCounterSize]byte
+	copy(buf[:], s.key[:])
+	copy(buf[KEY_SIZE:], s.nonce[secretStreamCounterSize:])
+
+	// The size is fixed, so the key stream always covers it.
+	out, _ := c.xorKeyStream(buf[:], 0)
+	util.Wipe(buf[:])
+
+	copy(s.key[:], out[:KEY_SIZE])
+	copy(s.nonce[secretStreamCounterSize:], out[KEY_SIZE:])
+	util.Wipe(out)
+
+	s.resetCounter()
+}
+
+// Clear sets the key and the nonce to 0x00.
+func (s *SecretStream) Clear() {
+	util.Wipe(s.key[:])
+	util.Wipe(s.nonce[:])
+}
+
+// Cipher returns the ChaCha20 cipher for the current message.
+func (s *SecretStream) cipher() *Cipher {
+	// The key and the nonce have fixed sizes, so this can't fail.
+	c, _ := newRawCipher(s.key[:], s.nonce[:])
+
+	return c
+}
+
+// SecretStreamTag computes the Poly1305 tag of a message. The one-time key comes
+// from block 0 and the MAC covers the padded additional data, the
+// encrypted tag block, the cipherText and both lengths.
+//
+// Unlike in RFC 8439, libsodium pads the cipherText with
+// len(cipherText) % 16 zero bytes, which is kept for compatibility.
+func secretStreamTag(c *Cipher, additionalData, block, cipherText []byte) ([]byte, error) {
+	m, err := poly.New(c.polyKey())
+	if err != nil {
+		return nil, err
+	}
+
+	var pad [poly.BLOCK_SIZE]byte
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(block)+len(cipherText)))
+
+	m.Write(additionalData)
+	m.Write(pad[:padding16(len(additionalData))])
+	m.Write(block)
+	m.Write(cipherText)
+	m.Write(pad[:len(cipherText)%poly.BLOCK_SIZE])
+	m.Write(lengths[:])
+
+	return m.Sum(nil), nil
+}
+
+// Advance mixes the tag into the inner nonce and increments the counter.
+// The stream is rekeyed if requested by the tag or when the counter wraps.
+func (s *SecretStream) advance(mac []byte, tag byte) {
+	for i := 0; i < NONCE_SIZE-secretStreamCounterSize; i++ {
+		s.nonce[secretStreamCounterSize+i] ^= mac[i]
+	}
+
+	ctr := binary.LittleEndian.Uint32(s.nonce[:secretStreamCounterSize]) + 1
+	binary.LittleEndian.PutUint32(s.nonce[:secretStreamCounterSize], ctr)
+
+	if tag&SECRETSTREAM_TAG_REKEY != 0 || ctr == 0 {
+		s.Rekey()
+	}
+}
+
+// ResetCounter sets the message counter back to 1.
+func (s *SecretStream) resetCounter() {
+	binary.LittleEndian.PutUint32(s.nonce[:secretStreamCounterSize], 1)
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+// Secret streams generated with libsodium 1.0.18. Entries with
+// rekey set record a crypto_secretstream_xchacha20poly1305_rekey call.
+type libsodiumSecretStreamFile struct {
+	Source  string `json:"source"`
+	Streams []struct {
+		Key      string `json:"key"`
+		Header   string `json:"header"`
+		Messages []struct {
+			Rekey          bool   `json:"rekey"`
+			Message        string `json:"message"`
+			AdditionalData string `json:"additionalData"`
+			Tag            byte   `json:"tag"`
+			CipherText     string `json:"cipherText"`
+		} `json:"messages"`
+	} `json:"streams"`
+}
+
+func TestSecretStreamLibsodium(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "libsodium", "secretstream_xchacha20poly1305.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f libsodiumSecretStreamFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Streams) == 0 {
+		t.Fatal("no test vectors found")
+	}
+
+	for i, stream := range f.Streams {
+		key := testutil.DecodeHex(t, stream.Key)
+		header := testutil.DecodeHex(t, stream.Header)
+
+		// Both sides start from the same state, so the pushing
+		// side can be recreated from the recorded header.
+		push, err := NewSecretStreamPull(key, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pull, err := NewSecretStreamPull(key, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, m := range stream.Messages {
+			if m.Rekey {
+				push.Rekey()
+				pull.Rekey()
+				continue
+			}
+
+			msg := testutil.DecodeHex(t, m.Message)
+			aad := testutil.DecodeHex(t, m.AdditionalData)
+			expected := testutil.DecodeHex(t, m.CipherText)
+
+			out, err := push.Push(msg, aad, m.Tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(out, expected) {
+				t.Fatalf("stream %d, message %d: push mismatch:\nexpected %x\nfound    %x", i, j, expected, out)
+			}
+
+			opened, tag, err := pull.Pull(expected, aad)
+			if err != nil {
+				t.Fatalf("stream %d, message %d: %v", i, j, err)
+			}
+
+			if !bytes.Equal(opened, msg) || tag != m.Tag {
+				t.Fatalf("stream %d, message %d: pull mismatch: expected %x (tag %d), found %x (tag %d)", i, j, msg, m.Tag, opened, tag)
+			}
+		}
+	}
+}
+
+func TestSecretStream(t *testing.T) {
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+
+	push, header, err := NewSecretStreamPush(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := [][]byte{aeadPlainText, nil, []byte("last")}
+	tags := []byte{SECRETSTREAM_TAG_MESSAGE, SECRETSTREAM_TAG_PUSH, SECRETSTREAM_TAG_FINAL}
+
+	var sealed [][]byte
+	for i, msg := range messages {
+		out, err := push.Push(msg, []byte("aad"), tags[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(out) != len(msg)+SECRETSTREAM_OVERHEAD {
+			t.Fatalf("message %d: expected %d bytes, found %d", i, len(msg)+SECRETSTREAM_OVERHEAD, len(out))
+		}
+
+		sealed = append(sealed, out)
+	}
+
+	newPull := func() *SecretStream {
+		pull, err := NewSecretStreamPull(key, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pull
+	}
+
+	pull := newPull()
+
+	// Failures don't change the state, so the stream
+	// continues once the right message arrives.
+	for i, out := range sealed {
+		for j := range out {
+			modified := append([]byte(nil), out...)
+			modified[j] ^= 0x01
+
+			if _, _, err := pull.Pull(modified, []byte("aad")); err != ErrAuthFailed {
+				t.Fatalf("message %d, byte %d: expected ErrAuthFailed, found %v", i, j, err)
+			}
+		}
+
+		if _, _, err := pull.Pull(out, []byte("other")); err != ErrAuthFailed {
+			t.Fatalf("message %d: expected ErrAuthFailed for modified associated data, found %v", i, err)
+		}
+
+		msg, tag, err := pull.Pull(out, []byte("aad"))
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+
+		if !bytes.Equal(msg, messages[i]) || tag != tags[i] {
+			t.Fatalf("message %d: pull mismatch", i)
+		}
+	}
+
+	// Dropped and reordered messages.
+	if _, _, err := newPull().Pull(sealed[1], []byte("aad")); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for a dropped message, found %v", err)
+	}
+
+	if _, _, err := pull.Pull(sealed[2], []byte("aad")); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for a replayed message, found %v", err)
+	}
+
+	if _, _, err := newPull().Pull(sealed[0][:SECRETSTREAM_OVERHEAD-1], nil); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for a short message, found %v", err)
+	}
+
+	if _, err := NewSecretStreamPull(key, header[1:]); err != ErrNonceSize {
+		t.Fatalf("expected ErrNonceSize, found %v", err)
+	}
+
+	if _, _, err := NewSecretStreamPush(key[1:]); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
+
+func TestSecretStreamCounterWrap(t *testing.T) {
+	key := testutil.DecodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	header := make([]byte, SECRETSTREAM_HEADER_SIZE)
+
+	s, err := NewSecretStreamPull(key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.nonce = [NONCE_SIZE]byte{0xff, 0xff, 0xff, 0xff}
+
+	// With a zero MAC only the counter changes before the rekey.
+	expected := *s
+	expected.nonce = [NONCE_SIZE]byte{}
+	expected.Rekey()
+
+	// The counter wrapping to 0 rekeys the stream
+	// like a message with the rekey tag.
+	var mac [TAG_SIZE]byte
+	s.advance(mac[:], SECRETSTREAM_TAG_MESSAGE)
+
+	if *s != expected {
+		t.Fatal("stream wasn't rekeyed after the counter wrapped")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+const (
+	// Magic bytes at the start of data encrypted with EnvelopeEncrypt.
+	WRAPPED_MAGIC = "CC2K"
+
+	// Current version of the EnvelopeEncrypt format.
+	WRAPPED_VERSION = 1
+
+	// Size of a key wrapped by WrapKey: nonce, encrypted key and tag.
+	WRAPPED_KEY_SIZE = XNONCE_SIZE + KEY_SIZE + TAG_SIZE
+)
+
+var (
+	// Error returned if the data is not a valid EnvelopeEncrypt output.
+	ErrWrappedFormat = errors.New("malformed wrapped key data")
+
+	// Error returned if the key-encryption key can't unwrap the data key.
+	ErrUnwrap = errors.New("data key unwrapping failed")
+)
+
+// KeyEncryptionKeyProvider wraps and unwraps data-encryption keys
+// with long-lived key-encryption keys (KEKs).
+//
+// The provider never has to reveal the KEK, so it can be
+// backed by a local key file as well as a remote KMS.
+type KeyEncryptionKeyProvider interface {
+	// WrapKey encrypts the data key with the current KEK
+	// and returns the ID of the KEK along with the wrapped key.
+	WrapKey(dataKey []byte) (kekID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts a data key wrapped by the KEK with the given ID.
+	UnwrapKey(kekID string, wrapped []byte) ([]byte, error)
+}
+
+// WrapKey encrypts the data key with the key-encryption key using
+// XChaCha20-Poly1305 with a random nonce. The KEK ID is authenticated
+// as the associated data. The result is nonce|encrypted key|tag.
+func WrapKey(kek *Key, dataKey []byte) ([]byte, error) {
+	if len(dataKey) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	nonce, err := util.RandomBytes(XNONCE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealXChaCha20Poly1305(kek.Bytes, nonce, dataKey, []byte(kek.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(nonce, sealed...), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+//
+// ErrUnwrap error is returned if the wrapped key was
+// modified or the KEK is not the one used for wrapping.
+func UnwrapKey(kek *Key, wrapped []byte) ([]byte, error) {
+	if len(wrapped) != WRAPPED_KEY_SIZE {
+		return nil, ErrWrappedFormat
+	}
+
+	dataKey, err := openXChaCha20Poly1305(kek.Bytes, wrapped[:XNONCE_SIZE], wrapped[XNONCE_SIZE:], []byte(kek.ID))
+	if err == ErrAuthFailed {
+		return nil, ErrUnwrap
+	}
+
+	return dataKey, err
+}
+
+// LocalKeyProvider is a KeyEncryptionKeyProvider with the KEKs
+// held in a Keyring. New data keys are wrapped by the primary key.
+type LocalKeyProvider struct {
+	Keyring *Keyring
+}
+
+// NewFileKeyProvider loads the KEKs from key files (see LoadKey).
+// The first key becomes primary. The passphrase is
+// used for the encrypted key files and may be empty.
+func NewFileKeyProvider(paths []string, passphrase []byte) (*LocalKeyProvider, error) {
+	kr, err := NewKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		k, err := LoadKey(path, passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := kr.Add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LocalKeyProvider{Keyring: kr}, nil
+}
+
+// WrapKey wraps the data key with the primary key of the keyring.
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	kek, err := p.Keyring.primaryCopy()
+	if err != nil {
+		return "", nil, err
+	}
+	defer kek.Clear()
+
+	wrapped, err := WrapKey(kek, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kek.ID, wrapped, nil
+}
+
+// UnwrapKey unwraps the data key with the keyring key of the given ID.
+func (p *LocalKeyProvider) UnwrapKey(kekID string, wrapped []byte) ([]byte, error) {
+	kek, err := p.Keyring.keyCopy(kekID)
+	if err != nil {
+		return nil, err
+	}
+	defer kek.Clear()
+
+	return UnwrapKey(kek, wrapped)
+}
+
+// EnvelopeEncrypt encrypts the plainText with a fresh random data key,
+// which is then wrapped by the provider and stored alongside the data:
+//
+//	magic       4 bytes   "CC2K"
+//	version     1 byte
+//	kekIDLen    1 byte
+//	kekID       kekIDLen bytes
+//	wrappedLen  2 bytes, little endian
+//	wrapped     wrappedLen bytes
+//	envelope    XChaCha20-Poly1305 envelope of the plainText
+//
+// Everything before the envelope is authenticated as its associated
+// data, so the wrapped key can't be swapped between objects.
+func EnvelopeEncrypt(p KeyEncryptionKeyProvider, plainText []byte) ([]byte, error) {
+	return EnvelopeEncryptWithAAD(p, plainText, nil)
+}
+
+// EnvelopeEncryptWithAAD works like EnvelopeEncrypt and additionally
+// binds the data to the additionalData, which is authenticated
+// after the header but not stored.
+func EnvelopeEncryptWithAAD(p KeyEncryptionKeyProvider, plainText []byte, additionalData []byte) ([]byte, error) {
+	dek, err := util.RandomBytes(KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(dek)
+
+	kekID, wrapped, err := p.WrapKey(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kekID) > MAX_KEY_ID_SIZE {
+		return nil, ErrKeyIDSize
+	}
+
+	if len(wrapped) > 0xffff {
+		return nil, ErrWrappedFormat
+	}
+
+	header := []byte(WRAPPED_MAGIC)
+	header = append(header, WRAPPED_VERSION, byte(len(kekID)))
+	header = append(header, kekID...)
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	c, err := (&Key{Bytes: dek}).NewCipher()
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	aad := append(append([]byte(nil), header...), additionalData...)
+
+	envelope, err := c.EncryptEnvelope(ALG_XCHACHA20_POLY1305, plainText, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header, envelope...), nil
+}
+
+// EnvelopeDecrypt unwraps the data key with the provider
+// and decrypts the data created by EnvelopeEncrypt.
+func EnvelopeDecrypt(p KeyEncryptionKeyProvider, data []byte) ([]byte, error) {
+	return EnvelopeDecryptWithAAD(p, data, nil)
+}
+
+// EnvelopeDecryptWithAAD decrypts the data created by
+// EnvelopeEncryptWithAAD with the same additionalData.
+func EnvelopeDecryptWithAAD(p KeyEncryptionKeyProvider, data []byte, additionalData []byte) ([]byte, error) {
+	kekID, wrapped, header, err := parseWrappedHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// EnvelopeEncrypt only writes XChaCha20-Poly1305,
+	// anything else was relabeled to skip the tag check.
+	e, err := ParseEnvelope(data[len(header):])
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Algorithm != ALG_XCHACHA20_POLY1305 {
+		return nil, ErrAlgorithm
+	}
+
+	dek, err := p.UnwrapKey(kekID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(dek)
+
+	c, err := (&Key{Bytes: dek}).NewCipher()
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	aad := append(append([]byte(nil), header...), additionalData...)
+
+	return c.DecryptEnvelope(data[len(header):], aad)
+}
+
+// ParseWrappedHeader splits the EnvelopeEncrypt header.
+func parseWrappedHeader(data []byte) (string, []byte, []byte, error) {
+	fixedSize := len(WRAPPED_MAGIC) + 2
+
+	if len(data) < fixedSize || string(data[:len(WRAPPED_MAGIC)]) != WRAPPED_MAGIC {
+		return "", nil, nil, ErrWrappedFormat
+	}
+
+	if data[len(WRAPPED_MAGIC)] != WRAPPED_VERSION {
+		return "", nil, nil, ErrEnvelopeVersion
+	}
+
+	kekIDSize := int(data[len(WRAPPED_MAGIC)+1])
+	if len(data) < fixedSize+kekIDSize+2 {
+		return "", nil, nil, ErrWrappedFormat
+	}
+
+	kekID := string(data[fixedSize : fixedSize+kekIDSize])
+	offset := fixedSize + kekIDSize
+
+	wrappedSize := int(binary.LittleEndian.Uint16(data[offset:]))
+	offset += 2
+
+	if len(data) < offset+wrappedSize {
+		return "", nil, nil, ErrWrappedFormat
+	}
+
+	wrapped := data[offset : offset+wrappedSize]
+
+	return kekID, wrapped, data[:offset+wrappedSize], nil
+}
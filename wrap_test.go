@@ -0,0 +1,187 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	kek, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dek := bytes.Repeat([]byte{0x42}, KEY_SIZE)
+
+	wrapped, err := WrapKey(kek, dek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wrapped) != WRAPPED_KEY_SIZE {
+		t.Fatalf("expected %d bytes, found %d", WRAPPED_KEY_SIZE, len(wrapped))
+	}
+
+	unwrapped, err := UnwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unwrapped, dek) {
+		t.Fatalf("unwrapped key differs")
+	}
+
+	if _, err := UnwrapKey(other, wrapped); err != ErrUnwrap {
+		t.Fatalf("expected ErrUnwrap for a different KEK, found %v", err)
+	}
+
+	if _, err := UnwrapKey(kek, wrapped[1:]); err != ErrWrappedFormat {
+		t.Fatalf("expected ErrWrappedFormat, found %v", err)
+	}
+
+	if _, err := WrapKey(kek, dek[1:]); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, found %v", err)
+	}
+}
+
+func TestEnvelopeEncrypt(t *testing.T) {
+	kek, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr, err := NewKeyring(kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &LocalKeyProvider{Keyring: kr}
+
+	first, err := EnvelopeEncrypt(p, []byte("object one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating the KEK must not break existing objects.
+	if _, err := kr.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := EnvelopeEncrypt(p, []byte("object two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for data, expected := range map[*[]byte]string{&first: "object one", &second: "object two"} {
+		plainText, err := EnvelopeDecrypt(p, *data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(plainText) != expected {
+			t.Fatalf("expected %q, found %q", expected, plainText)
+		}
+	}
+
+	// The wrapped key of one object can't be used with the body of another.
+	_, _, firstHeader, _ := parseWrappedHeader(first)
+	_, _, secondHeader, _ := parseWrappedHeader(second)
+	swapped := append(append([]byte(nil), firstHeader...), second[len(secondHeader):]...)
+
+	if _, err := EnvelopeDecrypt(p, swapped); err != ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed for swapped wrapped keys, found %v", err)
+	}
+
+	if _, err := EnvelopeDecrypt(p, first[:len(firstHeader)-1]); err != ErrWrappedFormat {
+		t.Fatalf("expected ErrWrappedFormat for truncated data, found %v", err)
+	}
+
+	// Envelopes relabeled to an unauthenticated algorithm are rejected.
+	e, err := ParseEnvelope(first[len(firstHeader):])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Algorithm = ALG_XCHACHA20
+	e.Tag = nil
+
+	relabeled, err := e.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downgraded := append(append([]byte(nil), firstHeader...), relabeled...)
+	for _, aad := range [][]byte{nil, []byte("aad")} {
+		if _, err := EnvelopeDecryptWithAAD(p, downgraded, aad); err != ErrAlgorithm {
+			t.Fatalf("expected ErrAlgorithm for a relabeled envelope, found %v", err)
+		}
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	kek, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := SaveKey(path, kek, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewFileKeyProvider([]string{path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var provider KeyEncryptionKeyProvider = p
+
+	data, err := EnvelopeEncrypt(provider, []byte("stored in the object store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kekID, _, _, err := parseWrappedHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kekID != kek.ID {
+		t.Fatalf("expected KEK ID %q, found %q", kek.ID, kekID)
+	}
+
+	plainText, err := EnvelopeDecrypt(provider, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(plainText) != "stored in the object store" {
+		t.Fatalf("unexpected plaintext %q", plainText)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wedkarz02/chacha20/internal/testutil"
+)
+
+// Wycheproof AEAD test vector file (aead_test_schema_v1.json).
+//
+// https://github.com/C2SP/wycheproof
+type wycheproofAEADFile struct {
+	Algorithm     string `json:"algorithm"`
+	NumberOfTests int    `json:"numberOfTests"`
+	TestGroups    []struct {
+		IVSize int `json:"ivSize"`
+		Tests  []struct {
+			TcID    int      `json:"tcId"`
+			Comment string   `json:"comment"`
+			Flags   []string `json:"flags"`
+			Key     string   `json:"key"`
+			IV      string   `json:"iv"`
+			AAD     string   `json:"aad"`
+			Msg     string   `json:"msg"`
+			CT      string   `json:"ct"`
+			Tag     string   `json:"tag"`
+			Result  string   `json:"result"`
+		} `json:"tests"`
+	} `json:"testGroups"`
+}
+
+type aeadFunc func(key, nonce, data, additionalData []byte) ([]byte, error)
+
+func TestWycheproof(t *testing.T) {
+	testFiles := []struct {
+		name string
+		seal aeadFunc
+		open aeadFunc
+	}{
+		{"chacha20_poly1305_test.json", sealChaCha20Poly1305, openChaCha20Poly1305},
+		{"xchacha20_poly1305_test.json", sealXChaCha20Poly1305, openXChaCha20Poly1305},
+	}
+
+	for _, tf := range testFiles {
+		t.Run(tf.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "wycheproof", tf.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var f wycheproofAEADFile
+			if err := json.Unmarshal(data, &f); err != nil {
+				t.Fatal(err)
+			}
+
+			count := 0
+
+			for _, group := range f.TestGroups {
+				for _, tc := range group.Tests {
+					count++
+
+					key := testutil.DecodeHex(t, tc.Key)
+					nonce := testutil.DecodeHex(t, tc.IV)
+					aad := testutil.DecodeHex(t, tc.AAD)
+					msg := testutil.DecodeHex(t, tc.Msg)
+					sealed := append(testutil.DecodeHex(t, tc.CT), testutil.DecodeHex(t, tc.Tag)...)
+
+					opened, err := tf.open(key, nonce, sealed, aad)
+
+					switch tc.Result {
+					case "valid":
+						if err != nil {
+							t.Fatalf("tcId %d (%s): open failed: %v", tc.TcID, tc.Comment, err)
+						}
+
+						if !bytes.Equal(opened, msg) {
+							t.Fatalf("tcId %d (%s): plaintext mismatch", tc.TcID, tc.Comment)
+						}
+
+						actual, err := tf.seal(key, nonce, msg, aad)
+						if err != nil {
+							t.Fatalf("tcId %d (%s): seal failed: %v", tc.TcID, tc.Comment, err)
+						}
+
+						if !bytes.Equal(actual, sealed) {
+							t.Fatalf("tcId %d (%s): expected %x, found %x", tc.TcID, tc.Comment, sealed, actual)
+						}
+					case "invalid":
+						if err == nil {
+							t.Fatalf("tcId %d (%s, %v): invalid ciphertext accepted", tc.TcID, tc.Comment, tc.Flags)
+						}
+					default:
+						t.Fatalf("tcId %d: unexpected result %q", tc.TcID, tc.Result)
+					}
+				}
+			}
+
+			if count != f.NumberOfTests {
+				t.Fatalf("expected %d tests, ran %d", f.NumberOfTests, count)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2023 Paweł Rybak
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package chacha20
+
+import (
+	"encoding/binary"
+
+	"github.com/wedkarz02/chacha20/pkg/util"
+)
+
+const (
+	// Size of the nonce in the 192-bit XChaCha20 variant.
+	XNONCE_SIZE = 24
+
+	// Size of the HChaCha20 nonce input.
+	HNONCE_SIZE = 16
+)
+
+// HChaCha20 derives a 256-bit subkey from the key and
+// the first 128 bits of the extended nonce.
+//
+// The state is set up like in ChaCha20, except that the counter
+// and nonce words are replaced by the 128-bit input. After 20 rounds
+// the first and the last row are returned without adding the initial state.
+//
+// https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha#section-2.2
+func hChaCha20(key []byte, nonce []byte) ([]byte, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrKeySize
+	}
+
+	if len(nonce) != HNONCE_SIZE {
+		return nil, ErrNonceSize
+	}
+
+	var c Cipher
+
+	c.state[0] = CONSTANT_0
+	c.state[1] = CONSTANT_1
+	c.state[2] = CONSTANT_2
+	c.state[3] = CONSTANT_3
+
+	for i := 0; i < 8; i++ {
+		c.state[i+4] = binary.LittleEndian.Uint32(key[i*4 : (i+1)*4])
+	}
+
+	for i := 0; i < 4; i++ {
+		c.state[i+12] = binary.LittleEndian.Uint32(nonce[i*4 : (i+1)*4])
+	}
+
+	c.rounds()
+
+	subKey := make([]byte, KEY_SIZE)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(subKey[i*4:(i+1)*4], c.state[i])
+		binary.LittleEndian.PutUint32(subKey[(i+4)*4:(i+5)*4], c.state[i+12])
+	}
+
+	return subKey, nil
+}
+
+// XChaCha20Params converts the key and the 192-bit nonce into
+// the HChaCha20 subkey and the 96-bit ChaCha20 nonce.
+// The ChaCha20 nonce is 4 zero bytes followed by
+// the last 64 bits of the extended nonce.
+func xChaCha20Params(key []byte, nonce []byte) ([]byte, []byte, error) {
+	if len(nonce) != XNONCE_SIZE {
+		return nil, nil, ErrNonceSize
+	}
+
+	subKey, err := hChaCha20(key, nonce[:HNONCE_SIZE])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subNonce := make([]byte, NONCE_SIZE)
+	copy(subNonce[4:], nonce[HNONCE_SIZE:])
+
+	return subKey, subNonce, nil
+}
+
+// XorXChaCha20 encrypts/decrypts the data with XChaCha20.
+// Like in Encrypt, the counter starts at INITIAL_CTR.
+func xorXChaCha20(key, nonce, data []byte) ([]byte, error) {
+	subKey, subNonce, err := xChaCha20Params(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newRawCipher(subKey, subNonce)
+	util.Wipe(subKey)
+	if err != nil {
+		return nil, err
+	}
+	defer c.ClearKey()
+
+	return c.encryptionCore(data)
+}
+
+// SealXChaCha20Poly1305 encrypts and authenticates the plainText
+// with AEAD_XChaCha20_Poly1305.
+//
+// https://datatracker.ietf.org/doc/html/draft-irtf-cfrg-xchacha#section-2
+func sealXChaCha20Poly1305(key, nonce, plainText, additionalData []byte) ([]byte, error) {
+	subKey, subNonce, err := xChaCha20Params(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(subKey)
+
+	return sealChaCha20Poly1305(subKey, subNonce, plainText, additionalData)
+}
+
+// OpenXChaCha20Poly1305 verifies and decrypts the cipherText
+// followed by the tag created by sealXChaCha20Poly1305.
+func openXChaCha20Poly1305(key, nonce, cipherText, additionalData []byte) ([]byte, error) {
+	subKey, subNonce, err := xChaCha20Params(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Wipe(subKey)
+
+	return openChaCha20Poly1305(subKey, subNonce, cipherText, additionalData)
+}